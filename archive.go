@@ -0,0 +1,416 @@
+package ftdc
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ArchiveFS is a read-only file system backed by an archive, that
+// must be closed to release the underlying file.
+type ArchiveFS interface {
+	fs.FS
+	io.Closer
+}
+
+// OpenArchive opens a tar, gzip-compressed tar, or zip archive, such
+// as a diagnostic bundle, as a file system, so that the FTDC files in
+// the archive can be read without extracting them, as in:
+//
+//	archive, err := ftdc.OpenArchive("diagnostics.tar.gz")
+//	if err != nil {
+//	    return err
+//	}
+//	defer archive.Close()
+//
+//	iter := ftdc.ReadDirectoryFS(ctx, archive, "diagnostic.data", ftdc.ReadOptions{})
+//
+// Individual files can be read by passing the result of the archive's
+// Open method to any of the Read* functions. The format of the archive
+// is determined from its contents, rather than its name.
+func OpenArchive(name string) (ArchiveFS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	fsys, err := newArchiveFS(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "problem opening archive '%s'", name)
+	}
+
+	return &archiveFile{FS: fsys, Closer: f}, nil
+}
+
+type archiveFile struct {
+	fs.FS
+	io.Closer
+}
+
+func newArchiveFS(r io.ReaderAt, size int64) (fs.FS, error) {
+	header := make([]byte, 512)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, errors.WithStack(err)
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return NewZipFS(r, size)
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return NewTarGzipFS(r, size)
+	case len(header) == 512 && bytes.HasPrefix(header[257:], []byte("ustar")):
+		return NewTarFS(r, size)
+	default:
+		return nil, errors.New("unrecognized archive format")
+	}
+}
+
+// NewZipFS returns a file system for the contents of a zip archive.
+func NewZipFS(r io.ReaderAt, size int64) (fs.FS, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "problem reading zip archive")
+	}
+
+	return archive, nil
+}
+
+// NewTarFS returns a file system for the contents of a tar
+// archive. The archive is indexed when the file system is created,
+// and files are read directly from the underlying reader when they're
+// opened.
+func NewTarFS(r io.ReaderAt, size int64) (fs.FS, error) {
+	counter := &countingReader{r: io.NewSectionReader(r, 0, size)}
+	archive := newArchiveIndex()
+
+	err := archive.scan(tar.NewReader(counter), func(hdr *tar.Header) func() (io.ReadCloser, error) {
+		section := io.NewSectionReader(r, counter.n, hdr.Size)
+		return func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(section, 0, hdr.Size)), nil
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "problem reading tar archive")
+	}
+
+	return archive, nil
+}
+
+// NewTarGzipFS returns a file system for the contents of a
+// gzip-compressed tar archive. The archive is indexed when the file
+// system is created. Because compressed archives can't be read at
+// arbitrary offsets, opening a file decompresses the archive up to
+// the file; reading files in the order that they appear in the
+// archive continues from the previous file, to avoid decompressing
+// the archive more than once.
+func NewTarGzipFS(r io.ReaderAt, size int64) (fs.FS, error) {
+	cursors := &tarGzipCursors{r: r, size: size}
+	cursor, err := cursors.start()
+	if err != nil {
+		return nil, errors.Wrap(err, "problem reading gzip archive")
+	}
+
+	archive := newArchiveIndex()
+	position := 0
+	err = archive.scan(cursor.tar, func(*tar.Header) func() (io.ReadCloser, error) {
+		position++
+		target := position
+		return func() (io.ReadCloser, error) { return cursors.open(target) }
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "problem reading tar archive")
+	}
+
+	return archive, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// tarGzipCursors tracks a decompressed reader, positioned after the
+// most recently read file in a gzip-compressed tar archive, so that
+// files can be opened in sequence without decompressing the archive
+// from the beginning for every file.
+type tarGzipCursors struct {
+	r    io.ReaderAt
+	size int64
+	mu   sync.Mutex
+	idle *tarGzipCursor
+}
+
+type tarGzipCursor struct {
+	tar *tar.Reader
+	// position is the number of entries that the tar reader has
+	// read headers for.
+	position int
+}
+
+func (c *tarGzipCursors) start() (*tarGzipCursor, error) {
+	gz, err := gzip.NewReader(io.NewSectionReader(c.r, 0, c.size))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &tarGzipCursor{tar: tar.NewReader(gz)}, nil
+}
+
+// open returns a reader for the target'th entry in the archive,
+// counting from one.
+func (c *tarGzipCursors) open(target int) (io.ReadCloser, error) {
+	c.mu.Lock()
+	cursor := c.idle
+	c.idle = nil
+	c.mu.Unlock()
+
+	if cursor == nil || cursor.position >= target {
+		var err error
+		if cursor, err = c.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	for cursor.position < target {
+		if _, err := cursor.tar.Next(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, errors.WithStack(err)
+		}
+		cursor.position++
+	}
+
+	return &tarGzipEntry{Reader: cursor.tar, cursors: c, cursor: cursor}, nil
+}
+
+type tarGzipEntry struct {
+	io.Reader
+	cursors *tarGzipCursors
+	cursor  *tarGzipCursor
+}
+
+// Close makes the cursor available to the next file that's opened.
+func (e *tarGzipEntry) Close() error {
+	if e.cursor == nil {
+		return nil
+	}
+
+	e.cursors.mu.Lock()
+	e.cursors.idle = e.cursor
+	e.cursors.mu.Unlock()
+	e.cursor = nil
+
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////
+//
+// fs.FS implementation for tar archives.
+
+type archiveIndex struct {
+	files map[string]*archiveEntry
+}
+
+type archiveEntry struct {
+	info     fs.FileInfo
+	open     func() (io.ReadCloser, error)
+	children []fs.DirEntry
+}
+
+func newArchiveIndex() *archiveIndex {
+	return &archiveIndex{
+		files: map[string]*archiveEntry{
+			".": {info: &archiveDirInfo{name: "."}},
+		},
+	}
+}
+
+// scan reads the headers of every entry in the archive, and records
+// the regular files and directories. The opener function is called
+// for every entry, in order, and returns a function to open the
+// contents of the entry.
+func (a *archiveIndex) scan(tr *tar.Reader, opener func(*tar.Header) func() (io.ReadCloser, error)) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		open := opener(hdr)
+
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == "" || !fs.ValidPath(name) {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			a.add(name, &archiveEntry{info: hdr.FileInfo(), open: open})
+		case tar.TypeDir:
+			a.mkdir(name)
+		}
+	}
+
+	for _, entry := range a.files {
+		sort.Slice(entry.children, func(i, j int) bool { return entry.children[i].Name() < entry.children[j].Name() })
+	}
+
+	return nil
+}
+
+func (a *archiveIndex) add(name string, entry *archiveEntry) {
+	parent := a.mkdir(path.Dir(name))
+
+	if existing, ok := a.files[name]; ok {
+		// later entries replace earlier ones, as when
+		// extracting the archive, both in the index and in the
+		// listing of the parent directory.
+		existing.info = entry.info
+		existing.open = entry.open
+		for idx := range parent.children {
+			if parent.children[idx].Name() == entry.info.Name() {
+				parent.children[idx] = fs.FileInfoToDirEntry(entry.info)
+			}
+		}
+		return
+	}
+
+	a.files[name] = entry
+	parent.children = append(parent.children, fs.FileInfoToDirEntry(entry.info))
+}
+
+func (a *archiveIndex) mkdir(name string) *archiveEntry {
+	if entry, ok := a.files[name]; ok {
+		return entry
+	}
+
+	entry := &archiveEntry{info: &archiveDirInfo{name: path.Base(name)}}
+	parent := a.mkdir(path.Dir(name))
+	a.files[name] = entry
+	parent.children = append(parent.children, fs.FileInfoToDirEntry(entry.info))
+
+	return entry
+}
+
+func (a *archiveIndex) lookup(op, name string) (*archiveEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry, ok := a.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return entry, nil
+}
+
+func (a *archiveIndex) Open(name string) (fs.File, error) {
+	entry, err := a.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.open == nil {
+		return &archiveDir{entry: entry}, nil
+	}
+
+	r, err := entry.open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &archiveOpenFile{ReadCloser: r, info: entry.info}, nil
+}
+
+func (a *archiveIndex) ReadDir(name string) ([]fs.DirEntry, error) {
+	entry, err := a.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.open != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	out := make([]fs.DirEntry, len(entry.children))
+	copy(out, entry.children)
+
+	return out, nil
+}
+
+type archiveOpenFile struct {
+	io.ReadCloser
+	info fs.FileInfo
+}
+
+func (f *archiveOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+type archiveDir struct {
+	entry  *archiveEntry
+	offset int
+}
+
+func (d *archiveDir) Stat() (fs.FileInfo, error) { return d.entry.info, nil }
+func (d *archiveDir) Close() error               { return nil }
+func (d *archiveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *archiveDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := d.entry.children[d.offset:]
+	if count > 0 && len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > 0 && count < len(remaining) {
+		remaining = remaining[:count]
+	}
+	d.offset += len(remaining)
+
+	out := make([]fs.DirEntry, len(remaining))
+	copy(out, remaining)
+
+	return out, nil
+}
+
+type archiveDirInfo struct {
+	name string
+}
+
+func (i *archiveDirInfo) Name() string       { return i.name }
+func (i *archiveDirInfo) Size() int64        { return 0 }
+func (i *archiveDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (i *archiveDirInfo) ModTime() time.Time { return time.Time{} }
+func (i *archiveDirInfo) IsDir() bool        { return true }
+func (i *archiveDirInfo) Sys() interface{}   { return nil }
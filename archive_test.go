@@ -0,0 +1,165 @@
+package ftdc
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{name: "diagnostic.data/metrics.2020-01-01T00-00-20Z-00000", data: produceTimedPayload(t, start.Add(20*time.Second), 20, 10)},
+		{name: "diagnostic.data/metrics.2020-01-01T00-00-00Z-00000", data: produceTimedPayload(t, start, 20, 10)},
+		{name: "diagnostic.data/metrics.interim", data: produceTimedPayload(t, start.Add(35*time.Second), 10, 10)},
+		{name: "mongod.log", data: []byte("log")},
+	}
+
+	writeTar := func(w io.Writer) {
+		tw := tar.NewWriter(w)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "diagnostic.data/", Typeflag: tar.TypeDir, Mode: 0755}))
+		for _, file := range files {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: file.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(file.data))}))
+			_, err := tw.Write(file.data)
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+	}
+
+	archives := map[string]func(io.Writer){
+		"Tar": writeTar,
+		"TarGzip": func(w io.Writer) {
+			gz := gzip.NewWriter(w)
+			writeTar(gz)
+			require.NoError(t, gz.Close())
+		},
+		"Zip": func(w io.Writer) {
+			zw := zip.NewWriter(w)
+			for _, file := range files {
+				fw, err := zw.Create(file.name)
+				require.NoError(t, err)
+				_, err = fw.Write(file.data)
+				require.NoError(t, err)
+			}
+			require.NoError(t, zw.Close())
+		},
+	}
+
+	dir := t.TempDir()
+	for name, write := range archives {
+		t.Run(name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			write(buf)
+			fn := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(fn, buf.Bytes(), 0644))
+
+			archive, err := OpenArchive(fn)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, archive.Close()) }()
+
+			if name != "Zip" {
+				expected := []string{"mongod.log"}
+				for _, file := range files[:3] {
+					expected = append(expected, file.name)
+				}
+				require.NoError(t, fstest.TestFS(archive, expected...))
+			}
+
+			t.Run("Directory", func(t *testing.T) {
+				iter := ReadDirectoryFS(ctx, archive, "diagnostic.data", ReadOptions{})
+				defer iter.Close()
+				count := 0
+				for iter.Next() {
+					for _, ts := range iter.Chunk().Metrics[0].Values {
+						assert.Equal(t, start.Add(time.Duration(count)*time.Second), timeEpocMs(ts).UTC())
+						count++
+					}
+				}
+				require.NoError(t, iter.Err())
+				assert.Equal(t, 45, count)
+			})
+			t.Run("Metrics", func(t *testing.T) {
+				iter := ReadDirectoryMetricsFS(ctx, archive, "diagnostic.data", ReadOptions{Start: start.Add(30 * time.Second)})
+				defer iter.Close()
+				count := 0
+				for iter.Next() {
+					count++
+				}
+				require.NoError(t, iter.Err())
+				assert.Equal(t, 15, count)
+			})
+			t.Run("File", func(t *testing.T) {
+				for _, file := range files[:3] {
+					f, err := archive.Open(file.name)
+					require.NoError(t, err)
+
+					iter := ReadChunks(ctx, f)
+					count := 0
+					for iter.Next() {
+						count += iter.Chunk().Size()
+					}
+					assert.NoError(t, iter.Err())
+					assert.NotZero(t, count)
+					iter.Close()
+					require.NoError(t, f.Close())
+				}
+			})
+			t.Run("Missing", func(t *testing.T) {
+				_, err := archive.Open("diagnostic.data/metrics.missing")
+				assert.ErrorIs(t, err, os.ErrNotExist)
+			})
+		})
+	}
+	t.Run("DuplicateEntries", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		for _, data := range []string{"old", "newer"} {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: "diagnostic.data/metrics.interim", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))}))
+			_, err := tw.Write([]byte(data))
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+
+		archive, err := NewTarFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+		require.NoError(t, fstest.TestFS(archive, "diagnostic.data/metrics.interim"))
+
+		data, err := fs.ReadFile(archive, "diagnostic.data/metrics.interim")
+		require.NoError(t, err)
+		assert.Equal(t, "newer", string(data))
+
+		entries, err := fs.ReadDir(archive, "diagnostic.data")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		info, err := entries[0].Info()
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), info.Size())
+	})
+	t.Run("Unrecognized", func(t *testing.T) {
+		fn := filepath.Join(dir, "unrecognized")
+		require.NoError(t, os.WriteFile(fn, []byte("not an archive"), 0644))
+		_, err := OpenArchive(fn)
+		assert.Error(t, err)
+
+		_, err = OpenArchive(filepath.Join(dir, "missing"))
+		assert.Error(t, err)
+	})
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-type metricHashFunc func(*birch.Document) (string, int)
+type metricHashFunc func(*birch.Document, CollectorOptions) (string, int)
 
 func BenchmarkHashBSON(b *testing.B) {
 	for _, impl := range []struct {
@@ -62,7 +62,7 @@ func BenchmarkHashBSON(b *testing.B) {
 						num int
 					)
 					for n := 0; n < b.N; n++ {
-						h, num = impl.HashFunc(test.Doc)
+						h, num = impl.HashFunc(test.Doc, CollectorOptions{})
 					}
 					b.StopTimer()
 					assert.NotZero(b, num)
@@ -123,7 +123,7 @@ func BenchmarkDocumentCreation(b *testing.B) {
 		b.Run(test.Name, func(b *testing.B) {
 			for n := 0; n < b.N; n++ {
 				for i := 0; i < test.Samples; i++ {
-					doc, _ = restoreDocument(test.Reference, i, test.Metrics, 0)
+					doc, _ = restoreDocument(test.Reference, i, test.Metrics, 0, false)
 					require.NotNil(b, doc)
 					require.Equal(b, test.Length, doc.Len())
 				}
@@ -19,7 +19,18 @@ type extractedMetrics struct {
 	ts     time.Time
 }
 
-func extractMetricsFromDocument(doc *birch.Document) (extractedMetrics, error) {
+// isDecimalOrNullType reports if values of the type are only recorded
+// as metrics with the DecimalAndNullValues option.
+func isDecimalOrNullType(btype bsontype.Type) bool {
+	switch btype {
+	case bsontype.Decimal128, bsontype.Null, bsontype.Undefined:
+		return true
+	default:
+		return false
+	}
+}
+
+func extractMetricsFromDocument(doc *birch.Document, opts CollectorOptions) (extractedMetrics, error) {
 	metrics := extractedMetrics{}
 	iter := doc.Iterator()
 
@@ -31,7 +42,7 @@ func extractMetricsFromDocument(doc *birch.Document) (extractedMetrics, error) {
 	catcher := util.NewCatcher()
 
 	for iter.Next() {
-		data, err = extractMetricsFromValue(iter.Element().Value())
+		data, err = extractMetricsFromValue(iter.Element().Value(), opts)
 		catcher.Add(err)
 		metrics.values = append(metrics.values, data.values...)
 		metrics.types = append(metrics.types, data.types...)
@@ -50,7 +61,7 @@ func extractMetricsFromDocument(doc *birch.Document) (extractedMetrics, error) {
 	return metrics, catcher.Resolve()
 }
 
-func extractMetricsFromArray(array *birch.Array) (extractedMetrics, error) {
+func extractMetricsFromArray(array *birch.Array, opts CollectorOptions) (extractedMetrics, error) {
 	metrics := extractedMetrics{}
 
 	var (
@@ -62,7 +73,7 @@ func extractMetricsFromArray(array *birch.Array) (extractedMetrics, error) {
 	iter := array.Iterator()
 
 	for iter.Next() {
-		data, err = extractMetricsFromValue(iter.Value())
+		data, err = extractMetricsFromValue(iter.Value(), opts)
 		catcher.Add(err)
 		metrics.values = append(metrics.values, data.values...)
 		metrics.types = append(metrics.types, data.types...)
@@ -77,17 +88,40 @@ func extractMetricsFromArray(array *birch.Array) (extractedMetrics, error) {
 	return metrics, catcher.Resolve()
 }
 
-func extractMetricsFromValue(val *birch.Value) (extractedMetrics, error) {
+func extractMetricsFromValue(val *birch.Value, opts CollectorOptions) (extractedMetrics, error) {
 	metrics := extractedMetrics{}
 	var err error
 
 	btype := val.Type()
+	if isDecimalOrNullType(btype) && !opts.DecimalAndNullValues {
+		return metrics, nil
+	}
+
 	switch btype {
 	case bsontype.Array:
-		metrics, err = extractMetricsFromArray(val.MutableArray())
+		metrics, err = extractMetricsFromArray(val.MutableArray(), opts)
 		err = errors.WithStack(err)
 	case bsontype.EmbeddedDocument:
-		metrics, err = extractMetricsFromDocument(val.MutableDocument())
+		// times at nanosecond precision and unsigned integers
+		// are date-time and int64 metrics, but keep the type
+		// of the marker document, so that the metrics are never
+		// widened, and changing between these and the plain
+		// types is a schema change.
+		doc := val.MutableDocument()
+		if ns, ok := nanosecondTimeFromDocument(doc); ok {
+			metrics.values = append(metrics.values, birch.VC.Int64(ns))
+			metrics.types = append(metrics.types, bsontype.EmbeddedDocument)
+			metrics.ts = time.Unix(0, ns)
+			break
+		}
+
+		if value, ok := unsignedIntegerFromDocument(doc); ok {
+			metrics.values = append(metrics.values, birch.VC.Int64(value))
+			metrics.types = append(metrics.types, bsontype.EmbeddedDocument)
+			break
+		}
+
+		metrics, err = extractMetricsFromDocument(doc, opts)
 		err = errors.WithStack(err)
 	case bsontype.Boolean:
 		if val.Boolean() {
@@ -113,11 +147,31 @@ func extractMetricsFromValue(val *birch.Value) (extractedMetrics, error) {
 		t, i := val.Timestamp()
 		metrics.values = append(metrics.values, birch.VC.Int64(int64(t)), birch.VC.Int64(int64(i)))
 		metrics.types = append(metrics.types, bsontype.Timestamp, bsontype.Timestamp)
+	case bsontype.Decimal128:
+		// decimals are recorded as the closest double, and
+		// restored as decimals.
+		metrics.values = append(metrics.values, birch.VC.Double(decimalToFloat(decimalValue(val))))
+		metrics.types = append(metrics.types, bsontype.Decimal128)
+	case bsontype.Null, bsontype.Undefined:
+		// null values are recorded as zero, so that the field
+		// keeps its place in the schema.
+		metrics.values = append(metrics.values, birch.VC.Int64(0))
+		metrics.types = append(metrics.types, btype)
 	}
 
 	return metrics, err
 }
 
+// metricValue returns an extracted metric value as it is recorded in
+// Metric.Values.
+func metricValue(val *birch.Value) int64 {
+	if val.Type() == bsontype.Double {
+		return normalizeFloat(val.Double())
+	}
+
+	return val.Int64()
+}
+
 func extractDelta(current *birch.Value, previous *birch.Value) (int64, error) {
 	switch current.Type() {
 	case bsontype.Double:
@@ -9,41 +9,41 @@ import (
 	"github.com/evergreen-ci/birch/bsontype"
 )
 
-func metricKeyHash(doc *birch.Document) (string, int) {
+func metricKeyHash(doc *birch.Document, opts CollectorOptions) (string, int) {
 	checksum := fnv.New64()
-	seen := metricKeyHashDocument(checksum, "", doc)
+	seen := metricKeyHashDocument(checksum, "", doc, opts)
 	return fmt.Sprintf("%x", checksum.Sum(nil)), seen
 }
 
-func metricKeyHashDocument(checksum hash.Hash, key string, doc *birch.Document) int {
+func metricKeyHashDocument(checksum hash.Hash, key string, doc *birch.Document, opts CollectorOptions) int {
 	iter := doc.Iterator()
 	seen := 0
 	for iter.Next() {
 		elem := iter.Element()
-		seen += metricKeyHashValue(checksum, fmt.Sprintf("%s.%s", key, elem.Key()), elem.Value())
+		seen += metricKeyHashValue(checksum, fmt.Sprintf("%s.%s", key, elem.Key()), elem.Value(), opts)
 	}
 
 	return seen
 }
 
-func metricKeyHashArray(checksum hash.Hash, key string, array *birch.Array) int {
+func metricKeyHashArray(checksum hash.Hash, key string, array *birch.Array, opts CollectorOptions) int {
 	seen := 0
 	iter := array.Iterator()
 	idx := 0
 	for iter.Next() {
-		seen += metricKeyHashValue(checksum, fmt.Sprintf("%s.%d", key, idx), iter.Value())
+		seen += metricKeyHashValue(checksum, fmt.Sprintf("%s.%d", key, idx), iter.Value(), opts)
 		idx++
 	}
 
 	return seen
 }
 
-func metricKeyHashValue(checksum hash.Hash, key string, value *birch.Value) int {
+func metricKeyHashValue(checksum hash.Hash, key string, value *birch.Value, opts CollectorOptions) int {
 	switch value.Type() {
 	case bsontype.Array:
-		return metricKeyHashArray(checksum, key, value.MutableArray())
+		return metricKeyHashArray(checksum, key, value.MutableArray(), opts)
 	case bsontype.EmbeddedDocument:
-		return metricKeyHashDocument(checksum, key, value.MutableDocument())
+		return metricKeyHashDocument(checksum, key, value.MutableDocument(), opts)
 	case bsontype.Boolean:
 		_, _ = checksum.Write([]byte(key))
 		return 1
@@ -59,6 +59,17 @@ func metricKeyHashValue(checksum hash.Hash, key string, value *birch.Value) int
 	case bsontype.DateTime:
 		_, _ = checksum.Write([]byte(key))
 		return 1
+	case bsontype.Decimal128, bsontype.Null, bsontype.Undefined:
+		if !opts.DecimalAndNullValues {
+			return 0
+		}
+		// these values can't be widened, and are restored
+		// with the type in the reference document, so
+		// changing between these and other types is a schema
+		// change.
+		_, _ = checksum.Write([]byte(key))
+		_, _ = checksum.Write([]byte{byte(value.Type())})
+		return 1
 	case bsontype.Timestamp:
 		_, _ = checksum.Write([]byte(key))
 		return 2
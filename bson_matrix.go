@@ -29,6 +29,12 @@ func rehydrateMatrix(metrics []Metric, sample int) (*birch.Element, int, error)
 			array.AppendInterface(restoreFloat(p))
 		}
 	case bsontype.Int64:
+		if metrics[sample].encoding == encodingUnsigned {
+			for _, p := range metrics[sample].Values {
+				array.Append(birch.VC.Document(unsignedIntegerDocument(p)))
+			}
+			break
+		}
 		for _, p := range metrics[sample].Values {
 			array.AppendInterface(p)
 		}
@@ -37,8 +43,20 @@ func rehydrateMatrix(metrics []Metric, sample int) (*birch.Element, int, error)
 			array.AppendInterface(int32(p))
 		}
 	case bsontype.DateTime:
+		for _, ts := range metrics[sample].Times() {
+			array.Append(birch.VC.Time(ts))
+		}
+	case bsontype.Decimal128:
 		for _, p := range metrics[sample].Values {
-			array.AppendInterface(timeEpocMs(p))
+			array.Append(birch.VC.Decimal128(floatToDecimal(restoreFloat(p))))
+		}
+	case bsontype.Null:
+		for range metrics[sample].Values {
+			array.Append(birch.VC.Null())
+		}
+	case bsontype.Undefined:
+		for range metrics[sample].Values {
+			array.Append(birch.VC.Undefined())
 		}
 	case bsontype.Timestamp:
 		for idx, p := range metrics[sample].Values {
@@ -0,0 +1,229 @@
+package ftdc
+
+import (
+	"io"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
+	"github.com/pkg/errors"
+)
+
+////////////////////////////////////////////////////////////////////////
+//
+// Helpers for periodic metadata documents.
+//
+// Periodic metadata documents hold a document of documents, one for
+// each source of metadata (e.g. "getParameter"). When the metadata
+// changes, the server writes a document that contains every
+// top-level document, but only the fields within those documents
+// that changed. When the set of fields changes the server writes the
+// complete metadata and resets the counter to zero.
+
+func readPeriodicMetadata(doc *birch.Document, previous *PeriodicMetadata) (*PeriodicMetadata, error) {
+	out := &PeriodicMetadata{}
+	out.ID, _ = doc.Lookup("_id").TimeOK()
+
+	counter := doc.Lookup("counter")
+	if counter != nil {
+		switch counter.Type() {
+		case bsontype.Int32:
+			out.Counter = int64(counter.Int32())
+		case bsontype.Int64:
+			out.Counter = counter.Int64()
+		case bsontype.Double:
+			out.Counter = int64(counter.Double())
+		}
+	}
+
+	delta := doc.Lookup("doc")
+	if delta == nil || delta.Type() != bsontype.EmbeddedDocument {
+		return nil, errors.New("periodic metadata document is not populated")
+	}
+	out.Delta = delta.MutableDocument()
+
+	full := out.Delta
+	if out.Counter != 0 && previous != nil {
+		full = applyMetadataDelta(previous.full, out.Delta)
+	}
+
+	// birch documents cache decoded sub-documents when they're
+	// accessed, so documents that are shared with the consumers of
+	// the reader, which may run in other goroutines, must not be
+	// accessed when applying later deltas. The reader keeps a
+	// private copy of the complete metadata for that purpose.
+	data, err := full.MarshalBSON()
+	if err != nil {
+		return nil, errors.Wrap(err, "problem encoding periodic metadata")
+	}
+
+	if out.full, err = birch.ReadDocument(data); err != nil {
+		return nil, errors.Wrap(err, "problem decoding periodic metadata")
+	}
+
+	if out.Document, err = birch.ReadDocument(data); err != nil {
+		return nil, errors.Wrap(err, "problem decoding periodic metadata")
+	}
+
+	return out, nil
+}
+
+// applyMetadataDelta returns a new document with the fields in the
+// delta replacing the corresponding fields in the reference
+// document. Fields in top-level documents are replaced individually,
+// and all other fields are replaced as a whole.
+func applyMetadataDelta(reference, delta *birch.Document) *birch.Document {
+	out := reference.Copy()
+	iter := delta.Iterator()
+	for iter.Next() {
+		elem := iter.Element()
+		current := out.LookupElement(elem.Key())
+
+		if current == nil ||
+			elem.Value().Type() != bsontype.EmbeddedDocument ||
+			current.Value().Type() != bsontype.EmbeddedDocument {
+			out.Set(elem)
+			continue
+		}
+
+		sub := current.Value().MutableDocument().Copy()
+		subIter := elem.Value().MutableDocument().Iterator()
+		for subIter.Next() {
+			sub.Set(subIter.Element())
+		}
+		out.Set(birch.EC.SubDocument(elem.Key(), sub))
+	}
+
+	return out
+}
+
+// periodicMetadata tracks the periodic metadata that a collector has
+// recorded, to produce the periodic metadata documents for changes to
+// the metadata, as the server does.
+type periodicMetadata struct {
+	previous *birch.Document
+	counter  int64
+}
+
+// set records the metadata that a collector's SetPeriodicMetadata
+// receives, and passes the periodic metadata document for it, if the
+// metadata changed, to the add function, which holds it for the
+// collector's current chunk.
+func (p *periodicMetadata) set(in interface{}, add func(*birch.Document)) error {
+	doc, err := readDocument(in)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if update := p.update(doc, time.Now()); update != nil {
+		add(update)
+	}
+
+	return nil
+}
+
+// update records the metadata and returns the periodic metadata
+// document for it, or nil if the metadata has not changed. The
+// document holds the fields that changed, as a delta against the
+// previous metadata, unless the previous metadata had different
+// fields, in which case it holds the complete metadata and restarts
+// the counter.
+func (p *periodicMetadata) update(doc *birch.Document, ts time.Time) *birch.Document {
+	doc = doc.Copy()
+	delta, ok := metadataDelta(p.previous, doc)
+	switch {
+	case !ok:
+		p.counter = 0
+		delta = doc
+	case delta == nil:
+		return nil
+	default:
+		p.counter++
+	}
+
+	p.previous = doc
+
+	return birch.NewDocument(
+		birch.EC.Time("_id", ts),
+		birch.EC.Int32("type", 2),
+		birch.EC.Int64("counter", p.counter),
+		birch.EC.SubDocument("doc", delta),
+	)
+}
+
+// metadataDelta returns a document with the fields of the document
+// that differ from the reference document, for applyMetadataDelta,
+// or nil if there are no differences. Like the server, the delta
+// holds every top-level document, with only the fields that changed
+// within them. Returns false when the documents have different
+// fields, or the reference document is nil, as the delta can't
+// represent the change.
+func metadataDelta(reference, doc *birch.Document) (*birch.Document, bool) {
+	if reference == nil || !sameFields(reference, doc) {
+		return nil, false
+	}
+
+	delta := birch.DC.Make(doc.Len())
+	changed := false
+	for idx := 0; idx < doc.Len(); idx++ {
+		prev, elem := reference.ElementAt(uint(idx)), doc.ElementAt(uint(idx))
+		if elem.Value().Type() != bsontype.EmbeddedDocument {
+			if !elem.Value().Equal(prev.Value()) {
+				delta.Append(elem)
+				changed = true
+			}
+			continue
+		}
+
+		prevDoc, sub := prev.Value().MutableDocument(), elem.Value().MutableDocument()
+		if !sameFields(prevDoc, sub) {
+			return nil, false
+		}
+
+		subDelta := birch.DC.Make(0)
+		for i := 0; i < sub.Len(); i++ {
+			if field := sub.ElementAt(uint(i)); !field.Value().Equal(prevDoc.ElementAt(uint(i)).Value()) {
+				subDelta.Append(field)
+			}
+		}
+		changed = changed || subDelta.Len() > 0
+		delta.Append(birch.EC.SubDocument(elem.Key(), subDelta))
+	}
+
+	if !changed {
+		return nil, true
+	}
+
+	return delta, true
+}
+
+// sameFields returns true when the documents have the same keys, in
+// the same order, and documents in the same places.
+func sameFields(a, b *birch.Document) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+
+	for idx := 0; idx < a.Len(); idx++ {
+		ea, eb := a.ElementAt(uint(idx)), b.ElementAt(uint(idx))
+		if ea.Key() != eb.Key() {
+			return false
+		}
+		if (ea.Value().Type() == bsontype.EmbeddedDocument) != (eb.Value().Type() == bsontype.EmbeddedDocument) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writePeriodicMetadata writes the periodic metadata documents.
+func writePeriodicMetadata(w io.Writer, docs []*birch.Document) error {
+	for _, doc := range docs {
+		if _, err := doc.WriteTo(w); err != nil {
+			return errors.Wrap(err, "problem writing periodic metadata document")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,420 @@
+package ftdc
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeriodicMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	writeDoc := func(doc *birch.Document) {
+		_, err := doc.WriteTo(buf)
+		require.NoError(t, err)
+	}
+	writeChunk := func(offset int) {
+		collector := NewBaseCollector(10)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, collector.Add(birch.NewDocument(
+				birch.EC.Time("ts", start.Add(time.Duration(offset+i)*time.Second)),
+				birch.EC.Int64("counter", int64(offset+i)),
+			)))
+		}
+		out, err := collector.Resolve()
+		require.NoError(t, err)
+		_, err = buf.Write(out)
+		require.NoError(t, err)
+	}
+	writePeriodic := func(offset int, counter int64, doc *birch.Document) {
+		writeDoc(birch.NewDocument(
+			birch.EC.Time("_id", start.Add(time.Duration(offset)*time.Second)),
+			birch.EC.Int32("type", 2),
+			birch.EC.Int64("counter", counter),
+			birch.EC.SubDocument("doc", doc),
+		))
+	}
+
+	writeDoc(birch.NewDocument(
+		birch.EC.Time("_id", start),
+		birch.EC.Int32("type", 0),
+		birch.EC.SubDocumentFromElements("doc", birch.EC.String("host", "localhost")),
+	))
+	writePeriodic(0, 0, birch.NewDocument(
+		birch.EC.SubDocumentFromElements("getParameter",
+			birch.EC.Int32("a", 1),
+			birch.EC.Int32("b", 2),
+		),
+		birch.EC.SubDocumentFromElements("getCmdLineOpts",
+			birch.EC.String("config", "/etc/mongod.conf"),
+		),
+	))
+	writeChunk(0)
+	writePeriodic(10, 1, birch.NewDocument(
+		birch.EC.SubDocumentFromElements("getParameter", birch.EC.Int32("b", 3)),
+		birch.EC.SubDocumentFromElements("getCmdLineOpts"),
+	))
+	writePeriodic(15, 2, birch.NewDocument(
+		birch.EC.SubDocumentFromElements("getParameter", birch.EC.Int32("a", 4)),
+		birch.EC.SubDocumentFromElements("getCmdLineOpts"),
+	))
+	writeChunk(10)
+	writeChunk(20)
+	writePeriodic(30, 0, birch.NewDocument(
+		birch.EC.SubDocumentFromElements("getParameter", birch.EC.Int32("c", 5)),
+	))
+	writeChunk(30)
+	payload := buf.Bytes()
+
+	getParameter := func(doc *birch.Document) *birch.Document {
+		return doc.Lookup("getParameter").MutableDocument()
+	}
+
+	t.Run("Chunks", func(t *testing.T) {
+		iter := ReadChunks(ctx, bytes.NewBuffer(payload))
+		defer iter.Close()
+
+		chunks := []*Chunk{}
+		for iter.Next() {
+			chunks = append(chunks, iter.Chunk())
+		}
+		require.NoError(t, iter.Err())
+		require.Len(t, chunks, 4)
+
+		for _, chunk := range chunks {
+			require.NotNil(t, chunk.GetMetadata())
+			assert.Equal(t, "localhost", chunk.GetMetadata().Lookup("doc").MutableDocument().Lookup("host").StringValue())
+		}
+
+		updates := chunks[0].MetadataUpdates()
+		require.Len(t, updates, 1)
+		assert.EqualValues(t, 0, updates[0].Counter)
+		assert.Equal(t, start, updates[0].ID.UTC())
+		params := getParameter(chunks[0].GetPeriodicMetadata())
+		assert.EqualValues(t, 1, params.Lookup("a").Int32())
+		assert.EqualValues(t, 2, params.Lookup("b").Int32())
+
+		updates = chunks[1].MetadataUpdates()
+		require.Len(t, updates, 2)
+		assert.EqualValues(t, 1, updates[0].Counter)
+		assert.EqualValues(t, 2, updates[1].Counter)
+		assert.Equal(t, 1, getParameter(updates[0].Delta).Len())
+		assert.EqualValues(t, 3, getParameter(updates[0].Delta).Lookup("b").Int32())
+		assert.EqualValues(t, 1, getParameter(updates[0].Document).Lookup("a").Int32())
+		assert.EqualValues(t, 3, getParameter(updates[0].Document).Lookup("b").Int32())
+		params = getParameter(chunks[1].GetPeriodicMetadata())
+		assert.EqualValues(t, 4, params.Lookup("a").Int32())
+		assert.EqualValues(t, 3, params.Lookup("b").Int32())
+		assert.Equal(t, "/etc/mongod.conf", chunks[1].GetPeriodicMetadata().Lookup("getCmdLineOpts").MutableDocument().Lookup("config").StringValue())
+
+		assert.Empty(t, chunks[2].MetadataUpdates())
+		assert.Equal(t, chunks[1].GetPeriodicMetadata(), chunks[2].GetPeriodicMetadata())
+
+		require.Len(t, chunks[3].MetadataUpdates(), 1)
+		params = getParameter(chunks[3].GetPeriodicMetadata())
+		assert.Equal(t, 1, params.Len())
+		assert.EqualValues(t, 5, params.Lookup("c").Int32())
+		assert.Nil(t, chunks[3].GetPeriodicMetadata().Lookup("getCmdLineOpts"))
+	})
+	t.Run("TimeRangeCarriesUpdates", func(t *testing.T) {
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Start: start.Add(20 * time.Second)})
+		defer iter.Close()
+
+		require.True(t, iter.Next())
+		chunk := iter.Chunk()
+		assert.Len(t, chunk.MetadataUpdates(), 3)
+		assert.EqualValues(t, 4, getParameter(chunk.GetPeriodicMetadata()).Lookup("a").Int32())
+	})
+	t.Run("IteratorMetadata", func(t *testing.T) {
+		for name, iter := range map[string]Iterator{
+			"Metrics": ReadMetrics(ctx, bytes.NewBuffer(payload)),
+			"Matrix":  ReadMatrix(ctx, bytes.NewBuffer(payload)),
+		} {
+			t.Run(name, func(t *testing.T) {
+				defer iter.Close()
+				for iter.Next() {
+					metadata := iter.Metadata()
+					require.NotNil(t, metadata)
+					assert.True(t, isNum(2, metadata.Lookup("type")))
+					params := getParameter(metadata.Lookup("doc").MutableDocument())
+					counter := iter.Document().Lookup("counter")
+					switch {
+					case counter.Type() == bsontype.Array:
+						// the matrix reports the
+						// metadata of each chunk
+					case counter.Int64() < 10:
+						assert.EqualValues(t, 1, params.Lookup("a").Int32())
+					case counter.Int64() < 30:
+						assert.EqualValues(t, 4, params.Lookup("a").Int32())
+					default:
+						assert.EqualValues(t, 5, params.Lookup("c").Int32())
+					}
+				}
+				require.NoError(t, iter.Err())
+			})
+		}
+	})
+	t.Run("MissingDocument", func(t *testing.T) {
+		corrupt := &bytes.Buffer{}
+		_, err := birch.NewDocument(
+			birch.EC.Time("_id", start),
+			birch.EC.Int32("type", 2),
+			birch.EC.Int64("counter", 0),
+		).WriteTo(corrupt)
+		require.NoError(t, err)
+		corrupt.Write(payload)
+		data := corrupt.Bytes()
+
+		iter := ReadChunks(ctx, bytes.NewBuffer(data))
+		for iter.Next() {
+		}
+		assert.Error(t, iter.Err())
+		iter.Close()
+
+		iter = ReadChunksWithOptions(ctx, bytes.NewBuffer(data), ReadOptions{SkipCorrupt: true})
+		count := 0
+		for iter.Next() {
+			count++
+		}
+		assert.NoError(t, iter.Err())
+		assert.Equal(t, 4, count)
+		assert.Len(t, iter.Skipped(), 1)
+		iter.Close()
+	})
+}
+
+func TestApplyMetadataDelta(t *testing.T) {
+	reference := birch.NewDocument(
+		birch.EC.SubDocumentFromElements("one", birch.EC.Int32("a", 1), birch.EC.Int32("b", 2)),
+		birch.EC.SubDocumentFromElements("two",
+			birch.EC.SubDocumentFromElements("nested", birch.EC.Int32("x", 1), birch.EC.Int32("y", 2)),
+		),
+		birch.EC.Int32("three", 3),
+	)
+
+	out := applyMetadataDelta(reference, birch.NewDocument(
+		birch.EC.SubDocumentFromElements("one", birch.EC.Int32("b", 20)),
+		birch.EC.SubDocumentFromElements("two",
+			birch.EC.SubDocumentFromElements("nested", birch.EC.Int32("x", 10)),
+		),
+		birch.EC.Int32("three", 30),
+	))
+
+	assert.Equal(t, 3, out.Len())
+	assert.EqualValues(t, 1, out.Lookup("one").MutableDocument().Lookup("a").Int32())
+	assert.EqualValues(t, 20, out.Lookup("one").MutableDocument().Lookup("b").Int32())
+	nested := out.Lookup("two").MutableDocument().Lookup("nested").MutableDocument()
+	assert.Equal(t, 1, nested.Len())
+	assert.EqualValues(t, 10, nested.Lookup("x").Int32())
+	assert.EqualValues(t, 30, out.Lookup("three").Int32())
+
+	// the reference document is not modified
+	assert.EqualValues(t, 2, reference.Lookup("one").MutableDocument().Lookup("b").Int32())
+}
+
+func TestMetadataDelta(t *testing.T) {
+	reference := birch.NewDocument(
+		birch.EC.SubDocumentFromElements("one", birch.EC.Int32("a", 1), birch.EC.Int32("b", 2)),
+		birch.EC.SubDocumentFromElements("two", birch.EC.String("name", "example")),
+		birch.EC.Int32("three", 3),
+	)
+
+	t.Run("Unchanged", func(t *testing.T) {
+		delta, ok := metadataDelta(reference, reference.Copy())
+		assert.True(t, ok)
+		assert.Nil(t, delta)
+	})
+	t.Run("Changed", func(t *testing.T) {
+		doc := birch.NewDocument(
+			birch.EC.SubDocumentFromElements("one", birch.EC.Int32("a", 1), birch.EC.Int32("b", 20)),
+			birch.EC.SubDocumentFromElements("two", birch.EC.String("name", "example")),
+			birch.EC.Int32("three", 30),
+		)
+		delta, ok := metadataDelta(reference, doc)
+		require.True(t, ok)
+		require.NotNil(t, delta)
+
+		// every top-level document is in the delta, with only
+		// the fields that changed.
+		assert.Equal(t, 3, delta.Len())
+		assert.Equal(t, 1, delta.Lookup("one").MutableDocument().Len())
+		assert.EqualValues(t, 20, delta.Lookup("one").MutableDocument().Lookup("b").Int32())
+		assert.Equal(t, 0, delta.Lookup("two").MutableDocument().Len())
+		assert.EqualValues(t, 30, delta.Lookup("three").Int32())
+
+		assert.True(t, documentsEqual(applyMetadataDelta(reference, delta), doc))
+	})
+	t.Run("DifferentFields", func(t *testing.T) {
+		for _, doc := range []*birch.Document{
+			birch.NewDocument(birch.EC.SubDocumentFromElements("one", birch.EC.Int32("a", 1))),
+			birch.NewDocument(
+				birch.EC.SubDocumentFromElements("one", birch.EC.Int32("a", 1), birch.EC.Int32("c", 2)),
+				birch.EC.SubDocumentFromElements("two", birch.EC.String("name", "example")),
+				birch.EC.Int32("three", 3),
+			),
+			birch.NewDocument(
+				birch.EC.SubDocumentFromElements("one", birch.EC.Int32("a", 1), birch.EC.Int32("b", 2)),
+				birch.EC.String("two", "example"),
+				birch.EC.Int32("three", 3),
+			),
+		} {
+			_, ok := metadataDelta(reference, doc)
+			assert.False(t, ok, "%s", doc)
+		}
+
+		_, ok := metadataDelta(nil, reference)
+		assert.False(t, ok)
+	})
+}
+
+func TestCollectorPeriodicMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	metadata := func(b int32, extra ...*birch.Element) *birch.Document {
+		return birch.NewDocument(
+			birch.EC.SubDocumentFromElements("getParameter", append([]*birch.Element{
+				birch.EC.Int32("a", 1),
+				birch.EC.Int32("b", b),
+			}, extra...)...),
+			birch.EC.SubDocumentFromElements("getCmdLineOpts", birch.EC.String("config", "/etc/mongod.conf")),
+		)
+	}
+
+	for _, test := range []struct {
+		name    string
+		factory func(out *bytes.Buffer) Collector
+	}{
+		{
+			name:    "Base",
+			factory: func(*bytes.Buffer) Collector { return NewBaseCollector(100) },
+		},
+		{
+			name:    "Batch",
+			factory: func(*bytes.Buffer) Collector { return NewBatchCollector(4) },
+		},
+		{
+			name:    "Dynamic",
+			factory: func(*bytes.Buffer) Collector { return NewDynamicCollector(4) },
+		},
+		{
+			name:    "Union",
+			factory: func(*bytes.Buffer) Collector { return NewUnionCollector(4) },
+		},
+		{
+			name:    "Streaming",
+			factory: func(out *bytes.Buffer) Collector { return NewStreamingCollector(4, out) },
+		},
+		{
+			name:    "StreamingDynamic",
+			factory: func(out *bytes.Buffer) Collector { return NewStreamingDynamicCollector(4, out) },
+		},
+		{
+			name: "Buffered",
+			factory: func(*bytes.Buffer) Collector {
+				return NewBufferedCollector(ctx, 10, NewSynchronizedCollector(NewBatchCollector(4)))
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			collector, ok := test.factory(out).(PeriodicMetadataCollector)
+			require.True(t, ok)
+			require.NoError(t, collector.SetMetadata(birch.NewDocument(birch.EC.String("host", "localhost"))))
+
+			sample := 0
+			add := func(n int) {
+				for i := 0; i < n; i++ {
+					require.NoError(t, collector.Add(birch.NewDocument(birch.EC.Int64("counter", int64(sample)))))
+					sample++
+				}
+			}
+
+			require.NoError(t, collector.SetPeriodicMetadata(metadata(2)))
+			add(3)
+			require.NoError(t, collector.SetPeriodicMetadata(metadata(2)))
+			add(3)
+			require.NoError(t, collector.SetPeriodicMetadata(metadata(3)))
+			add(3)
+			require.NoError(t, collector.SetPeriodicMetadata(metadata(3, birch.EC.Int32("c", 4))))
+			add(3)
+
+			if test.name == "Buffered" {
+				time.Sleep(100 * time.Millisecond)
+			}
+			require.NoError(t, FlushCollector(collector, out))
+
+			updates := []*PeriodicMetadata{}
+			var last *Chunk
+			samples := 0
+			for chunk, err := range Chunks(ctx, bytes.NewReader(out.Bytes())) {
+				require.NoError(t, err)
+				assert.Equal(t, "localhost", chunk.GetMetadata().Lookup("doc").MutableDocument().Lookup("host").StringValue())
+				updates = append(updates, chunk.MetadataUpdates()...)
+				samples += chunk.Size()
+				last = chunk
+			}
+			assert.Equal(t, 12, samples)
+			require.NotNil(t, last)
+
+			require.Len(t, updates, 3)
+			assert.Equal(t, []int64{0, 1, 0}, []int64{updates[0].Counter, updates[1].Counter, updates[2].Counter})
+			assert.True(t, documentsEqual(updates[0].Delta, metadata(2)), "%s", updates[0].Delta)
+			assert.True(t, documentsEqual(updates[1].Document, metadata(3)), "%s", updates[1].Document)
+			assert.EqualValues(t, 3, updates[1].Delta.Lookup("getParameter").MutableDocument().Lookup("b").Int32())
+			assert.Equal(t, 1, updates[1].Delta.Lookup("getParameter").MutableDocument().Len())
+			assert.Equal(t, 0, updates[1].Delta.Lookup("getCmdLineOpts").MutableDocument().Len())
+			assert.True(t, documentsEqual(last.GetPeriodicMetadata(), metadata(3, birch.EC.Int32("c", 4))))
+		})
+	}
+	t.Run("StreamingDynamicSchemaChange", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		collector, ok := NewStreamingDynamicCollector(100, out).(PeriodicMetadataCollector)
+		require.True(t, ok)
+
+		require.NoError(t, collector.SetPeriodicMetadata(metadata(2)))
+		require.NoError(t, collector.Add(birch.NewDocument(birch.EC.Int64("a", 1))))
+		require.NoError(t, collector.Add(birch.NewDocument(birch.EC.Int64("a", 2), birch.EC.Int64("b", 1))))
+		require.NoError(t, collector.SetPeriodicMetadata(metadata(2)))
+		require.NoError(t, collector.SetPeriodicMetadata(metadata(3)))
+		require.NoError(t, collector.Add(birch.NewDocument(birch.EC.Int64("a", 3), birch.EC.Int64("b", 2))))
+		require.NoError(t, FlushCollector(collector, out))
+
+		updates := []*PeriodicMetadata{}
+		for chunk, err := range Chunks(ctx, bytes.NewReader(out.Bytes())) {
+			require.NoError(t, err)
+			updates = append(updates, chunk.MetadataUpdates()...)
+		}
+		require.Len(t, updates, 2)
+		assert.Equal(t, []int64{0, 1}, []int64{updates[0].Counter, updates[1].Counter})
+		assert.True(t, documentsEqual(updates[1].Document, metadata(3)), "%s", updates[1].Document)
+	})
+	t.Run("Uncompressed", func(t *testing.T) {
+		collector := NewUncompressedCollectorBSON(10)
+		_, ok := collector.(PeriodicMetadataCollector)
+		assert.False(t, ok)
+
+		for _, wrapper := range []Collector{
+			NewSynchronizedCollector(collector),
+			NewBufferedCollector(ctx, 10, collector),
+			NewSamplingCollector(time.Second, collector),
+		} {
+			pc, ok := wrapper.(PeriodicMetadataCollector)
+			require.True(t, ok)
+			assert.Error(t, pc.SetPeriodicMetadata(metadata(1)))
+		}
+	})
+}
+
+func documentsEqual(a, b *birch.Document) bool {
+	return birch.VC.Document(a).Equal(birch.VC.Document(b))
+}
@@ -46,23 +46,39 @@ func metricForType(key string, path []string, val *birch.Value) []Metric {
 		return []Metric{}
 	case bsontype.String:
 		return []Metric{}
-	case bsontype.Decimal128:
-		return []Metric{}
 	case bsontype.Array:
 		return metricForArray(key, path, val.MutableArray())
 	case bsontype.EmbeddedDocument:
-		path = append(path, key)
+		if ns, ok := nanosecondTimeFromDocument(val.MutableDocument()); ok {
+			return []Metric{
+				{
+					ParentPath:    path,
+					KeyName:       key,
+					startingValue: ns,
+					originalType:  bsontype.DateTime,
+					encoding:      encodingNanoseconds,
+				},
+			}
+		}
 
-		o := []Metric{}
-		for _, ne := range metricForDocument(path, val.MutableDocument()) {
-			o = append(o, Metric{
-				ParentPath:    path,
-				KeyName:       ne.KeyName,
-				startingValue: ne.startingValue,
-				originalType:  ne.originalType,
-			})
+		if value, ok := unsignedIntegerFromDocument(val.MutableDocument()); ok {
+			return []Metric{
+				{
+					ParentPath:    path,
+					KeyName:       key,
+					startingValue: value,
+					originalType:  bsontype.Int64,
+					encoding:      encodingUnsigned,
+				},
+			}
 		}
-		return o
+
+		// copy the path so that sibling documents don't share
+		// (and overwrite) the same backing array.
+		subpath := make([]string, len(path), len(path)+1)
+		copy(subpath, path)
+
+		return metricForDocument(append(subpath, key), val.MutableDocument())
 	case bsontype.Boolean:
 		if val.Boolean() {
 			return []Metric{
@@ -124,7 +140,7 @@ func metricForType(key string, path []string, val *birch.Value) []Metric {
 			{
 				ParentPath:    path,
 				KeyName:       key,
-				startingValue: int64(t) * 1000,
+				startingValue: int64(t),
 				originalType:  val.Type(),
 			},
 			{
@@ -134,7 +150,52 @@ func metricForType(key string, path []string, val *birch.Value) []Metric {
 				originalType:  val.Type(),
 			},
 		}
+	case bsontype.Decimal128:
+		return []Metric{
+			{
+				ParentPath:    path,
+				KeyName:       key,
+				startingValue: normalizeFloat(decimalToFloat(decimalValue(val))),
+				originalType:  val.Type(),
+			},
+		}
+	case bsontype.Null, bsontype.Undefined:
+		return []Metric{
+			{
+				ParentPath:   path,
+				KeyName:      key,
+				originalType: val.Type(),
+			},
+		}
 	default:
 		return []Metric{}
 	}
 }
+
+// withoutDecimalAndNullMetrics returns the metrics other than the
+// metrics for decimal, null, and undefined values, which are only
+// recorded by collectors with the DecimalAndNullValues option.
+func withoutDecimalAndNullMetrics(metrics []Metric) []Metric {
+	out := make([]Metric, 0, len(metrics))
+	for idx := range metrics {
+		if !isDecimalOrNullType(metrics[idx].originalType) {
+			out = append(out, metrics[idx])
+		}
+	}
+
+	return out
+}
+
+// withoutDecimalAndNullValues returns the values, which correspond to
+// the metrics, without the values for the metrics that
+// withoutDecimalAndNullMetrics omits.
+func withoutDecimalAndNullValues(metrics []Metric, values []bool) []bool {
+	out := make([]bool, 0, len(values))
+	for idx := range metrics {
+		if !isDecimalOrNullType(metrics[idx].originalType) {
+			out = append(out, values[idx])
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,111 @@
+package ftdc
+
+import (
+	"fmt"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
+)
+
+////////////////////////////////////////////////////////////////////////
+//
+// Helpers for projecting a subset of the metrics out of a reference
+// document.
+//
+// These functions walk documents in the same order as the
+// metricForDocument family, and append one entry to the keep slice
+// for every metric that metricForDocument would produce, so that the
+// slice can be used to select metrics from the full list.
+
+func projectDocument(path []string, doc *birch.Document, selected func(string) bool, keep []bool) (*birch.Document, []bool) {
+	out := birch.DC.Make(doc.Len())
+	iter := doc.Iterator()
+
+	var val *birch.Value
+	for iter.Next() {
+		elem := iter.Element()
+		val, keep = projectValue(elem.Key(), path, elem.Value(), selected, keep)
+		if val == nil {
+			continue
+		}
+
+		out.Append(birch.EC.Value(elem.Key(), val))
+	}
+
+	return out, keep
+}
+
+func projectArray(key string, path []string, array *birch.Array, selected func(string) bool, keep []bool) (*birch.Array, []bool) {
+	out := birch.MakeArray(array.Len())
+	iter := array.Iterator()
+	idx := 0
+
+	var val *birch.Value
+	for iter.Next() {
+		val, keep = projectValue(fmt.Sprintf("%s.%d", key, idx), path, iter.Value(), selected, keep)
+		idx++
+		if val == nil {
+			continue
+		}
+
+		out.Append(val)
+	}
+
+	return out, keep
+}
+
+func projectValue(key string, path []string, val *birch.Value, selected func(string) bool, keep []bool) (*birch.Value, []bool) {
+	switch val.Type() {
+	case bsontype.Array:
+		var array *birch.Array
+		array, keep = projectArray(key, path, val.MutableArray(), selected, keep)
+		if array.Len() == 0 {
+			return nil, keep
+		}
+		return birch.VC.Array(array), keep
+	case bsontype.EmbeddedDocument:
+		// the documents that record nanosecond times and
+		// unsigned integers are single metrics, as in
+		// metricForType, rather than documents of metrics.
+		if isMarkerDocument(val.MutableDocument()) {
+			return projectMetric(key, path, val, selected, keep)
+		}
+
+		var doc *birch.Document
+		subpath := make([]string, len(path), len(path)+1)
+		copy(subpath, path)
+		doc, keep = projectDocument(append(subpath, key), val.MutableDocument(), selected, keep)
+		if doc.Len() == 0 {
+			return nil, keep
+		}
+		return birch.VC.Document(doc), keep
+	default:
+		return projectMetric(key, path, val, selected, keep)
+	}
+}
+
+func projectMetric(key string, path []string, val *birch.Value, selected func(string) bool, keep []bool) (*birch.Value, []bool) {
+	metrics := metricForType(key, path, val)
+	if len(metrics) == 0 {
+		return nil, keep
+	}
+
+	// metrics derived from a single value, like the two halves of
+	// a timestamp, are selected together.
+	include := false
+	for idx := range metrics {
+		if selected(metrics[idx].Key()) {
+			include = true
+			break
+		}
+	}
+
+	for range metrics {
+		keep = append(keep, include)
+	}
+
+	if !include {
+		return nil, keep
+	}
+	return val, keep
+}
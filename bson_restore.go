@@ -2,6 +2,7 @@ package ftdc
 
 import (
 	"math"
+	"time"
 
 	"github.com/evergreen-ci/birch"
 	"github.com/evergreen-ci/birch/bsontype"
@@ -12,7 +13,12 @@ import (
 // Processores use to return rich (i.e. non-flat) structures from
 // metrics slices
 
-func restoreDocument(ref *birch.Document, sample int, metrics []Metric, idx int) (*birch.Document, int) {
+// restoreDocument returns the sample with the structure of the
+// reference document. Times at nanosecond precision are restored as
+// date-times, unless the document is a reference document, for
+// another chunk, which must record them as the original reference
+// document does.
+func restoreDocument(ref *birch.Document, sample int, metrics []Metric, idx int, reference bool) (*birch.Document, int) {
 	if ref == nil {
 		return nil, 0
 	}
@@ -25,7 +31,7 @@ func restoreDocument(ref *birch.Document, sample int, metrics []Metric, idx int)
 	for iter.Next() {
 		refElem := iter.Element()
 
-		elem, idx = restoreElement(refElem, sample, metrics, idx)
+		elem, idx = restoreElement(refElem, sample, metrics, idx, reference)
 		if elem == nil {
 			continue
 		}
@@ -35,14 +41,12 @@ func restoreDocument(ref *birch.Document, sample int, metrics []Metric, idx int)
 	return doc, idx
 }
 
-func restoreElement(ref *birch.Element, sample int, metrics []Metric, idx int) (*birch.Element, int) {
+func restoreElement(ref *birch.Element, sample int, metrics []Metric, idx int, reference bool) (*birch.Element, int) {
 	switch ref.Value().Type() {
 	case bsontype.ObjectID:
 		return nil, idx
 	case bsontype.String:
 		return nil, idx
-	case bsontype.Decimal128:
-		return nil, idx
 	case bsontype.Array:
 		array := ref.Value().MutableArray()
 
@@ -52,7 +56,7 @@ func restoreElement(ref *birch.Element, sample int, metrics []Metric, idx int) (
 		for iter.Next() {
 			var item *birch.Element
 			// TODO avoid Interface
-			item, idx = restoreElement(birch.EC.Interface("", iter.Value()), sample, metrics, idx)
+			item, idx = restoreElement(birch.EC.Interface("", iter.Value()), sample, metrics, idx, reference)
 			if item == nil {
 				continue
 			}
@@ -74,7 +78,18 @@ func restoreElement(ref *birch.Element, sample int, metrics []Metric, idx int) (
 	case bsontype.EmbeddedDocument:
 		var doc *birch.Document
 
-		doc, idx = restoreDocument(ref.Value().MutableDocument(), sample, metrics, idx)
+		if _, ok := nanosecondTimeFromDocument(ref.Value().MutableDocument()); ok {
+			if reference {
+				return birch.EC.SubDocument(ref.Key(), nanosecondTimeDocument(metrics[idx].Values[sample])), idx + 1
+			}
+			return birch.EC.Time(ref.Key(), time.Unix(0, metrics[idx].Values[sample])), idx + 1
+		}
+
+		if _, ok := unsignedIntegerFromDocument(ref.Value().MutableDocument()); ok {
+			return birch.EC.SubDocument(ref.Key(), unsignedIntegerDocument(metrics[idx].Values[sample])), idx + 1
+		}
+
+		doc, idx = restoreDocument(ref.Value().MutableDocument(), sample, metrics, idx, reference)
 		return birch.EC.SubDocument(ref.Key(), doc), idx
 	case bsontype.Boolean:
 		value := metrics[idx].Values[sample]
@@ -92,11 +107,34 @@ func restoreElement(ref *birch.Element, sample int, metrics []Metric, idx int) (
 		return birch.EC.Time(ref.Key(), timeEpocMs(metrics[idx].Values[sample])), idx + 1
 	case bsontype.Timestamp:
 		return birch.EC.Timestamp(ref.Key(), uint32(metrics[idx].Values[sample]), uint32(metrics[idx+1].Values[sample])), idx + 2
+	case bsontype.Decimal128, bsontype.Null, bsontype.Undefined:
+		// these values are only metrics in chunks recorded with
+		// the DecimalAndNullValues option, and otherwise,
+		// there are no metrics of these types.
+		if idx >= len(metrics) || metrics[idx].originalType != ref.Value().Type() {
+			return nil, idx
+		}
+
+		elem, _ := restoreFlat(metrics[idx].originalType, ref.Key(), metrics[idx].Values[sample])
+		return elem, idx + 1
 	default:
 		return nil, idx
 	}
 }
 
+// flatElement returns an element with the value of the metric, as
+// restoreFlat, for metrics with any encoding.
+func (m *Metric) flatElement(key string, value int64) (*birch.Element, bool) {
+	switch m.encoding {
+	case encodingNanoseconds:
+		return birch.EC.Time(key, time.Unix(0, value)), true
+	case encodingUnsigned:
+		return birch.EC.SubDocument(key, unsignedIntegerDocument(value)), true
+	}
+
+	return restoreFlat(m.originalType, key, value)
+}
+
 func restoreFlat(t bsontype.Type, key string, value int64) (*birch.Element, bool) {
 	switch t {
 	case bsontype.Boolean:
@@ -110,6 +148,12 @@ func restoreFlat(t bsontype.Type, key string, value int64) (*birch.Element, bool
 		return birch.EC.Int32(key, int32(value)), true
 	case bsontype.DateTime:
 		return birch.EC.Time(key, timeEpocMs(value)), true
+	case bsontype.Decimal128:
+		return birch.EC.Decimal128(key, floatToDecimal(restoreFloat(value))), true
+	case bsontype.Null:
+		return birch.EC.Null(key), true
+	case bsontype.Undefined:
+		return birch.EC.Undefined(key), true
 	default:
 		return birch.EC.Int64(key, value), true
 	}
@@ -1,8 +1,13 @@
 package ftdc
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -53,10 +58,41 @@ func TestFlattenArray(t *testing.T) {
 	})
 }
 
+func TestMetricKeys(t *testing.T) {
+	doc := birch.NewDocument(
+		birch.EC.SubDocumentFromElements("wiredTiger",
+			birch.EC.SubDocumentFromElements("cache",
+				birch.EC.Int64("bytes read", 1),
+				birch.EC.SubDocumentFromElements("eviction",
+					birch.EC.Int64("pages", 2),
+				),
+			),
+			birch.EC.SubDocumentFromElements("log",
+				birch.EC.Int64("syncs", 3),
+			),
+			birch.EC.ArrayFromElements("sessions", birch.VC.Int32(4)),
+		),
+		birch.EC.Int64("uptime", 5),
+	)
+
+	keys := []string{}
+	for _, m := range metricForDocument(nil, doc) {
+		keys = append(keys, m.Key())
+	}
+	assert.Equal(t, []string{
+		"wiredTiger.cache.bytes read",
+		"wiredTiger.cache.eviction.pages",
+		"wiredTiger.log.syncs",
+		"wiredTiger.sessions.0",
+		"uptime",
+	}, keys)
+}
+
 func TestReadDocument(t *testing.T) {
 	for _, test := range []struct {
 		name        string
 		in          interface{}
+		unsigned    bool
 		shouldError bool
 		len         int
 	}{
@@ -172,6 +208,53 @@ func TestReadDocument(t *testing.T) {
 			shouldError: false,
 			len:         1,
 		},
+		{
+			name:        "Uint64Map",
+			in:          map[string]uint64{"foo": math.MaxUint64, "bar": 42},
+			shouldError: false,
+		},
+		{
+			name:        "UintMap",
+			in:          map[string]uint{"foo": 42},
+			shouldError: false,
+		},
+		{
+			name:        "Uint64MapUnsigned",
+			in:          map[string]uint64{"foo": math.MaxUint64, "bar": 42},
+			unsigned:    true,
+			shouldError: false,
+			len:         2,
+		},
+		{
+			name:        "UintMapUnsigned",
+			in:          map[string]uint{"foo": 42},
+			unsigned:    true,
+			shouldError: false,
+			len:         1,
+		},
+		{
+			name: "StructWithLargeUint64",
+			in: struct {
+				Large uint64 `bson:"large"`
+			}{
+				Large: math.MaxUint64 - 1,
+			},
+			shouldError: true,
+		},
+		{
+			name: "StructWithLargeUint64Unsigned",
+			in: struct {
+				Small uint64 `bson:"small"`
+				Large uint64 `bson:"large"`
+				Plain uint   `bson:"plain"`
+			}{
+				Small: 42,
+				Large: math.MaxUint64 - 1,
+				Plain: 7,
+			},
+			unsigned: true,
+			len:      3,
+		},
 		{
 			name:        "StringMapEmpty",
 			in:          map[string]string{},
@@ -184,7 +267,7 @@ func TestReadDocument(t *testing.T) {
 		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
-			doc, err := readDocument(test.in)
+			doc, err := readSample(test.in, CollectorOptions{UnsignedIntegers: test.unsigned})
 			if test.shouldError {
 				assert.Error(t, err)
 			} else {
@@ -227,12 +310,34 @@ func TestBSONValueToMetric(t *testing.T) {
 			Value: birch.VC.String("42"),
 		},
 		{
-			Name:  "Decimal128Empty",
-			Value: birch.VC.Decimal128(decimal.Decimal128{}),
+			Name:      "Decimal128Empty",
+			Value:     birch.VC.Decimal128(decimal.Decimal128{}),
+			OutputLen: 1,
+			Expected:  0,
+		},
+		{
+			Name:      "Decimal128",
+			Value:     birch.VC.Decimal128(mustParseDecimal(t, "1.5")),
+			OutputLen: 1,
+			Expected:  normalizeFloat(1.5),
+			Key:       "foo",
+			Path:      []string{"really", "exists"},
 		},
 		{
-			Name:  "Decimal128",
-			Value: birch.VC.Decimal128(decimal.NewDecimal128(33, 43)),
+			Name:      "Null",
+			Value:     birch.VC.Null(),
+			OutputLen: 1,
+			Expected:  0,
+			Key:       "foo",
+			Path:      []string{"really", "exists"},
+		},
+		{
+			Name:      "Undefined",
+			Value:     birch.VC.Undefined(),
+			OutputLen: 1,
+			Expected:  0,
+			Key:       "foo",
+			Path:      []string{"really", "exists"},
 		},
 		{
 			Name:  "DBPointer",
@@ -374,7 +479,7 @@ func TestBSONValueToMetric(t *testing.T) {
 			Name:      "TimeStamp",
 			Value:     birch.VC.Timestamp(100, 100),
 			OutputLen: 2,
-			Expected:  100000,
+			Expected:  100,
 			Key:       "foo",
 			Path:      []string{"really", "exists"},
 		},
@@ -406,6 +511,13 @@ func TestExtractingMetrics(t *testing.T) {
 	}{
 		{
 			Name:              "IgnoredType",
+			Value:             birch.VC.MinKey(),
+			ExpectedCount:     0,
+			FirstEncodedValue: 0,
+			NumEncodedValues:  0,
+		},
+		{
+			Name:              "Null",
 			Value:             birch.VC.Null(),
 			ExpectedCount:     0,
 			FirstEncodedValue: 0,
@@ -427,7 +539,7 @@ func TestExtractingMetrics(t *testing.T) {
 		},
 		{
 			Name:              "Decimal128",
-			Value:             birch.VC.Decimal128(decimal.NewDecimal128(42, 42)),
+			Value:             birch.VC.Decimal128(mustParseDecimal(t, "40")),
 			ExpectedCount:     0,
 			FirstEncodedValue: 0,
 			NumEncodedValues:  0,
@@ -611,7 +723,7 @@ func TestExtractingMetrics(t *testing.T) {
 		},
 	} {
 		t.Run(test.Name, func(t *testing.T) {
-			metrics, err := extractMetricsFromValue(test.Value)
+			metrics, err := extractMetricsFromValue(test.Value, CollectorOptions{})
 			assert.NoError(t, err)
 			assert.Equal(t, test.NumEncodedValues, len(metrics.values))
 
@@ -633,6 +745,43 @@ func TestExtractingMetrics(t *testing.T) {
 	}
 }
 
+func TestExtractingDecimalAndNullMetrics(t *testing.T) {
+	opts := CollectorOptions{DecimalAndNullValues: true}
+	for _, test := range []struct {
+		name     string
+		value    *birch.Value
+		expected *birch.Value
+	}{
+		{
+			name:     "Decimal128",
+			value:    birch.VC.Decimal128(mustParseDecimal(t, "40.5")),
+			expected: birch.VC.Double(40.5),
+		},
+		{
+			name:     "Null",
+			value:    birch.VC.Null(),
+			expected: birch.VC.Int64(0),
+		},
+		{
+			name:     "Undefined",
+			value:    birch.VC.Undefined(),
+			expected: birch.VC.Int64(0),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			metrics, err := extractMetricsFromValue(test.value, opts)
+			require.NoError(t, err)
+			require.Len(t, metrics.values, 1)
+			assert.Equal(t, test.expected.Interface(), metrics.values[0].Interface())
+			assert.Equal(t, []bsontype.Type{test.value.Type()}, metrics.types)
+
+			metrics, err = extractMetricsFromValue(test.value, CollectorOptions{})
+			require.NoError(t, err)
+			assert.Empty(t, metrics.values)
+		})
+	}
+}
+
 func TestDocumentExtraction(t *testing.T) {
 	for _, test := range []struct {
 		Name               string
@@ -683,7 +832,7 @@ func TestDocumentExtraction(t *testing.T) {
 		},
 	} {
 		t.Run(test.Name, func(t *testing.T) {
-			metrics, err := extractMetricsFromDocument(test.Document)
+			metrics, err := extractMetricsFromDocument(test.Document, CollectorOptions{})
 			assert.NoError(t, err)
 			assert.Equal(t, test.NumEncodedValues, len(metrics.values))
 			assert.False(t, metrics.ts.IsZero())
@@ -742,7 +891,7 @@ func TestArrayExtraction(t *testing.T) {
 		},
 	} {
 		t.Run(test.Name, func(t *testing.T) {
-			metrics, err := extractMetricsFromArray(test.Array)
+			metrics, err := extractMetricsFromArray(test.Array, CollectorOptions{})
 			assert.NoError(t, err)
 			assert.Equal(t, test.NumEncodedValues, len(metrics.values))
 			if test.NumEncodedValues >= 1 {
@@ -766,6 +915,12 @@ func TestMetricsHashValue(t *testing.T) {
 	}{
 		{
 			name:        "IgnoredType",
+			value:       birch.VC.MinKey(),
+			expectedNum: 0,
+			keyElems:    0,
+		},
+		{
+			name:        "Null",
 			value:       birch.VC.Null(),
 			expectedNum: 0,
 			keyElems:    0,
@@ -928,10 +1083,34 @@ func TestMetricsHashValue(t *testing.T) {
 				assert.Equal(t, test.keyElems, len(keys))
 			})
 			t.Run("Checksum", func(t *testing.T) {
-				assert.Equal(t, test.expectedNum, metricKeyHashValue(fnv.New128(), "key", test.value))
+				assert.Equal(t, test.expectedNum, metricKeyHashValue(fnv.New128(), "key", test.value, CollectorOptions{}))
 			})
 		})
 	}
+	t.Run("DecimalAndNullValues", func(t *testing.T) {
+		opts := CollectorOptions{DecimalAndNullValues: true}
+		hash := func(value *birch.Value) string {
+			out, num := metricKeyHash(birch.NewDocument(birch.EC.Value("key", value)), opts)
+			assert.Equal(t, 1, num)
+			return out
+		}
+
+		// changing between these and other types is a schema
+		// change, unlike changing between numeric types, which
+		// can be widened.
+		hashes := map[string]bool{}
+		for _, value := range []*birch.Value{
+			birch.VC.Int64(1),
+			birch.VC.Double(1),
+			birch.VC.Decimal128(decimal.NewDecimal128(42, 42)),
+			birch.VC.Null(),
+			birch.VC.Undefined(),
+		} {
+			hashes[hash(value)] = true
+		}
+		assert.Len(t, hashes, 4)
+		assert.Equal(t, hash(birch.VC.Null()), hash(birch.VC.Null()))
+	})
 }
 
 func TestMetricsToElement(t *testing.T) {
@@ -958,6 +1137,29 @@ func TestMetricsToElement(t *testing.T) {
 		{
 			name: "Decimal128",
 			ref:  birch.EC.Decimal128("foo", decimal.NewDecimal128(1, 2)),
+			metrics: []Metric{
+				{Values: []int64{normalizeFloat(0.125)}, originalType: bsontype.Decimal128},
+			},
+			expected: birch.EC.Decimal128("foo", mustParseDecimal(t, "0.125")),
+			outNum:   1,
+		},
+		{
+			name: "Null",
+			ref:  birch.EC.Null("foo"),
+			metrics: []Metric{
+				{Values: []int64{0}, originalType: bsontype.Null},
+			},
+			expected: birch.EC.Null("foo"),
+			outNum:   1,
+		},
+		{
+			name: "Undefined",
+			ref:  birch.EC.Undefined("foo"),
+			metrics: []Metric{
+				{Values: []int64{0}, originalType: bsontype.Undefined},
+			},
+			expected: birch.EC.Undefined("foo"),
+			outNum:   1,
 		},
 		{
 			name: "Double",
@@ -1071,7 +1273,7 @@ func TestMetricsToElement(t *testing.T) {
 		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
-			elem, num := restoreElement(test.ref, 0, test.metrics, 0)
+			elem, num := restoreElement(test.ref, 0, test.metrics, 0, false)
 			assert.Equal(t, test.outNum, num)
 			if !test.isDocument {
 				assert.Equal(t, test.expected, elem)
@@ -1083,6 +1285,141 @@ func TestMetricsToElement(t *testing.T) {
 	}
 }
 
+func TestExtendedTypeRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type sample struct {
+		Counter uint64          `bson:"counter"`
+		Ratio   bson.Decimal128 `bson:"ratio"`
+		Missing *int64          `bson:"missing"`
+	}
+
+	collector, err := NewBaseCollectorWithOptions(100, CollectorOptions{UnsignedIntegers: true, DecimalAndNullValues: true})
+	require.NoError(t, err)
+	start := uint64(math.MaxInt64 - 2)
+	for i := uint64(0); i < 6; i++ {
+		ratio, err := bson.ParseDecimal128(fmt.Sprintf("%d.25", i))
+		require.NoError(t, err)
+		require.NoError(t, collector.Add(sample{Counter: start + i, Ratio: ratio}))
+	}
+
+	out, err := collector.Resolve()
+	require.NoError(t, err)
+
+	t.Run("Structured", func(t *testing.T) {
+		idx := uint64(0)
+		for doc, err := range StructuredSamples(ctx, bytes.NewReader(out)) {
+			require.NoError(t, err)
+
+			counter, ok := Uint64Value(doc.Lookup("counter"))
+			require.True(t, ok)
+			assert.Equal(t, start+idx, counter)
+
+			require.Equal(t, bsontype.Decimal128, doc.Lookup("ratio").Type())
+			assert.Equal(t, fmt.Sprintf("%d.25", idx), decimalValue(doc.Lookup("ratio")).String())
+
+			assert.Equal(t, bsontype.Null, doc.Lookup("missing").Type())
+			idx++
+		}
+		assert.EqualValues(t, 6, idx)
+	})
+	t.Run("Flattened", func(t *testing.T) {
+		count := 0
+		for doc, err := range Samples(ctx, bytes.NewReader(out)) {
+			require.NoError(t, err)
+			assert.Equal(t, 3, doc.Len())
+			assert.Equal(t, bsontype.Decimal128, doc.Lookup("ratio").Type())
+			assert.Equal(t, bsontype.Null, doc.Lookup("missing").Type())
+			count++
+		}
+		assert.Equal(t, 6, count)
+	})
+	t.Run("Chunk", func(t *testing.T) {
+		for chunk, err := range Chunks(ctx, bytes.NewReader(out)) {
+			require.NoError(t, err)
+			require.Len(t, chunk.Metrics, 3)
+			assert.Equal(t, bsontype.Int64, chunk.Metrics[0].Type())
+			assert.Equal(t, []uint64{start, start + 1, start + 2, start + 3, start + 4, start + 5}, chunk.Metrics[0].Uint64s())
+			assert.Nil(t, chunk.Metrics[1].Uint64s())
+			assert.Equal(t, bsontype.Decimal128, chunk.Metrics[1].Type())
+			assert.Equal(t, []float64{0.25, 1.25, 2.25, 3.25, 4.25, 5.25}, chunk.Metrics[1].Float64s())
+			assert.Equal(t, bsontype.Null, chunk.Metrics[2].Type())
+			assert.Equal(t, make([]int64, 6), chunk.Metrics[2].Values)
+		}
+	})
+	t.Run("Matrix", func(t *testing.T) {
+		for _, iter := range []Iterator{
+			ReadMatrix(ctx, bytes.NewReader(out)),
+			ReadSeries(ctx, bytes.NewReader(out)),
+		} {
+			count := 0
+			for doc, err := range Documents(iter) {
+				require.NoError(t, err)
+				counters := doc.Lookup("counter").MutableArray()
+				require.Equal(t, 6, counters.Len())
+				counter, ok := Uint64Value(counters.Lookup(5))
+				require.True(t, ok)
+				assert.Equal(t, start+5, counter)
+				assert.Equal(t, 6, doc.Lookup("ratio").MutableArray().Len())
+				assert.Equal(t, 6, doc.Lookup("missing").MutableArray().Len())
+				count++
+			}
+			assert.Equal(t, 1, count)
+		}
+	})
+	t.Run("CSV", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		iter := ReadChunks(ctx, bytes.NewReader(out))
+		defer iter.Close()
+		require.NoError(t, WriteCSV(ctx, iter, buf))
+
+		records, err := csv.NewReader(buf).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 7)
+		assert.Equal(t, []string{"counter", "ratio", "missing"}, records[0])
+		for i, record := range records[1:] {
+			assert.Equal(t, strconv.FormatUint(start+uint64(i), 10), record[0])
+			assert.Equal(t, fmt.Sprintf("%d.25", i), record[1])
+		}
+	})
+	t.Run("DefaultOptions", func(t *testing.T) {
+		collector, err := NewBaseCollectorWithOptions(100, CollectorOptions{UnsignedIntegers: true})
+		require.NoError(t, err)
+		for i := uint64(0); i < 6; i++ {
+			require.NoError(t, collector.Add(sample{Counter: start + i}))
+		}
+
+		out, err := collector.Resolve()
+		require.NoError(t, err)
+
+		for chunk, err := range Chunks(ctx, bytes.NewReader(out)) {
+			require.NoError(t, err)
+			require.Len(t, chunk.Metrics, 1)
+			assert.Equal(t, "counter", chunk.Metrics[0].Key())
+		}
+
+		count := 0
+		for doc, err := range Samples(ctx, bytes.NewReader(out)) {
+			require.NoError(t, err)
+			assert.Nil(t, doc.Lookup("ratio"))
+			assert.Nil(t, doc.Lookup("missing"))
+			count++
+		}
+		assert.Equal(t, 6, count)
+
+		count = 0
+		for doc, err := range StructuredSamples(ctx, bytes.NewReader(out)) {
+			require.NoError(t, err)
+			counter, ok := Uint64Value(doc.Lookup("counter"))
+			require.True(t, ok)
+			assert.Equal(t, start+uint64(count), counter)
+			count++
+		}
+		assert.Equal(t, 6, count)
+	})
+}
+
 func TestIsOneChecker(t *testing.T) {
 	assert.False(t, isNum(1, nil))
 	assert.False(t, isNum(1, birch.VC.Int32(32)))
@@ -1100,3 +1437,9 @@ func TestIsOneChecker(t *testing.T) {
 	assert.True(t, isNum(1, birch.VC.Int64(1)))
 	assert.True(t, isNum(1, birch.VC.Double(1.0)))
 }
+
+func mustParseDecimal(t *testing.T, in string) decimal.Decimal128 {
+	out, err := decimal.ParseDecimal128(in)
+	require.NoError(t, err)
+	return out
+}
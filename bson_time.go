@@ -0,0 +1,66 @@
+package ftdc
+
+import (
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
+)
+
+////////////////////////////////////////////////////////////////////////
+//
+// Helpers for recording times at nanosecond precision
+
+// nanosecondTimeKey is the key of the only field of the documents
+// that hold times at nanosecond precision. The field is an int64
+// metric that holds the time in nanoseconds since the epoch, so
+// readers that don't recognize these documents see an ordinary int64
+// metric.
+const nanosecondTimeKey = "$nanos"
+
+// NanosecondDocumentMarshaler is implemented by types that can
+// marshal their times at nanosecond precision (see NanosecondTime).
+// Collectors configured to record times at nanosecond precision use
+// MarshalDocumentNanoseconds rather than MarshalDocument.
+type NanosecondDocumentMarshaler interface {
+	MarshalDocumentNanoseconds() (*birch.Document, error)
+}
+
+// NanosecondTime returns an element that records the time at
+// nanosecond precision, for the documents passed to collectors, as
+// BSON date-times only have millisecond precision. The value of the
+// element is a document with a single int64 field, "$nanos", that
+// holds the time in nanoseconds since the epoch, which the readers
+// restore as a date-time metric whose values are in nanoseconds (see
+// Metric.Nanoseconds). The documents that the iterators return hold
+// these times as date-times, which have millisecond precision. Times
+// must be between the years 1678 and 2262.
+func NanosecondTime(key string, t time.Time) *birch.Element {
+	return birch.EC.SubDocument(key, nanosecondTimeDocument(t.UnixNano()))
+}
+
+// NanosecondTimeValue returns the time recorded by a value produced
+// by NanosecondTime, or false if the value does not hold a time at
+// nanosecond precision, as in the documents passed to collectors.
+func NanosecondTimeValue(val *birch.Value) (time.Time, bool) {
+	if val.Type() != bsontype.EmbeddedDocument {
+		return time.Time{}, false
+	}
+
+	ns, ok := nanosecondTimeFromDocument(val.MutableDocument())
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, ns), true
+}
+
+func nanosecondTimeDocument(ns int64) *birch.Document {
+	return birch.NewDocument(birch.EC.Int64(nanosecondTimeKey, ns))
+}
+
+// nanosecondTimeFromDocument returns the time, in nanoseconds since
+// the epoch, when the document holds a time at nanosecond precision.
+func nanosecondTimeFromDocument(doc *birch.Document) (int64, bool) {
+	return markerDocumentValue(doc, nanosecondTimeKey)
+}
@@ -0,0 +1,79 @@
+package ftdc
+
+import (
+	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
+)
+
+////////////////////////////////////////////////////////////////////////
+//
+// Helpers for recording unsigned 64-bit integers
+
+// unsignedIntegerKey is the key of the only field of the documents
+// that hold unsigned 64-bit integers. The field is an int64 metric
+// with the same bits as the integer, so readers that don't recognize
+// these documents see an ordinary int64 metric.
+const unsignedIntegerKey = "$uint64"
+
+// Uint64 returns an element that records an unsigned 64-bit integer,
+// for the documents passed to collectors, as BSON has no unsigned
+// integer type, and cannot represent values larger than the largest
+// int64. The value of the element is a document with a single int64
+// field, "$uint64", that holds the int64 with the same bits as the
+// integer, so that the deltas between the values of counters are
+// exact, and which the readers restore in the same form, in the
+// documents that the iterators return (see Uint64Value), and as the
+// values of an int64 metric (see Metric.Uint64s). Collectors record
+// uint and uint64 fields in this form with the UnsignedIntegers
+// option.
+func Uint64(key string, value uint64) *birch.Element {
+	return birch.EC.SubDocument(key, unsignedIntegerDocument(int64(value)))
+}
+
+// Uint64Value returns the integer recorded by a value produced by
+// Uint64, or false if the value does not hold an unsigned integer.
+func Uint64Value(val *birch.Value) (uint64, bool) {
+	if val.Type() != bsontype.EmbeddedDocument {
+		return 0, false
+	}
+
+	value, ok := unsignedIntegerFromDocument(val.MutableDocument())
+	return uint64(value), ok
+}
+
+func unsignedIntegerDocument(value int64) *birch.Document {
+	return birch.NewDocument(birch.EC.Int64(unsignedIntegerKey, value))
+}
+
+// unsignedIntegerFromDocument returns the bits of the integer, when
+// the document holds an unsigned integer.
+func unsignedIntegerFromDocument(doc *birch.Document) (int64, bool) {
+	return markerDocumentValue(doc, unsignedIntegerKey)
+}
+
+// isMarkerDocument reports if the document records a nanosecond time
+// or an unsigned integer, and so holds a single metric.
+func isMarkerDocument(doc *birch.Document) bool {
+	if _, ok := nanosecondTimeFromDocument(doc); ok {
+		return true
+	}
+
+	_, ok := unsignedIntegerFromDocument(doc)
+	return ok
+}
+
+// markerDocumentValue returns the value of the only field of a
+// document that records a value that BSON cannot represent (see
+// NanosecondTime and Uint64), when the field has the key.
+func markerDocumentValue(doc *birch.Document, key string) (int64, bool) {
+	if doc.Len() != 1 {
+		return 0, false
+	}
+
+	elem := doc.ElementAt(0)
+	if elem.Key() != key || elem.Value().Type() != bsontype.Int64 {
+		return 0, false
+	}
+
+	return elem.Value().Int64(), true
+}
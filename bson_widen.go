@@ -0,0 +1,127 @@
+package ftdc
+
+import (
+	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
+)
+
+////////////////////////////////////////////////////////////////////////
+//
+// Helpers for widening the type of numeric metrics, when collectors
+// are configured to widen types rather than reject samples.
+
+// numericRank orders the numeric types from narrowest to widest, and
+// is zero for other types.
+func numericRank(t bsontype.Type) int {
+	switch t {
+	case bsontype.Int32:
+		return 1
+	case bsontype.Int64:
+		return 2
+	case bsontype.Double:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// widerNumericType returns the narrowest type that can hold values of
+// both types, and false if either type is not numeric.
+func widerNumericType(a, b bsontype.Type) (bsontype.Type, bool) {
+	ra, rb := numericRank(a), numericRank(b)
+	switch {
+	case ra == 0 || rb == 0:
+		return 0, false
+	case ra >= rb:
+		return a, true
+	default:
+		return b, true
+	}
+}
+
+// widenMetricValue converts an extracted metric value, which is
+// either an int64 or a double, to the representation for the type.
+func widenMetricValue(val *birch.Value, t bsontype.Type) *birch.Value {
+	if t == bsontype.Double && val.Type() != bsontype.Double {
+		return birch.VC.Double(float64(val.Int64()))
+	}
+	return val
+}
+
+// widenMetricColumn converts the values of a metric, encoded as in
+// Metric.Values, from an integer type to a double.
+func widenMetricColumn(values []int64) {
+	for idx := range values {
+		values[idx] = normalizeFloat(float64(values[idx]))
+	}
+}
+
+// widenDocumentMetric returns a copy of the document, with the
+// metric at the index, counting metrics in the same order as
+// extractMetricsFromDocument, converted to the type. The second
+// return value is the index of the next metric after the document.
+func widenDocumentMetric(doc *birch.Document, metric int, t bsontype.Type, idx int, opts CollectorOptions) (*birch.Document, int) {
+	out := birch.DC.Make(doc.Len())
+
+	iter := doc.Iterator()
+	for iter.Next() {
+		var val *birch.Value
+		elem := iter.Element()
+		val, idx = widenValueMetric(elem.Value(), metric, t, idx, opts)
+		out.Append(birch.EC.Value(elem.Key(), val))
+	}
+
+	return out, idx
+}
+
+func widenValueMetric(val *birch.Value, metric int, t bsontype.Type, idx int, opts CollectorOptions) (*birch.Value, int) {
+	if idx > metric {
+		return val, idx
+	}
+
+	switch val.Type() {
+	case bsontype.EmbeddedDocument:
+		var doc *birch.Document
+		doc, idx = widenDocumentMetric(val.MutableDocument(), metric, t, idx, opts)
+		return birch.VC.Document(doc), idx
+	case bsontype.Array:
+		values := []*birch.Value{}
+		iter := val.MutableArray().Iterator()
+		for iter.Next() {
+			var item *birch.Value
+			item, idx = widenValueMetric(iter.Value(), metric, t, idx, opts)
+			values = append(values, item)
+		}
+		return birch.VC.ArrayFromValues(values...), idx
+	case bsontype.Int32, bsontype.Int64, bsontype.Double:
+		if idx != metric {
+			return val, idx + 1
+		}
+
+		switch t {
+		case bsontype.Int64:
+			if val.Type() == bsontype.Int32 {
+				return birch.VC.Int64(int64(val.Int32())), idx + 1
+			}
+		case bsontype.Double:
+			switch val.Type() {
+			case bsontype.Int32:
+				return birch.VC.Double(float64(val.Int32())), idx + 1
+			case bsontype.Int64:
+				return birch.VC.Double(float64(val.Int64())), idx + 1
+			}
+		}
+		return val, idx + 1
+	case bsontype.Boolean, bsontype.DateTime:
+		return val, idx + 1
+	case bsontype.Decimal128, bsontype.Null, bsontype.Undefined:
+		if !opts.DecimalAndNullValues {
+			return val, idx
+		}
+		return val, idx + 1
+	case bsontype.Timestamp:
+		return val, idx + 2
+	default:
+		return val, idx
+	}
+}
@@ -1,5 +1,10 @@
 package ftdc
 
+import (
+	"github.com/mongodb/ftdc/util"
+	"github.com/pkg/errors"
+)
+
 // Collector describes the interface for collecting and constructing
 // FTDC data series. Implementations may have different efficiencies
 // and handling of schema changes.
@@ -33,6 +38,38 @@ type Collector interface {
 	Info() CollectorInfo
 }
 
+// PeriodicMetadataCollector is a Collector that can also record
+// periodic metadata. The compressed collectors implement it, as do
+// the wrappers (e.g. the synchronized and buffered collectors), which
+// return an error if the collector that they wrap doesn't.
+type PeriodicMetadataCollector interface {
+	Collector
+
+	// SetPeriodicMetadata records the current state of metadata
+	// that changes over time, such as configuration, which
+	// should be a document with a sub-document for each source of
+	// metadata. When the metadata differs from the previous call,
+	// the collector writes a periodic metadata (type 2) document,
+	// as newer versions of the server do, before the chunk that
+	// holds the samples collected after the change. These
+	// documents hold only the fields that changed, unless the set
+	// of fields changed, and readers reconstruct the complete
+	// metadata for each chunk (see Chunk.GetPeriodicMetadata).
+	SetPeriodicMetadata(interface{}) error
+}
+
+// setPeriodicMetadata sets the periodic metadata of a wrapped
+// collector, returning an error if it doesn't record periodic
+// metadata.
+func setPeriodicMetadata(collector Collector, in interface{}) error {
+	pc, ok := collector.(PeriodicMetadataCollector)
+	if !ok {
+		return errors.Errorf("collector %T does not support periodic metadata", collector)
+	}
+
+	return pc.SetPeriodicMetadata(in)
+}
+
 // CollectorInfo reports on the current state of the collector and
 // provides introspection into the current state of the collector for
 // testing, transparency, and to support more complex collector
@@ -40,4 +77,117 @@ type Collector interface {
 type CollectorInfo struct {
 	MetricsCount int
 	SampleCount  int
+
+	// PayloadSize is the estimated size, in bytes, of the chunks
+	// that the collector would produce if it were resolved,
+	// which is only reported by the compressed collectors. Unless
+	// the collector has a maximum chunk size, the estimate
+	// assumes that the samples don't compress, so it is an upper
+	// bound.
+	PayloadSize int
+}
+
+// CollectorOptions configures how collectors encode chunks. The zero
+// value produces the same chunks as mongod.
+type CollectorOptions struct {
+	// Compression selects the codec used to compress the payload
+	// of each chunk, which defaults to zlib.
+	Compression Compression
+
+	// CompressionLevel sets the level of the codec, where zero
+	// is the codec's default level. For zlib, the levels range
+	// from 1 (fastest) to 9 (smallest). For zstd, the levels
+	// range from 1 to 22, as with the zstd command line tool, and
+	// are mapped to the closest level that the encoder supports.
+	CompressionLevel int
+
+	// MaxChunkSize, when non-zero, limits the size, in bytes, of
+	// each chunk, in addition to the limit on the number of
+	// samples. Collectors estimate the compressed size of the
+	// chunk as samples are added, and start a new chunk, or,
+	// for the base collector, reject the sample, when the next
+	// sample would exceed the limit. The estimate is refined by
+	// compressing the chunk as it approaches the limit, so chunks
+	// are usually close to, and rarely larger than, the limit.
+	// Chunks always have at least one sample, so chunks with a
+	// single large sample may exceed the limit.
+	MaxChunkSize int
+
+	// WidenNumericTypes makes collectors widen the type of a
+	// metric when a sample has a wider numeric type for the
+	// metric than the previous samples, rather than rejecting the
+	// sample as a schema change, which is common with documents
+	// marshaled from Go structs or JSON. Int32 metrics are
+	// widened to int64, and integer metrics are widened to
+	// double. Samples with a narrower type than the metric are
+	// converted to the metric's type.
+	//
+	// Types are widened for the whole chunk, so when the chunk is
+	// read, every sample in the chunk, including the samples
+	// collected before the type changed, has the wider type.
+	// Integers larger than 2^53 lose precision when they are
+	// widened to double.
+	WidenNumericTypes bool
+
+	// NanosecondTimes makes collectors record the times in
+	// samples at nanosecond precision, rather than as BSON
+	// date-times, which only have millisecond precision. This
+	// applies to the time.Time values of samples that the
+	// collector marshals, such as structs, and to samples that
+	// implement NanosecondDocumentMarshaler, like the events
+	// package's Performance type. Documents can record times at
+	// nanosecond precision, regardless of this option, with
+	// NanosecondTime.
+	//
+	// The times are read as date-time metrics whose values are in
+	// nanoseconds (see Metric.Nanoseconds), and the chunk's
+	// timestamps have nanosecond precision, while the documents
+	// that the iterators return hold date-times; however, other
+	// FTDC tools read them as int64 metrics.
+	NanosecondTimes bool
+
+	// UnsignedIntegers makes collectors record the uint and
+	// uint64 values in samples that the collector marshals, such
+	// as structs and maps, as unsigned integers (see Uint64), so
+	// that values larger than the largest int64, like byte
+	// counters, are recorded exactly, rather than dropped, for
+	// maps, or rejected, for other samples. Documents can record
+	// unsigned integers, regardless of this option, with Uint64.
+	//
+	// The integers are read as int64 metrics with the same bits
+	// (see Metric.Uint64s); other FTDC tools read them as int64
+	// metrics, which are negative for values larger than the
+	// largest int64.
+	UnsignedIntegers bool
+
+	// DecimalAndNullValues makes collectors record Decimal128,
+	// null, and undefined values as metrics: decimals as the
+	// closest double, and null and undefined values as zero, so
+	// that fields that are sometimes null keep their place in the
+	// schema. By default, as in mongod, these values are only
+	// recorded in the reference document of each chunk.
+	//
+	// The readers in this package recognize chunks recorded
+	// either way; however, other FTDC tools report that chunks
+	// with these metrics are corrupt, as the number of metrics
+	// doesn't match the reference document.
+	DecimalAndNullValues bool
+}
+
+// Validate returns an error if the options are not valid.
+func (opts CollectorOptions) Validate() error {
+	catcher := util.NewCatcher()
+
+	catcher.Add(opts.Compression.Validate())
+	if opts.Compression == CompressionZstd {
+		catcher.NewWhen(opts.CompressionLevel < 0 || opts.CompressionLevel > 22,
+			"zstd compression level must be between 1 and 22")
+	} else {
+		catcher.NewWhen(opts.CompressionLevel < 0 || opts.CompressionLevel > 9,
+			"zlib compression level must be between 1 and 9")
+	}
+
+	catcher.NewWhen(opts.MaxChunkSize < 0, "maximum chunk size must not be negative")
+
+	return catcher.Resolve()
 }
@@ -0,0 +1,283 @@
+package ftdc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/mongodb/ftdc/util"
+	"github.com/pkg/errors"
+)
+
+// AppendOptions configures an AppendingCollector.
+type AppendOptions struct {
+	// Path is the path of the FTDC file, which is created if it
+	// does not exist.
+	Path string
+
+	// FlushSamples is the number of samples that the collector
+	// holds before appending them to the file, as a chunk, which
+	// defaults to 300, as in mongod.
+	FlushSamples int
+}
+
+// Validate returns an error if the options are not valid.
+func (opts AppendOptions) Validate() error {
+	catcher := util.NewCatcher()
+
+	catcher.NewWhen(opts.Path == "", "must specify a path")
+	catcher.NewWhen(opts.FlushSamples < 0, "flush samples must not be negative")
+
+	return catcher.Resolve()
+}
+
+// AppendState describes the contents of the file that an
+// AppendingCollector opened.
+type AppendState struct {
+	// Size is the size of the existing content of the file, in
+	// bytes, which the collector appends to.
+	Size int64
+
+	// Truncated is the number of bytes that were removed from the
+	// end of the file because they did not hold a complete, valid
+	// document, which happens when a process stops while it is
+	// writing to the file.
+	Truncated int64
+
+	// Metadata is the content ("doc") of the last metadata (type
+	// 0) document in the file, or nil if there were none.
+	Metadata *birch.Document
+
+	// PeriodicMetadata is the complete periodic metadata as of
+	// the end of the file, reconstructed from the periodic
+	// metadata (type 2) documents, or nil if there were none.
+	PeriodicMetadata *birch.Document
+}
+
+// AppendingCollector is a Collector that appends its chunks to an
+// existing FTDC file.
+type AppendingCollector interface {
+	PeriodicMetadataCollector
+
+	// Flush appends the samples in the collector to the file.
+	Flush() error
+
+	// Close flushes the collector, and closes the file.
+	Close() error
+
+	// Existing describes the content of the file when the
+	// collector opened it.
+	Existing() AppendState
+}
+
+type appendingCollector struct {
+	Collector
+	opts     AppendOptions
+	file     *os.File
+	existing AppendState
+}
+
+// NewAppendingCollector wraps a collector, appending its chunks to the
+// FTDC file at the path in the options, so that a process that
+// restarts can continue the same file. The collector validates the
+// existing content of the file, and removes a partially written
+// document from the end of the file, including a chunk that can't be
+// decoded, but returns an error if the file is damaged anywhere else.
+//
+// The metadata of the last metadata document in the file is set as
+// the wrapped collector's metadata, unless the collector's metadata is
+// set again. The periodic metadata in the file is available from
+// Existing, and the first periodic metadata that the collector
+// records holds the complete metadata, as when mongod restarts.
+//
+// The wrapped collector's chunks are appended to the file every
+// FlushSamples samples, or, if the collector rejects a sample (e.g.
+// because it is full, or because the schema changed), before the
+// sample is added again. As with the other collectors, the collector
+// is not safe for concurrent use.
+func NewAppendingCollector(collector Collector, opts AppendOptions) (AppendingCollector, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid appending collector options")
+	}
+
+	if opts.FlushSamples == 0 {
+		opts.FlushSamples = defaultRotatingFlushSamples
+	}
+
+	file, err := os.OpenFile(opts.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem opening '%s'", opts.Path)
+	}
+
+	existing, err := prepareAppend(file)
+	if err != nil {
+		catcher := util.NewCatcher()
+		catcher.Wrapf(err, "problem preparing '%s'", opts.Path)
+		catcher.Add(file.Close())
+		return nil, catcher.Resolve()
+	}
+
+	if existing.Metadata != nil {
+		if err = collector.SetMetadata(existing.Metadata); err != nil {
+			catcher := util.NewCatcher()
+			catcher.Wrap(err, "problem setting metadata")
+			catcher.Add(file.Close())
+			return nil, catcher.Resolve()
+		}
+	}
+
+	return &appendingCollector{
+		Collector: collector,
+		opts:      opts,
+		file:      file,
+		existing:  existing,
+	}, nil
+}
+
+func (c *appendingCollector) Existing() AppendState { return c.existing }
+
+func (c *appendingCollector) SetPeriodicMetadata(in interface{}) error {
+	return setPeriodicMetadata(c.Collector, in)
+}
+
+func (c *appendingCollector) Add(in interface{}) error {
+	return errors.WithStack(addAndFlush(c.Collector, in, c.opts.FlushSamples, c.Flush))
+}
+
+func (c *appendingCollector) Flush() error {
+	if c.file == nil {
+		return errors.New("collector is closed")
+	}
+
+	if c.Collector.Info().SampleCount == 0 {
+		return nil
+	}
+
+	payload, err := c.Collector.Resolve()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err = c.file.Write(payload); err != nil {
+		return errors.Wrapf(err, "problem writing to '%s'", c.opts.Path)
+	}
+
+	c.Collector.Reset()
+
+	return nil
+}
+
+func (c *appendingCollector) Close() error {
+	if c.file == nil {
+		return nil
+	}
+
+	catcher := util.NewCatcher()
+
+	catcher.Add(c.Flush())
+	catcher.Wrapf(c.file.Sync(), "problem syncing '%s'", c.opts.Path)
+	catcher.Wrapf(c.file.Close(), "problem closing '%s'", c.opts.Path)
+	c.file = nil
+
+	return catcher.Resolve()
+}
+
+// prepareAppend reads the existing content of the file, removes a
+// damaged document from the end of the file, and leaves the file
+// positioned at the end of the remaining content.
+func prepareAppend(file *os.File) (AppendState, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return AppendState{}, errors.WithStack(err)
+	}
+
+	var corrupt *ChunkError
+	next := newLenientReader(file, func(chunkErr *ChunkError) {
+		if corrupt == nil {
+			corrupt = chunkErr
+		}
+	})
+
+	var (
+		state    AppendState
+		periodic *PeriodicMetadata
+		last     sourceDocument
+	)
+
+	for {
+		source, err := next.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return AppendState{}, errors.Wrap(err, "problem reading file")
+		}
+
+		docType := source.doc.Lookup("type")
+		switch {
+		case isNum(0, docType):
+			if doc, ok := source.doc.Lookup("doc").MutableDocumentOK(); ok {
+				state.Metadata = doc
+			}
+		case isNum(2, docType):
+			if periodic, err = readPeriodicMetadata(source.doc, periodic); err != nil {
+				return AppendState{}, errors.Wrapf(err, "invalid periodic metadata at offset %d", source.offset)
+			}
+		}
+
+		last = source
+	}
+
+	state.Size = last.offset + last.size
+	if corrupt != nil && corrupt.Offset < state.Size {
+		return AppendState{}, errors.Wrap(corrupt, "file is damaged before the last document")
+	}
+
+	// the last document is the one that is most likely to have
+	// been damaged, so chunks are decoded to make sure that they
+	// are intact.
+	if last.doc != nil && isNum(1, last.doc.Lookup("type")) {
+		if err = validateChunkDocument(last.doc); err != nil {
+			state.Size = last.offset
+		}
+	}
+
+	if periodic != nil {
+		state.PeriodicMetadata = periodic.Document
+	}
+
+	state.Truncated = info.Size() - state.Size
+	if state.Truncated > 0 {
+		if err = file.Truncate(state.Size); err != nil {
+			return AppendState{}, errors.Wrap(err, "problem truncating file")
+		}
+		if err = file.Sync(); err != nil {
+			return AppendState{}, errors.Wrap(err, "problem syncing file")
+		}
+	}
+
+	if _, err = file.Seek(state.Size, io.SeekStart); err != nil {
+		return AppendState{}, errors.WithStack(err)
+	}
+
+	return state, nil
+}
+
+// validateChunkDocument returns an error if the metric chunk document
+// can't be decoded.
+func validateChunkDocument(doc *birch.Document) error {
+	data, err := doc.MarshalBSON()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, err := range Chunks(context.Background(), bytes.NewReader(data)) {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package ftdc
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendingCollector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(i int) *birch.Document {
+		return birch.NewDocument(
+			birch.EC.Time("ts", start.Add(time.Duration(i)*time.Second)),
+			birch.EC.Int64("counter", int64(i)),
+		)
+	}
+
+	// appendSamples adds the samples from first to last (exclusive)
+	// to the file, and returns the state of the file when it was
+	// opened.
+	appendSamples := func(t *testing.T, path string, first, last int) AppendState {
+		collector, err := NewAppendingCollector(NewBaseCollector(1000), AppendOptions{Path: path, FlushSamples: 10})
+		require.NoError(t, err)
+		for i := first; i < last; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+		require.NoError(t, collector.Close())
+		return collector.Existing()
+	}
+
+	t.Run("NewFile", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.ftdc")
+		state := appendSamples(t, path, 0, 25)
+		assert.Zero(t, state)
+		assert.Equal(t, counterRange(0, 25), readFileCounters(t, path))
+	})
+	t.Run("Continue", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.ftdc")
+		appendSamples(t, path, 0, 25)
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+
+		state := appendSamples(t, path, 25, 40)
+		assert.Equal(t, info.Size(), state.Size)
+		assert.Zero(t, state.Truncated)
+		assert.Equal(t, counterRange(0, 40), readFileCounters(t, path))
+	})
+	t.Run("Metadata", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.ftdc")
+		collector, err := NewAppendingCollector(NewBaseCollector(1000), AppendOptions{Path: path})
+		require.NoError(t, err)
+		require.NoError(t, collector.SetMetadata(birch.NewDocument(birch.EC.String("host", "example"))))
+		require.NoError(t, collector.SetPeriodicMetadata(birch.NewDocument(
+			birch.EC.SubDocument("params", birch.NewDocument(birch.EC.Int32("level", 1))),
+		)))
+		require.NoError(t, collector.Add(sample(0)))
+		require.NoError(t, collector.Close())
+
+		state := appendSamples(t, path, 1, 5)
+		require.NotNil(t, state.Metadata)
+		assert.Equal(t, "example", state.Metadata.Lookup("host").StringValue())
+		require.NotNil(t, state.PeriodicMetadata)
+		assert.Equal(t, int32(1), state.PeriodicMetadata.Lookup("params").MutableDocument().Lookup("level").Int32())
+
+		// the recovered metadata is written with the new chunks.
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		chunks := 0
+		for chunk, err := range Chunks(ctx, bytes.NewReader(data)) {
+			require.NoError(t, err)
+			assert.Equal(t, "example", chunk.GetMetadata().Lookup("doc").MutableDocument().Lookup("host").StringValue())
+			chunks++
+		}
+		assert.Equal(t, 2, chunks)
+	})
+	t.Run("PartialDocument", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.ftdc")
+		appendSamples(t, path, 0, 20)
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		appendSamples(t, path, 20, 30)
+
+		// remove the end of the last chunk, as if the process
+		// stopped while writing it.
+		require.NoError(t, os.Truncate(path, info.Size()+20))
+
+		state := appendSamples(t, path, 20, 30)
+		assert.Equal(t, info.Size(), state.Size)
+		assert.Equal(t, int64(20), state.Truncated)
+		assert.Equal(t, counterRange(0, 30), readFileCounters(t, path))
+	})
+	t.Run("DamagedChunk", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.ftdc")
+		appendSamples(t, path, 0, 10)
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		appendSamples(t, path, 10, 20)
+
+		// the last chunk is a valid document, but its payload
+		// can't be decoded.
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		doc, err := birch.ReadDocument(data[info.Size():])
+		require.NoError(t, err)
+		doc.Set(birch.EC.Binary("data", []byte("not compressed")))
+		damaged, err := doc.MarshalBSON()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, append(data[:info.Size()], damaged...), 0644))
+
+		state := appendSamples(t, path, 10, 20)
+		assert.Equal(t, info.Size(), state.Size)
+		assert.Equal(t, int64(len(damaged)), state.Truncated)
+		assert.Equal(t, counterRange(0, 20), readFileCounters(t, path))
+	})
+	t.Run("DamagedMiddle", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrics.ftdc")
+		appendSamples(t, path, 0, 30)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		copy(data, []byte{0xff, 0xff, 0xff, 0xff})
+		require.NoError(t, os.WriteFile(path, data, 0644))
+
+		_, err = NewAppendingCollector(NewBaseCollector(1000), AppendOptions{Path: path})
+		assert.Error(t, err)
+
+		// the file is not modified.
+		after, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, after)
+	})
+	t.Run("Options", func(t *testing.T) {
+		_, err := NewAppendingCollector(NewBaseCollector(1000), AppendOptions{})
+		assert.Error(t, err)
+		_, err = NewAppendingCollector(NewBaseCollector(1000), AppendOptions{Path: filepath.Join(t.TempDir(), "metrics.ftdc"), FlushSamples: -1})
+		assert.Error(t, err)
+	})
+}
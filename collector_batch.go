@@ -3,12 +3,15 @@ package ftdc
 import (
 	"bytes"
 
+	"github.com/evergreen-ci/birch"
 	"github.com/pkg/errors"
 )
 
 type batchCollector struct {
 	maxSamples int
+	opts       CollectorOptions
 	chunks     []*betterCollector
+	periodic   periodicMetadata
 }
 
 // NewBatchCollector constructs a collector implementation that
@@ -16,15 +19,27 @@ type batchCollector struct {
 // This implementation allows you break data into smaller components
 // for more efficient read operations.
 func NewBatchCollector(maxSamples int) Collector {
-	return newBatchCollector(maxSamples)
+	return newBatchCollector(maxSamples, CollectorOptions{})
 }
 
-func newBatchCollector(size int) *batchCollector {
+// NewBatchCollectorWithOptions is the same as NewBatchCollector, but
+// uses the options to control how chunks are encoded.
+func NewBatchCollectorWithOptions(maxSamples int, opts CollectorOptions) (Collector, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid collector options")
+	}
+
+	return newBatchCollector(maxSamples, opts), nil
+}
+
+func newBatchCollector(size int, opts CollectorOptions) *batchCollector {
 	return &batchCollector{
 		maxSamples: size,
+		opts:       opts,
 		chunks: []*betterCollector{
 			{
 				maxDeltas: size,
+				opts:      opts,
 			},
 		},
 	}
@@ -36,31 +51,52 @@ func (c *batchCollector) Info() CollectorInfo {
 		info := c.Info()
 		out.MetricsCount += info.MetricsCount
 		out.SampleCount += info.SampleCount
+		out.PayloadSize += info.PayloadSize
 	}
 	return out
 }
 
 func (c *batchCollector) Reset() {
-	c.chunks = []*betterCollector{{maxDeltas: c.maxSamples}}
+	c.chunks = []*betterCollector{{maxDeltas: c.maxSamples, opts: c.opts}}
 }
 
 func (c *batchCollector) SetMetadata(in interface{}) error {
 	return errors.WithStack(c.chunks[0].SetMetadata(in))
 }
 
+func (c *batchCollector) SetPeriodicMetadata(in interface{}) error {
+	return errors.WithStack(c.periodic.set(in, c.addPeriodicMetadata))
+}
+
+func (c *batchCollector) addPeriodicMetadata(update *birch.Document) {
+	c.chunks[len(c.chunks)-1].addPeriodicMetadata(update)
+}
+
 func (c *batchCollector) Add(in interface{}) error {
-	doc, err := readDocument(in)
+	doc, err := readSample(in, c.opts)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
 	last := c.chunks[len(c.chunks)-1]
 	if last.Info().SampleCount >= c.maxSamples {
-		last = &betterCollector{maxDeltas: c.maxSamples}
-		c.chunks = append(c.chunks, last)
+		last = c.addChunk(last)
+	}
+
+	err = last.Add(doc)
+	if err == errCollectorFull {
+		err = c.addChunk(last).Add(doc)
 	}
 
-	return errors.WithStack(last.Add(doc))
+	return errors.WithStack(err)
+}
+
+// addChunk starts a new chunk, which uses the compression ratio of the
+// previous chunk to estimate its size.
+func (c *batchCollector) addChunk(last *betterCollector) *betterCollector {
+	next := &betterCollector{maxDeltas: c.maxSamples, opts: c.opts, ratio: last.ratio}
+	c.chunks = append(c.chunks, next)
+	return next
 }
 
 func (c *batchCollector) Resolve() ([]byte, error) {
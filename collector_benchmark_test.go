@@ -2,7 +2,11 @@ package ftdc
 
 import (
 	"context"
+	"io"
 	"testing"
+	"time"
+
+	"github.com/evergreen-ci/birch"
 )
 
 func BenchmarkCollectorInterface(b *testing.B) {
@@ -39,3 +43,39 @@ func BenchmarkCollectorInterface(b *testing.B) {
 		})
 	}
 }
+
+func BenchmarkConcurrentCollectors(b *testing.B) {
+	for _, collect := range []struct {
+		name    string
+		factory func() Collector
+	}{
+		{
+			name:    "Synchronized",
+			factory: func() Collector { return NewSynchronizedCollector(NewStreamingCollector(1000, io.Discard)) },
+		},
+		{
+			name: "Sharded",
+			factory: func() Collector {
+				collector, _ := NewShardedCollector(NewStreamingCollector(1000, io.Discard), ShardedOptions{})
+				return collector
+			},
+		},
+	} {
+		b.Run(collect.name, func(b *testing.B) {
+			collector := collect.factory()
+			b.SetParallelism(32)
+			b.RunParallel(func(pb *testing.PB) {
+				for i := int64(0); pb.Next(); i++ {
+					collector.Add(birch.NewDocument( // nolint
+						birch.EC.Time("ts", time.Now()),
+						birch.EC.Int64("counter", i),
+						birch.EC.Int32("gauge", int32(i%7)),
+					))
+				}
+			})
+			if closer, ok := collector.(io.Closer); ok {
+				closer.Close() // nolint
+			}
+		})
+	}
+}
@@ -5,19 +5,52 @@ import (
 	"time"
 
 	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
 	"github.com/pkg/errors"
 )
 
 type betterCollector struct {
 	metadata   *birch.Document
+	periodic   periodicMetadata
+	updates    []*birch.Document
 	reference  *birch.Document
 	startedAt  time.Time
 	lastSample *extractedMetrics
 	deltas     []int64
 	numSamples int
 	maxDeltas  int
+	opts       CollectorOptions
+
+	// rawSize is the size of the uncompressed payload. The
+	// compressed size is estimated from the size of the payload
+	// when it was last compressed (baseSize, for a payload of
+	// baseRawSize bytes, after baseSamples samples), and the ratio
+	// at which the samples added since then compress. The ratio
+	// starts at 1, which never underestimates, and is refined by
+	// compressing the payload when the estimate exceeds the
+	// maximum chunk size, which is the only time that payloads
+	// are compressed before Resolve.
+	rawSize     int
+	baseRawSize int
+	baseSize    int
+	baseSamples int
+	ratio       float64
 }
 
+// errCollectorFull is returned by the base collector when there's no
+// room for another sample in the chunk.
+var errCollectorFull = errors.New("collector is overfull")
+
+// chunkDocumentOverhead is an upper bound on the size of a metric
+// chunk document, excluding its payload.
+const chunkDocumentOverhead = 64
+
+// measureInterval is the number of samples that the collector adds
+// between compressions of the payload, once the ratio at which the
+// samples compress is known, so that a chunk that's near the maximum
+// size isn't compressed for every sample.
+const measureInterval = 8
+
 // NewBasicCollector provides a basic FTDC data collector that mirrors
 // the server's implementation. The Add method will error if you
 // attempt to add more than the specified number of records (plus one,
@@ -28,6 +61,19 @@ func NewBaseCollector(maxSize int) Collector {
 	}
 }
 
+// NewBaseCollectorWithOptions is the same as NewBaseCollector, but
+// uses the options to control how chunks are encoded.
+func NewBaseCollectorWithOptions(maxSize int, opts CollectorOptions) (Collector, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid collector options")
+	}
+
+	return &betterCollector{
+		maxDeltas: maxSize,
+		opts:      opts,
+	}, nil
+}
+
 func (c *betterCollector) SetMetadata(in interface{}) error {
 	doc, err := readDocument(in)
 	if err != nil {
@@ -37,11 +83,30 @@ func (c *betterCollector) SetMetadata(in interface{}) error {
 	c.metadata = doc
 	return nil
 }
+
+// SetPeriodicMetadata records the periodic metadata. The previous
+// metadata is kept when the collector is reset, so that the periodic
+// metadata documents in the output of a collector that is resolved
+// and reset repeatedly, like the streaming collector, form a single
+// sequence of deltas.
+func (c *betterCollector) SetPeriodicMetadata(in interface{}) error {
+	return errors.WithStack(c.periodic.set(in, c.addPeriodicMetadata))
+}
+
+func (c *betterCollector) addPeriodicMetadata(update *birch.Document) {
+	c.updates = append(c.updates, update)
+}
+
 func (c *betterCollector) Reset() {
+	c.updates = nil
 	c.reference = nil
 	c.lastSample = nil
 	c.deltas = nil
 	c.numSamples = 0
+	c.rawSize = 0
+	c.baseRawSize = 0
+	c.baseSize = 0
+	c.baseSamples = 0
 }
 
 func (c *betterCollector) Info() CollectorInfo {
@@ -59,11 +124,72 @@ func (c *betterCollector) Info() CollectorInfo {
 	return CollectorInfo{
 		SampleCount:  num + c.numSamples,
 		MetricsCount: metricsCount,
+		PayloadSize:  c.estimateSize(c.rawSize),
 	}
 }
 
+// estimateSize returns the estimated size of the chunk document for
+// an uncompressed payload of the specified size.
+func (c *betterCollector) estimateSize(rawSize int) int {
+	if c.reference == nil {
+		return 0
+	}
+
+	ratio := c.ratio
+	if ratio == 0 {
+		ratio = 1
+	}
+
+	return c.baseSize + int(float64(rawSize-c.baseRawSize)*ratio) + chunkDocumentOverhead
+}
+
+// measure compresses the payload, and uses its size to refine the
+// estimate of the size of the chunk.
+func (c *betterCollector) measure() error {
+	data, err := c.getPayload()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if c.rawSize > c.baseRawSize && c.baseSize > 0 {
+		c.ratio = float64(len(data)-c.baseSize) / float64(c.rawSize-c.baseRawSize)
+		if c.ratio < 0.01 {
+			c.ratio = 0.01
+		}
+	}
+
+	c.baseRawSize = c.rawSize
+	c.baseSize = len(data)
+	c.baseSamples = c.numSamples
+
+	return nil
+}
+
+// fits returns true if a sample that adds the specified number of
+// bytes to the payload fits within the maximum chunk size.
+func (c *betterCollector) fits(rowSize int) bool {
+	if c.opts.MaxChunkSize <= 0 || c.estimateSize(c.rawSize+rowSize) <= c.opts.MaxChunkSize {
+		return true
+	}
+
+	// the estimate is based on the compression of earlier
+	// samples, so measure the current payload to refine it
+	// before deciding that the chunk is full, unless the payload
+	// was measured recently, and the estimate is already based
+	// on how these samples compress.
+	if c.ratio != 0 && c.numSamples-c.baseSamples < measureInterval {
+		return false
+	}
+
+	if err := c.measure(); err != nil {
+		return false
+	}
+
+	return c.estimateSize(c.rawSize+rowSize) <= c.opts.MaxChunkSize
+}
+
 func (c *betterCollector) Add(in interface{}) error {
-	doc, err := readDocument(in)
+	doc, err := readSample(in, c.opts)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -71,21 +197,30 @@ func (c *betterCollector) Add(in interface{}) error {
 	var metrics extractedMetrics
 	if c.reference == nil {
 		c.reference = doc
-		metrics, err = extractMetricsFromDocument(doc)
+		metrics, err = extractMetricsFromDocument(doc, c.opts)
 		if err != nil {
 			return errors.WithStack(err)
 		}
 		c.startedAt = metrics.ts
 		c.lastSample = &metrics
 		c.deltas = make([]int64, c.maxDeltas*len(c.lastSample.values))
+		size, _ := doc.Validate()
+		c.rawSize = int(size) + 8
+
+		// the reference document usually compresses much
+		// better than the samples, so measure it separately.
+		if c.opts.MaxChunkSize > 0 {
+			return errors.WithStack(c.measure())
+		}
+
 		return nil
 	}
 
 	if c.numSamples >= c.maxDeltas {
-		return errors.New("collector is overfull")
+		return errCollectorFull
 	}
 
-	metrics, err = extractMetricsFromDocument(doc)
+	metrics, err = extractMetricsFromDocument(doc, c.opts)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -96,25 +231,147 @@ func (c *betterCollector) Add(in interface{}) error {
 		)
 	}
 
-	var delta int64
-	for idx := range metrics.values {
-		if metrics.types[idx] != c.lastSample.types[idx] {
+	for idx := range metrics.types {
+		if metrics.types[idx] == c.lastSample.types[idx] {
+			continue
+		}
+
+		if _, ok := widerNumericType(metrics.types[idx], c.lastSample.types[idx]); !ok || !c.opts.WidenNumericTypes {
 			return errors.Errorf("unexpected schema change detected for sample types: [current=%v vs previous=%v]",
 				metrics.types, c.lastSample.types)
 		}
-		delta, err = extractDelta(metrics.values[idx], c.lastSample.values[idx])
+	}
+
+	// the sample is only recorded, and the metrics that it
+	// widens are only widened, once it's known to fit in the
+	// chunk.
+	var (
+		delta      int64
+		rowSize    int
+		widenings  []widening
+		row        = make([]int64, len(metrics.values))
+		sizeChange int
+	)
+	for idx := range metrics.values {
+		previous := c.lastSample.values[idx]
+		previousDelta := func(sample int) int64 { return c.deltas[getOffset(c.maxDeltas, sample, idx)] }
+
+		if metrics.types[idx] != c.lastSample.types[idx] {
+			w := c.planWidening(&metrics, idx)
+			widenings = append(widenings, w)
+			sizeChange += w.sizeChange
+			previous = widenMetricValue(previous, w.wider)
+			if w.deltas != nil {
+				previousDelta = func(sample int) int64 { return w.deltas[sample] }
+			}
+		}
+
+		delta, err = extractDelta(metrics.values[idx], previous)
 		if err != nil {
 			return errors.Wrap(err, "problem parsing data")
 		}
+		row[idx] = delta
+
+		// zeros are run-length encoded, so count two bytes
+		// for the start of each run.
+		switch {
+		case delta != 0:
+			rowSize += len(encodeValue(delta))
+		case c.numSamples == 0 || previousDelta(c.numSamples-1) != 0:
+			rowSize += 2
+		}
+	}
+
+	if !c.fits(rowSize + sizeChange) {
+		return errCollectorFull
+	}
+
+	for _, w := range widenings {
+		c.applyWidening(w)
+	}
+
+	for idx, delta := range row {
 		c.deltas[getOffset(c.maxDeltas, c.numSamples, idx)] = delta
 	}
 
 	c.numSamples++
+	c.rawSize += rowSize
 	c.lastSample = &metrics
 
 	return nil
 }
 
+// widening is the conversion of a metric, in the reference document
+// and the previous samples, to a wider type, which is planned before
+// a sample is added, and only applied once the sample is accepted.
+type widening struct {
+	metric int
+	wider  bsontype.Type
+	// deltas are the deltas of the previous samples for the
+	// wider type, or nil when they don't change.
+	deltas []int64
+	// sizeChange is the change in the size of the payload from
+	// the new deltas.
+	sizeChange int
+}
+
+// planWidening converts the metric, in the sample, to the wider of
+// its type in the sample and in the previous samples, and returns the
+// changes that the collector needs to make to widen the previous
+// samples, without making them.
+func (c *betterCollector) planWidening(metrics *extractedMetrics, metric int) widening {
+	wider, _ := widerNumericType(metrics.types[metric], c.lastSample.types[metric])
+	w := widening{metric: metric, wider: wider}
+
+	if wider != c.lastSample.types[metric] && wider == bsontype.Double {
+		// doubles are encoded as their bits, so the deltas of
+		// the previous samples change.
+		values := make([]int64, c.numSamples+1)
+		values[c.numSamples] = c.lastSample.values[metric].Int64()
+		for sample := c.numSamples; sample > 0; sample-- {
+			values[sample-1] = values[sample] - c.deltas[getOffset(c.maxDeltas, sample-1, metric)]
+		}
+		widenMetricColumn(values)
+
+		w.deltas = make([]int64, c.numSamples)
+		for sample := range w.deltas {
+			w.deltas[sample] = values[sample+1] - values[sample]
+			w.sizeChange += deltaSize(w.deltas[sample]) - deltaSize(c.deltas[getOffset(c.maxDeltas, sample, metric)])
+		}
+	}
+
+	metrics.values[metric] = widenMetricValue(metrics.values[metric], wider)
+	metrics.types[metric] = wider
+
+	return w
+}
+
+// applyWidening converts the metric in the reference document and the
+// previous samples, as planned by planWidening.
+func (c *betterCollector) applyWidening(w widening) {
+	if w.wider == c.lastSample.types[w.metric] {
+		return
+	}
+
+	for sample, delta := range w.deltas {
+		c.deltas[getOffset(c.maxDeltas, sample, w.metric)] = delta
+	}
+	c.rawSize += w.sizeChange
+
+	c.reference, _ = widenDocumentMetric(c.reference, w.metric, w.wider, 0, c.opts)
+	c.lastSample.values[w.metric] = widenMetricValue(c.lastSample.values[w.metric], w.wider)
+	c.lastSample.types[w.metric] = w.wider
+}
+
+// deltaSize returns the number of bytes that a non-zero delta adds to
+// the payload.
+func deltaSize(delta int64) int {
+	if delta == 0 {
+		return 0
+	}
+	return len(encodeValue(delta))
+}
+
 func (c *betterCollector) Resolve() ([]byte, error) {
 	if c.reference == nil {
 		return nil, errors.New("no reference document")
@@ -136,53 +393,19 @@ func (c *betterCollector) Resolve() ([]byte, error) {
 		}
 	}
 
-	_, err = birch.NewDocument(
-		birch.EC.Time("_id", c.startedAt),
-		birch.EC.Int32("type", 1),
-		birch.EC.Binary("data", data)).WriteTo(buf)
-	if err != nil {
-		return nil, errors.Wrap(err, "problem writing metric chunk document")
+	if err = writePeriodicMetadata(buf, c.updates); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err = writeChunkDocument(buf, c.startedAt, data, c.opts.Compression); err != nil {
+		return nil, errors.WithStack(err)
 	}
 
 	return buf.Bytes(), nil
 }
 
 func (c *betterCollector) getPayload() ([]byte, error) {
-	payload := bytes.NewBuffer([]byte{})
-	if _, err := c.reference.WriteTo(payload); err != nil {
-		return nil, errors.Wrap(err, "problem writing reference document")
-	}
-
-	payload.Write(encodeSizeValue(uint32(len(c.lastSample.values))))
-	payload.Write(encodeSizeValue(uint32(c.numSamples)))
-	zeroCount := int64(0)
-	for i := 0; i < len(c.lastSample.values); i++ {
-		for j := 0; j < c.numSamples; j++ {
-			delta := c.deltas[getOffset(c.maxDeltas, j, i)]
-
-			if delta == 0 {
-				zeroCount++
-				continue
-			}
-
-			if zeroCount > 0 {
-				payload.Write(encodeValue(0))
-				payload.Write(encodeValue(zeroCount - 1))
-				zeroCount = 0
-			}
-
-			payload.Write(encodeValue(delta))
-		}
-	}
-	if zeroCount > 0 {
-		payload.Write(encodeValue(0))
-		payload.Write(encodeValue(zeroCount - 1))
-	}
-
-	data, err := compressBuffer(payload.Bytes())
-	if err != nil {
-		return nil, errors.Wrap(err, "problem compressing payload")
-	}
-
-	return data, nil
+	return encodeChunkPayload(c.reference, len(c.lastSample.values), c.numSamples, c.opts, func(metric, sample int) int64 {
+		return c.deltas[getOffset(c.maxDeltas, sample, metric)]
+	})
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/mongodb/ftdc/util"
+	"github.com/pkg/errors"
 )
 
 type bufferedCollector struct {
@@ -29,13 +30,13 @@ func NewBufferedCollector(ctx context.Context, size int, coll Collector) Collect
 			case <-ctx.Done():
 				if len(c.pipe) != 0 {
 					for in := range c.pipe {
-						c.catcher.Add(c.Collector.Add(in))
+						c.catcher.Add(c.add(in))
 					}
 				}
 
 				return
 			case in := <-c.pipe:
-				c.catcher.Add(c.Collector.Add(in))
+				c.catcher.Add(c.add(in))
 			}
 		}
 	}()
@@ -51,6 +52,29 @@ func (c *bufferedCollector) Add(in interface{}) error {
 	}
 }
 
+// periodicMetadataUpdate holds periodic metadata, which the buffered
+// collector passes through the buffer with the samples, so that the
+// metadata is recorded in order with the samples.
+type periodicMetadataUpdate struct {
+	metadata interface{}
+}
+
+func (c *bufferedCollector) SetPeriodicMetadata(in interface{}) error {
+	if _, ok := c.Collector.(PeriodicMetadataCollector); !ok {
+		return errors.Errorf("collector %T does not support periodic metadata", c.Collector)
+	}
+
+	return c.Add(periodicMetadataUpdate{metadata: in})
+}
+
+func (c *bufferedCollector) add(in interface{}) error {
+	if update, ok := in.(periodicMetadataUpdate); ok {
+		return setPeriodicMetadata(c.Collector, update.metadata)
+	}
+
+	return c.Collector.Add(in)
+}
+
 func (c *bufferedCollector) Resolve() ([]byte, error) {
 	if c.catcher.HasErrors() {
 		return nil, c.catcher.Resolve()
@@ -3,14 +3,17 @@ package ftdc
 import (
 	"bytes"
 
+	"github.com/evergreen-ci/birch"
 	"github.com/pkg/errors"
 )
 
 type dynamicCollector struct {
 	maxSamples int
+	opts       CollectorOptions
 	chunks     []*batchCollector
 	hash       string
 	currentNum int
+	periodic   periodicMetadata
 }
 
 // NewDynamicCollector constructs a Collector that records metrics
@@ -22,10 +25,25 @@ type dynamicCollector struct {
 // particularly for documents with more complex schemas, so you may
 // wish to opt for a simpler collector in some cases.
 func NewDynamicCollector(maxSamples int) Collector {
+	return newDynamicCollector(maxSamples, CollectorOptions{})
+}
+
+// NewDynamicCollectorWithOptions is the same as NewDynamicCollector,
+// but uses the options to control how chunks are encoded.
+func NewDynamicCollectorWithOptions(maxSamples int, opts CollectorOptions) (Collector, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid collector options")
+	}
+
+	return newDynamicCollector(maxSamples, opts), nil
+}
+
+func newDynamicCollector(maxSamples int, opts CollectorOptions) *dynamicCollector {
 	return &dynamicCollector{
 		maxSamples: maxSamples,
+		opts:       opts,
 		chunks: []*batchCollector{
-			newBatchCollector(maxSamples),
+			newBatchCollector(maxSamples, opts),
 		},
 	}
 }
@@ -36,12 +54,13 @@ func (c *dynamicCollector) Info() CollectorInfo {
 		info := c.Info()
 		out.MetricsCount += info.MetricsCount
 		out.SampleCount += info.SampleCount
+		out.PayloadSize += info.PayloadSize
 	}
 	return out
 }
 
 func (c *dynamicCollector) Reset() {
-	c.chunks = []*batchCollector{newBatchCollector(c.maxSamples)}
+	c.chunks = []*batchCollector{newBatchCollector(c.maxSamples, c.opts)}
 	c.hash = ""
 }
 
@@ -49,14 +68,22 @@ func (c *dynamicCollector) SetMetadata(in interface{}) error {
 	return errors.WithStack(c.chunks[0].SetMetadata(in))
 }
 
+func (c *dynamicCollector) SetPeriodicMetadata(in interface{}) error {
+	return errors.WithStack(c.periodic.set(in, c.addPeriodicMetadata))
+}
+
+func (c *dynamicCollector) addPeriodicMetadata(update *birch.Document) {
+	c.chunks[len(c.chunks)-1].addPeriodicMetadata(update)
+}
+
 func (c *dynamicCollector) Add(in interface{}) error {
-	doc, err := readDocument(in)
+	doc, err := readSample(in, c.opts)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
 	if c.hash == "" {
-		docHash, num := metricKeyHash(doc)
+		docHash, num := metricKeyHash(doc, c.opts)
 		c.hash = docHash
 		c.currentNum = num
 		return errors.WithStack(c.chunks[0].Add(doc))
@@ -64,13 +91,14 @@ func (c *dynamicCollector) Add(in interface{}) error {
 
 	lastChunk := c.chunks[len(c.chunks)-1]
 
-	docHash, _ := metricKeyHash(doc)
+	docHash, _ := metricKeyHash(doc, c.opts)
 	if c.hash == docHash {
 		return errors.WithStack(lastChunk.Add(doc))
 	}
 
-	chunk := newBatchCollector(c.maxSamples)
+	chunk := newBatchCollector(c.maxSamples, c.opts)
 	c.chunks = append(c.chunks, chunk)
+	c.hash = docHash
 
 	return errors.WithStack(chunk.Add(doc))
 }
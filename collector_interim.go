@@ -0,0 +1,264 @@
+package ftdc
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mongodb/ftdc/util"
+	"github.com/pkg/errors"
+)
+
+// SyncPolicy determines when a collector with an interim file syncs
+// the interim file to stable storage.
+//
+// Regardless of the policy, the output is flushed, when it has a
+// Flush method (e.g. *bufio.Writer), and synced, when it has a Sync
+// method (e.g. *os.File), before the interim file is removed, so that
+// the samples are never only in memory once the interim file that
+// held them is gone.
+type SyncPolicy string
+
+const (
+	// SyncNone never syncs the interim file, and relies on the
+	// operating system to write it, which protects the samples
+	// that it holds from a crash of the process, but not of the
+	// system. This is the default, and matches mongod.
+	SyncNone SyncPolicy = "none"
+
+	// SyncInterim syncs each new interim file before it replaces
+	// the previous one.
+	SyncInterim SyncPolicy = "interim"
+
+	// SyncAlways syncs each new interim file, as SyncInterim, and
+	// also syncs the directory that holds it after the file is
+	// replaced or removed, so that the change to the directory
+	// survives a crash of the system.
+	SyncAlways SyncPolicy = "always"
+)
+
+// Validate returns an error if the policy is not supported. The zero
+// value is valid, and is the same as SyncNone.
+func (p SyncPolicy) Validate() error {
+	switch p {
+	case "", SyncNone, SyncInterim, SyncAlways:
+		return nil
+	default:
+		return errors.Errorf("unsupported sync policy '%s'", p)
+	}
+}
+
+// InterimOptions configures the interim file of a streaming
+// collector, which holds the samples that the collector has not yet
+// written to its output.
+type InterimOptions struct {
+	// Path is the path of the interim file.
+	Path string
+
+	// Samples is the number of samples that the collector adds
+	// between writes of the interim file, which defaults to 1, so
+	// that the file is written after every sample, as in mongod.
+	Samples int
+
+	// Sync determines when the collector syncs the interim file
+	// to stable storage.
+	Sync SyncPolicy
+}
+
+// Validate returns an error if the options are not valid.
+func (opts InterimOptions) Validate() error {
+	catcher := util.NewCatcher()
+
+	catcher.NewWhen(opts.Path == "", "must specify a path for the interim file")
+	catcher.NewWhen(opts.Samples < 0, "interim samples must not be negative")
+	catcher.Add(opts.Sync.Validate())
+
+	return catcher.Resolve()
+}
+
+// NewStreamingCollectorWithInterim is the same as
+// NewStreamingCollectorWithOptions, but also writes the samples that
+// it holds, as an FTDC stream, to an interim file, so that a crash
+// loses at most the samples since the interim file was last written.
+// The interim file is replaced atomically, and is removed once its
+// samples have been written to the output.
+//
+// When the collector is created, the samples in an existing interim
+// file, left by a previous process, are written to the output, as
+// mongod does; ReadWithInterim reads an output and its interim file
+// together, for files that are read while the process is running, or
+// that it never restarted to recover.
+func NewStreamingCollectorWithInterim(maxSamples int, writer io.Writer, opts CollectorOptions, interim InterimOptions) (Collector, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid collector options")
+	}
+
+	file, err := newInterimFile(writer, interim)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	c := newStreamingCollector(maxSamples, writer, opts)
+	c.interim = file
+
+	return c, nil
+}
+
+// NewStreamingDynamicCollectorWithInterim is the same as
+// NewStreamingDynamicCollectorWithOptions, but writes an interim file,
+// as NewStreamingCollectorWithInterim.
+func NewStreamingDynamicCollectorWithInterim(max int, writer io.Writer, opts CollectorOptions, interim InterimOptions) (Collector, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid collector options")
+	}
+
+	file, err := newInterimFile(writer, interim)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	c := &streamingDynamicCollector{
+		output:             writer,
+		streamingCollector: newStreamingCollector(max, writer, opts),
+	}
+	c.interim = file
+
+	return c, nil
+}
+
+// interimFile maintains the interim file of a streaming or rotating
+// collector.
+// The methods are no-ops on a nil interimFile, which is used when the
+// collector has no interim file.
+type interimFile struct {
+	opts    InterimOptions
+	pending int
+	// err is an error removing the interim file, which is
+	// reported by the next update, as Reset can't return errors.
+	err error
+}
+
+// newInterimFile writes the samples in an existing interim file to the
+// output, and removes it.
+func newInterimFile(output io.Writer, opts InterimOptions) (*interimFile, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid interim options")
+	}
+
+	if opts.Samples == 0 {
+		opts.Samples = 1
+	}
+
+	f := &interimFile{opts: opts}
+
+	data, err := readInterim(opts.Path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(data) > 0 {
+		if _, err = output.Write(data); err != nil {
+			return nil, errors.Wrap(err, "problem writing interim samples")
+		}
+	}
+
+	f.reset(output)
+
+	return f, errors.WithStack(f.err)
+}
+
+// update replaces the interim file with the samples that the collector
+// holds, every Samples samples.
+func (f *interimFile) update(c Collector) error {
+	if f == nil {
+		return nil
+	}
+
+	if f.err != nil {
+		err := f.err
+		f.err = nil
+		return err
+	}
+
+	f.pending++
+	if f.pending < f.opts.Samples || c.Info().SampleCount == 0 {
+		return nil
+	}
+	f.pending = 0
+
+	payload, err := c.Resolve()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = replaceFile(f.opts.Path, payload, f.opts.Sync == SyncInterim || f.opts.Sync == SyncAlways); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(f.syncDirectory())
+}
+
+// reset removes the interim file, as remove, after the collector's
+// samples have been written to the output, or discarded, and holds
+// any error for the next update.
+func (f *interimFile) reset(output io.Writer) {
+	if f == nil {
+		return
+	}
+
+	catcher := util.NewCatcher()
+	catcher.Add(f.err)
+	catcher.Add(f.remove(output))
+	f.err = catcher.Resolve()
+}
+
+// remove flushes and syncs the output, which holds the samples in the
+// interim file, and then removes the interim file. The output may be
+// nil when the samples are already in stable storage.
+func (f *interimFile) remove(output io.Writer) error {
+	if f == nil {
+		return nil
+	}
+
+	f.pending = 0
+
+	if flusher, ok := output.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return errors.Wrap(err, "problem flushing output")
+		}
+	}
+
+	if syncer, ok := output.(interface{ Sync() error }); ok {
+		if err := syncer.Sync(); err != nil {
+			return errors.Wrap(err, "problem syncing output")
+		}
+	}
+
+	if err := os.Remove(f.opts.Path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "problem removing interim file")
+	}
+
+	return errors.WithStack(f.syncDirectory())
+}
+
+// syncDirectory syncs the directory that holds the interim file, with
+// the SyncAlways policy.
+func (f *interimFile) syncDirectory() error {
+	if f.opts.Sync != SyncAlways {
+		return nil
+	}
+
+	dir, err := os.Open(filepath.Dir(f.opts.Path))
+	if err != nil {
+		return errors.Wrap(err, "problem opening interim file directory")
+	}
+
+	catcher := util.NewCatcher()
+	catcher.Wrap(dir.Sync(), "problem syncing interim file directory")
+	catcher.Add(dir.Close())
+
+	return catcher.Resolve()
+}
@@ -0,0 +1,168 @@
+package ftdc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamingCollectorInterim(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(i int) *birch.Document {
+		return birch.NewDocument(
+			birch.EC.Time("ts", start.Add(time.Duration(i)*time.Second)),
+			birch.EC.Int64("counter", int64(i)),
+		)
+	}
+
+	// readInterimCounters returns the counters in the output and
+	// the interim file.
+	readInterimCounters := func(t *testing.T, path, interim string) []int64 {
+		iter := ReadWithInterim(ctx, path, interim, ReadOptions{})
+		defer iter.Close()
+		out := []int64{}
+		for iter.Next() {
+			out = append(out, readIteratorCounters(t, iter.Chunk().Iterator(ctx))...)
+		}
+		require.NoError(t, iter.Err())
+		return out
+	}
+
+	openOutput := func(t *testing.T, path string) *os.File {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = file.Close() })
+		return file
+	}
+
+	t.Run("Interim", func(t *testing.T) {
+		dir := t.TempDir()
+		path, interim := filepath.Join(dir, "metrics.ftdc"), filepath.Join(dir, "metrics.interim")
+		collector, err := NewStreamingCollectorWithInterim(10, openOutput(t, path), CollectorOptions{}, InterimOptions{Path: interim})
+		require.NoError(t, err)
+		for i := 0; i < 15; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+
+		// the samples that were not written to the output are
+		// in the interim file.
+		assert.Equal(t, counterRange(10, 15), readFileCounters(t, interim))
+		assert.Equal(t, counterRange(0, 15), readInterimCounters(t, path, interim))
+
+		// the interim file is removed once its samples are in
+		// the output.
+		require.NoError(t, FlushCollector(collector, openOutput(t, path)))
+		assert.NoFileExists(t, interim)
+		assert.Equal(t, counterRange(0, 15), readInterimCounters(t, path, interim))
+	})
+	t.Run("Recover", func(t *testing.T) {
+		dir := t.TempDir()
+		path, interim := filepath.Join(dir, "metrics.ftdc"), filepath.Join(dir, "metrics.interim")
+		collector, err := NewStreamingCollectorWithInterim(10, openOutput(t, path), CollectorOptions{}, InterimOptions{Path: interim, Sync: SyncAlways})
+		require.NoError(t, err)
+		for i := 0; i < 15; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+
+		// the process stops, and the next process writes the
+		// samples in the interim file to the output.
+		collector, err = NewStreamingCollectorWithInterim(10, openOutput(t, path), CollectorOptions{}, InterimOptions{Path: interim, Sync: SyncAlways})
+		require.NoError(t, err)
+		assert.NoFileExists(t, interim)
+		for i := 15; i < 20; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+		require.NoError(t, FlushCollector(collector, openOutput(t, path)))
+		assert.Equal(t, counterRange(0, 20), readInterimCounters(t, path, interim))
+	})
+	t.Run("Duplicates", func(t *testing.T) {
+		dir := t.TempDir()
+		path, interim := filepath.Join(dir, "metrics.ftdc"), filepath.Join(dir, "metrics.interim")
+		collector, err := NewStreamingCollectorWithInterim(10, openOutput(t, path), CollectorOptions{}, InterimOptions{Path: interim})
+		require.NoError(t, err)
+		for i := 0; i < 5; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+		data, err := os.ReadFile(interim)
+		require.NoError(t, err)
+
+		// the process stops after writing the samples to the
+		// output, but before removing the interim file.
+		require.NoError(t, FlushCollector(collector, openOutput(t, path)))
+		require.NoError(t, os.WriteFile(interim, data, 0644))
+		assert.Equal(t, counterRange(0, 5), readInterimCounters(t, path, interim))
+	})
+	t.Run("Samples", func(t *testing.T) {
+		dir := t.TempDir()
+		path, interim := filepath.Join(dir, "metrics.ftdc"), filepath.Join(dir, "metrics.interim")
+		collector, err := NewStreamingCollectorWithInterim(100, openOutput(t, path), CollectorOptions{}, InterimOptions{Path: interim, Samples: 3, Sync: SyncInterim})
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+		assert.NoFileExists(t, interim)
+
+		for i := 2; i < 8; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+		assert.Equal(t, counterRange(0, 6), readInterimCounters(t, path, interim))
+	})
+	t.Run("BufferedOutput", func(t *testing.T) {
+		dir := t.TempDir()
+		path, interim := filepath.Join(dir, "metrics.ftdc"), filepath.Join(dir, "metrics.interim")
+		output := bufio.NewWriterSize(openOutput(t, path), 1024*1024)
+		collector, err := NewStreamingCollectorWithInterim(10, output, CollectorOptions{}, InterimOptions{Path: interim})
+		require.NoError(t, err)
+		for i := 0; i < 15; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+
+		// the output is flushed before the interim file that
+		// held the first chunk's samples is replaced, so no
+		// samples are only in the buffer.
+		assert.Zero(t, output.Buffered())
+		assert.Equal(t, counterRange(0, 15), readInterimCounters(t, path, interim))
+	})
+	t.Run("Dynamic", func(t *testing.T) {
+		dir := t.TempDir()
+		path, interim := filepath.Join(dir, "metrics.ftdc"), filepath.Join(dir, "metrics.interim")
+		collector, err := NewStreamingDynamicCollectorWithInterim(100, openOutput(t, path), CollectorOptions{}, InterimOptions{Path: interim})
+		require.NoError(t, err)
+		for i := 0; i < 5; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+
+		// a schema change writes the samples to the output,
+		// and starts a new interim file.
+		for i := 5; i < 8; i++ {
+			doc := sample(i)
+			doc.Append(birch.EC.Int64("extra", int64(i)))
+			require.NoError(t, collector.Add(doc))
+		}
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.NotZero(t, info.Size())
+		assert.Contains(t, readFileCounters(t, interim), int64(7))
+		assert.Equal(t, counterRange(0, 8), readInterimCounters(t, path, interim))
+	})
+	t.Run("Options", func(t *testing.T) {
+		_, err := NewStreamingCollectorWithInterim(10, &bytes.Buffer{}, CollectorOptions{}, InterimOptions{})
+		assert.Error(t, err)
+		_, err = NewStreamingCollectorWithInterim(10, &bytes.Buffer{}, CollectorOptions{}, InterimOptions{Path: filepath.Join(t.TempDir(), "interim"), Samples: -1})
+		assert.Error(t, err)
+		_, err = NewStreamingDynamicCollectorWithInterim(10, &bytes.Buffer{}, CollectorOptions{}, InterimOptions{Path: filepath.Join(t.TempDir(), "interim"), Sync: "sometimes"})
+		assert.Error(t, err)
+	})
+}
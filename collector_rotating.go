@@ -0,0 +1,432 @@
+package ftdc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mongodb/ftdc/util"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRotatingFlushSamples = 300
+	defaultRotatingMaxFileSize  = 10 * 1024 * 1024
+)
+
+// RotatingOptions configures a RotatingCollector.
+type RotatingOptions struct {
+	// Directory is the directory that holds the files, which is
+	// created if it does not exist.
+	Directory string
+
+	// FlushSamples is the number of samples that the collector
+	// holds before writing them to the current file, as a chunk,
+	// which defaults to 300, as in mongod.
+	FlushSamples int
+
+	// MaxFileSize is the size, in bytes, at which the collector
+	// starts a new file, which defaults to 10 megabytes, as in
+	// mongod.
+	MaxFileSize int64
+
+	// MaxFileAge, when non-zero, is the age at which the
+	// collector starts a new file, regardless of its size.
+	MaxFileAge time.Duration
+
+	// MaxDirectorySize, when non-zero, is the total size, in
+	// bytes, of the FTDC files in the directory that the
+	// collector retains. When the collector starts a new file,
+	// it removes the oldest files until the directory is within
+	// the limit. The current file is never removed.
+	MaxDirectorySize int64
+
+	// DisableInterim disables the interim file, which holds the
+	// samples that have not yet been written to the current
+	// file.
+	DisableInterim bool
+
+	// InterimSamples is the number of samples that the collector
+	// adds between writes of the interim file, which defaults to
+	// 1, as InterimOptions.Samples.
+	InterimSamples int
+
+	// Sync determines when the collector syncs the interim file
+	// to stable storage, as InterimOptions.Sync.
+	Sync SyncPolicy
+}
+
+// Validate returns an error if the options are not valid.
+func (opts RotatingOptions) Validate() error {
+	catcher := util.NewCatcher()
+
+	catcher.NewWhen(opts.Directory == "", "must specify a directory")
+	catcher.NewWhen(opts.FlushSamples < 0, "flush samples must not be negative")
+	catcher.NewWhen(opts.MaxFileSize < 0, "maximum file size must not be negative")
+	catcher.NewWhen(opts.MaxFileAge < 0, "maximum file age must not be negative")
+	catcher.NewWhen(opts.MaxDirectorySize < 0, "maximum directory size must not be negative")
+	catcher.NewWhen(opts.InterimSamples < 0, "interim samples must not be negative")
+	catcher.Add(opts.Sync.Validate())
+
+	return catcher.Resolve()
+}
+
+// RotatingCollector is a Collector that writes chunks to a series of
+// files in a directory, in the same layout as mongod's
+// diagnostic.data directory, which ReadDirectory reads.
+type RotatingCollector interface {
+	PeriodicMetadataCollector
+
+	// Flush writes the samples in the collector to the current
+	// file.
+	Flush() error
+
+	// Close flushes the collector, and closes the current file.
+	Close() error
+}
+
+type rotatingCollector struct {
+	Collector
+	opts    RotatingOptions
+	file    *os.File
+	name    string
+	size    int64
+	started time.Time
+	interim *interimFile
+	now     func() time.Time
+}
+
+// NewRotatingCollector wraps a collector, writing its chunks to files
+// in a directory, named like mongod's metrics files (e.g.
+// "metrics.2019-08-14T15-37-35Z-00000"), and starting a new file when
+// the current file reaches the maximum size or age. The wrapped
+// collector's chunks are written to the current file every
+// FlushSamples samples, or, if the collector rejects a sample (e.g.
+// because it is full, or because the schema changed), before the
+// sample is added again.
+//
+// Every InterimSamples samples that aren't written to the current
+// file, the collector writes the samples that it holds to the
+// "metrics.interim" file, so that they survive a crash, as the
+// streaming collectors do with an interim file (see
+// NewStreamingCollectorWithInterim). When the collector starts, the
+// contents of an existing interim file are written to the first file,
+// as mongod does.
+//
+// As with the other collectors, the collector is not safe for
+// concurrent use.
+func NewRotatingCollector(collector Collector, opts RotatingOptions) (RotatingCollector, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid rotating collector options")
+	}
+
+	if opts.FlushSamples == 0 {
+		opts.FlushSamples = defaultRotatingFlushSamples
+	}
+
+	if opts.MaxFileSize == 0 {
+		opts.MaxFileSize = defaultRotatingMaxFileSize
+	}
+
+	if err := os.MkdirAll(opts.Directory, 0755); err != nil {
+		return nil, errors.Wrapf(err, "problem creating directory '%s'", opts.Directory)
+	}
+
+	c := &rotatingCollector{
+		Collector: collector,
+		opts:      opts,
+		now:       time.Now,
+	}
+
+	if !opts.DisableInterim {
+		samples := opts.InterimSamples
+		if samples == 0 {
+			samples = 1
+		}
+		c.interim = &interimFile{opts: InterimOptions{
+			Path:    filepath.Join(opts.Directory, diagnosticInterimFile),
+			Samples: samples,
+			Sync:    opts.Sync,
+		}}
+	}
+
+	if err := c.recoverInterim(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c, nil
+}
+
+func (c *rotatingCollector) SetPeriodicMetadata(in interface{}) error {
+	return setPeriodicMetadata(c.Collector, in)
+}
+
+func (c *rotatingCollector) Add(in interface{}) error {
+	if err := addAndFlush(c.Collector, in, c.opts.FlushSamples, c.Flush); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(c.interim.update(c.Collector))
+}
+
+// addAndFlush adds the sample to the collector wrapped by a collector
+// that writes chunks to a file with flush. If the collector rejects
+// the sample, e.g. because it is full, or because the schema changed,
+// the samples that it holds are flushed, and the sample is added
+// again. The samples are also flushed when there are flushSamples of
+// them.
+func addAndFlush(collector Collector, in interface{}, flushSamples int, flush func() error) error {
+	if err := collector.Add(in); err != nil {
+		if collector.Info().SampleCount == 0 {
+			return errors.WithStack(err)
+		}
+
+		if err = flush(); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if err = collector.Add(in); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if collector.Info().SampleCount >= flushSamples {
+		return errors.WithStack(flush())
+	}
+
+	return nil
+}
+
+func (c *rotatingCollector) Flush() error {
+	if c.Collector.Info().SampleCount == 0 {
+		return nil
+	}
+
+	payload, err := c.Collector.Resolve()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = c.write(payload); err != nil {
+		return errors.WithStack(err)
+	}
+
+	c.Collector.Reset()
+
+	return errors.WithStack(c.interim.remove(c.output()))
+}
+
+func (c *rotatingCollector) Close() error {
+	catcher := util.NewCatcher()
+
+	catcher.Add(c.Flush())
+	catcher.Add(c.closeFile())
+
+	return catcher.Resolve()
+}
+
+// write writes the payload to the current file, starting a new file
+// first if the current file is too old, and closing the file after
+// if it is too large.
+func (c *rotatingCollector) write(payload []byte) error {
+	if c.file != nil && c.opts.MaxFileAge > 0 && c.now().Sub(c.started) >= c.opts.MaxFileAge {
+		if err := c.closeFile(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if c.file == nil {
+		if err := c.openFile(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	n, err := c.file.Write(payload)
+	c.size += int64(n)
+	if err != nil {
+		return errors.Wrapf(err, "problem writing to '%s'", c.name)
+	}
+
+	if c.size >= c.opts.MaxFileSize {
+		return errors.WithStack(c.closeFile())
+	}
+
+	return nil
+}
+
+// openFile creates a new file, named for the current time, and
+// removes old files to stay within the retention limit.
+func (c *rotatingCollector) openFile() error {
+	c.started = c.now().UTC()
+	stamp := c.started.Format(diagnosticFileTimeFormat)
+
+	for seq := 0; ; seq++ {
+		name := fmt.Sprintf("%s%s-%05d", diagnosticFilePrefix, stamp, seq)
+		file, err := os.OpenFile(filepath.Join(c.opts.Directory, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "problem creating '%s'", name)
+		}
+
+		c.file = file
+		c.name = name
+		c.size = 0
+		break
+	}
+
+	return errors.WithStack(c.enforceRetention())
+}
+
+func (c *rotatingCollector) closeFile() error {
+	if c.file == nil {
+		return nil
+	}
+
+	catcher := util.NewCatcher()
+	catcher.Wrapf(c.file.Sync(), "problem syncing '%s'", c.name)
+	catcher.Wrapf(c.file.Close(), "problem closing '%s'", c.name)
+	c.file = nil
+
+	return catcher.Resolve()
+}
+
+// enforceRetention removes the oldest files in the directory until
+// the total size of the files is within the limit.
+func (c *rotatingCollector) enforceRetention() error {
+	if c.opts.MaxDirectorySize <= 0 {
+		return nil
+	}
+
+	files, err := listDiagnosticFiles(os.DirFS(c.opts.Directory), ".")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var total int64
+	sizes := make([]int64, len(files))
+	for idx, file := range files {
+		info, err := os.Stat(filepath.Join(c.opts.Directory, file.name))
+		if err != nil {
+			continue
+		}
+		sizes[idx] = info.Size()
+		total += info.Size()
+	}
+
+	catcher := util.NewCatcher()
+	for idx, file := range files {
+		if total <= c.opts.MaxDirectorySize {
+			break
+		}
+
+		if file.interim || file.name == c.name {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(c.opts.Directory, file.name)); err != nil && !os.IsNotExist(err) {
+			catcher.Wrapf(err, "problem removing '%s'", file.name)
+			continue
+		}
+		total -= sizes[idx]
+	}
+
+	return catcher.Resolve()
+}
+
+// output returns the current file, or nil if the current file was
+// closed, and so synced, after the last write.
+func (c *rotatingCollector) output() io.Writer {
+	if c.file == nil {
+		return nil
+	}
+
+	return c.file
+}
+
+// recoverInterim writes the complete documents in an existing
+// interim file to a new file, and removes the interim file.
+func (c *rotatingCollector) recoverInterim() error {
+	if c.interim == nil {
+		return nil
+	}
+
+	data, err := readInterim(c.interim.opts.Path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if len(data) > 0 {
+		if err = c.write(data); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return errors.WithStack(c.interim.remove(c.output()))
+}
+
+// readInterim returns the complete documents in an interim file,
+// which are all of the documents unless the process stopped while
+// writing the file, or nil if the file does not exist.
+func readInterim(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "problem reading interim file")
+	}
+
+	buf := &bytes.Buffer{}
+	next := newLenientReader(bytes.NewReader(data), func(*ChunkError) {})
+	for {
+		source, err := next.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "problem reading interim file")
+		}
+
+		if _, err = source.doc.WriteTo(buf); err != nil {
+			return nil, errors.Wrap(err, "problem recovering interim file")
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// replaceFile atomically replaces the contents of the file, by
+// writing to a temporary file in the same directory and renaming it.
+// When sync is true, the temporary file is synced before it replaces
+// the file, so that the new contents survive a system crash.
+func replaceFile(path string, data []byte, sync bool) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "problem creating temporary file")
+	}
+
+	catcher := util.NewCatcher()
+	_, err = tmp.Write(data)
+	catcher.Add(err)
+	if sync && !catcher.HasErrors() {
+		catcher.Add(tmp.Sync())
+	}
+	catcher.Add(tmp.Close())
+	if catcher.HasErrors() {
+		catcher.Add(os.Remove(tmp.Name()))
+		return errors.Wrapf(catcher.Resolve(), "problem writing '%s'", path)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		catcher.Add(err)
+		catcher.Add(os.Remove(tmp.Name()))
+		return errors.Wrapf(catcher.Resolve(), "problem replacing '%s'", path)
+	}
+
+	return nil
+}
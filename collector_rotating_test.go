@@ -0,0 +1,259 @@
+package ftdc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingCollector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(i int) *birch.Document {
+		return birch.NewDocument(
+			birch.EC.Time("ts", start.Add(time.Duration(i)*time.Second)),
+			birch.EC.Int64("counter", int64(i)),
+			birch.EC.Int32("gauge", int32(i%7)),
+		)
+	}
+
+	readDirectoryCounters := func(t *testing.T, dir string) []int64 {
+		return readIteratorCounters(t, ReadDirectoryMetrics(ctx, dir, ReadOptions{}))
+	}
+
+	listFiles := func(t *testing.T, dir string) []string {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		out := []string{}
+		for _, entry := range entries {
+			out = append(out, entry.Name())
+		}
+		return out
+	}
+
+	// clock returns a function that advances the time by the
+	// interval every time it's called.
+	clock := func(interval time.Duration) func() time.Time {
+		now := start
+		return func() time.Time {
+			now = now.Add(interval)
+			return now
+		}
+	}
+
+	t.Run("Rotation", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "diagnostic.data")
+		collector, err := NewRotatingCollector(NewBaseCollector(100), RotatingOptions{
+			Directory:    dir,
+			FlushSamples: 10,
+			MaxFileSize:  1024,
+		})
+		require.NoError(t, err)
+
+		for i := 0; i < 205; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+
+		// the samples that haven't been flushed are in the
+		// interim file.
+		assert.Contains(t, listFiles(t, dir), diagnosticInterimFile)
+		assert.Equal(t, 5, collector.Info().SampleCount)
+		assert.Equal(t, counterRange(0, 205), readDirectoryCounters(t, dir))
+
+		require.NoError(t, collector.Close())
+		files := listFiles(t, dir)
+		assert.NotContains(t, files, diagnosticInterimFile)
+		assert.True(t, len(files) > 2, "%v", files)
+		for _, name := range files {
+			file, ok := parseDiagnosticFileName(name)
+			require.True(t, ok, name)
+			assert.False(t, file.interim)
+
+			info, err := os.Stat(filepath.Join(dir, name))
+			require.NoError(t, err)
+			assert.True(t, info.Size() < 2048, "%s is %d bytes", name, info.Size())
+		}
+		assert.Equal(t, counterRange(0, 205), readDirectoryCounters(t, dir))
+	})
+	t.Run("Naming", func(t *testing.T) {
+		dir := t.TempDir()
+		collector, err := NewRotatingCollector(NewBaseCollector(100), RotatingOptions{
+			Directory:      dir,
+			FlushSamples:   10,
+			MaxFileSize:    1,
+			DisableInterim: true,
+		})
+		require.NoError(t, err)
+		collector.(*rotatingCollector).now = func() time.Time { return start.Add(90 * time.Minute) }
+
+		for i := 0; i < 30; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+		require.NoError(t, collector.Close())
+
+		assert.Equal(t, []string{
+			"metrics.2020-01-01T01-30-00Z-00000",
+			"metrics.2020-01-01T01-30-00Z-00001",
+			"metrics.2020-01-01T01-30-00Z-00002",
+		}, listFiles(t, dir))
+		assert.Equal(t, counterRange(0, 30), readDirectoryCounters(t, dir))
+	})
+	t.Run("Age", func(t *testing.T) {
+		dir := t.TempDir()
+		collector, err := NewRotatingCollector(NewBaseCollector(100), RotatingOptions{
+			Directory:    dir,
+			FlushSamples: 10,
+			MaxFileAge:   time.Minute,
+		})
+		require.NoError(t, err)
+		collector.(*rotatingCollector).now = clock(25 * time.Second)
+
+		for i := 0; i < 100; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+		require.NoError(t, collector.Close())
+
+		// every file is opened, and then checked twice,
+		// before it is too old.
+		assert.Len(t, listFiles(t, dir), 4)
+		assert.Equal(t, counterRange(0, 100), readDirectoryCounters(t, dir))
+	})
+	t.Run("Retention", func(t *testing.T) {
+		dir := t.TempDir()
+		collector, err := NewRotatingCollector(NewBaseCollector(100), RotatingOptions{
+			Directory:        dir,
+			FlushSamples:     10,
+			MaxFileSize:      1024,
+			MaxDirectorySize: 4096,
+		})
+		require.NoError(t, err)
+		collector.(*rotatingCollector).now = clock(time.Second)
+
+		for i := 0; i < 500; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+
+			var total int64
+			for _, name := range listFiles(t, dir) {
+				info, err := os.Stat(filepath.Join(dir, name))
+				require.NoError(t, err)
+				total += info.Size()
+			}
+			assert.True(t, total < 4096+2048, "%d bytes", total)
+		}
+		require.NoError(t, collector.Close())
+
+		// the oldest samples were removed.
+		read := readDirectoryCounters(t, dir)
+		require.NotEmpty(t, read)
+		assert.True(t, read[0] > 0)
+		assert.Equal(t, counterRange(int(read[0]), 500), read)
+	})
+	t.Run("SchemaChange", func(t *testing.T) {
+		dir := t.TempDir()
+		collector, err := NewRotatingCollector(NewBaseCollector(100), RotatingOptions{Directory: dir})
+		require.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+		require.NoError(t, collector.Add(birch.NewDocument(birch.EC.Time("ts", start.Add(time.Minute)), birch.EC.Int64("counter", 20))))
+		require.NoError(t, collector.Close())
+
+		assert.Equal(t, counterRange(0, 21), readDirectoryCounters(t, dir))
+	})
+	t.Run("RecoverInterim", func(t *testing.T) {
+		dir := t.TempDir()
+		collector, err := NewRotatingCollector(NewBaseCollector(100), RotatingOptions{Directory: dir, FlushSamples: 50})
+		require.NoError(t, err)
+		collector.(*rotatingCollector).now = clock(time.Second)
+		for i := 0; i < 75; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+
+		// simulate a crash, by abandoning the collector
+		// without closing it, and leave a temporary file
+		// behind, as if it crashed while writing the interim
+		// file.
+		require.NoError(t, os.WriteFile(filepath.Join(dir, diagnosticInterimFile+".123.tmp"), []byte("partial"), 0644))
+		interim, err := os.ReadFile(filepath.Join(dir, diagnosticInterimFile))
+		require.NoError(t, err)
+
+		collector, err = NewRotatingCollector(NewBaseCollector(100), RotatingOptions{Directory: dir, FlushSamples: 50})
+		require.NoError(t, err)
+		collector.(*rotatingCollector).now = clock(time.Hour)
+		assert.NotContains(t, listFiles(t, dir), diagnosticInterimFile)
+
+		for i := 75; i < 100; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+		require.NoError(t, collector.Close())
+		assert.Equal(t, counterRange(0, 100), readDirectoryCounters(t, dir))
+
+		// a damaged interim file is recovered up to the
+		// damage.
+		dir = t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, diagnosticInterimFile), append(interim, interim[:len(interim)/2]...), 0644))
+		collector, err = NewRotatingCollector(NewBaseCollector(100), RotatingOptions{Directory: dir})
+		require.NoError(t, err)
+		require.NoError(t, collector.Close())
+		assert.Equal(t, counterRange(50, 75), readDirectoryCounters(t, dir))
+	})
+	t.Run("InterimSamples", func(t *testing.T) {
+		dir := t.TempDir()
+		collector, err := NewRotatingCollector(NewBaseCollector(100), RotatingOptions{
+			Directory:      dir,
+			FlushSamples:   50,
+			InterimSamples: 3,
+			Sync:           SyncAlways,
+		})
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+		assert.NotContains(t, listFiles(t, dir), diagnosticInterimFile)
+
+		require.NoError(t, collector.Add(sample(2)))
+		assert.Contains(t, listFiles(t, dir), diagnosticInterimFile)
+		assert.Equal(t, counterRange(0, 3), readDirectoryCounters(t, dir))
+
+		// the interim file is only rewritten every third
+		// sample.
+		for i := 3; i < 5; i++ {
+			require.NoError(t, collector.Add(sample(i)))
+		}
+		assert.Equal(t, counterRange(0, 3), readDirectoryCounters(t, dir))
+
+		require.NoError(t, collector.Close())
+		assert.NotContains(t, listFiles(t, dir), diagnosticInterimFile)
+		assert.Equal(t, counterRange(0, 5), readDirectoryCounters(t, dir))
+	})
+	t.Run("Validate", func(t *testing.T) {
+		for _, opts := range []RotatingOptions{
+			{},
+			{Directory: t.TempDir(), FlushSamples: -1},
+			{Directory: t.TempDir(), MaxFileSize: -1},
+			{Directory: t.TempDir(), MaxFileAge: -1},
+			{Directory: t.TempDir(), MaxDirectorySize: -1},
+			{Directory: t.TempDir(), InterimSamples: -1},
+			{Directory: t.TempDir(), Sync: "sometimes"},
+		} {
+			_, err := NewRotatingCollector(NewBaseCollector(10), opts)
+			assert.Error(t, err)
+		}
+
+		file := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(file, nil, 0644))
+		_, err := NewRotatingCollector(NewBaseCollector(10), RotatingOptions{Directory: file})
+		require.Error(t, err)
+		assert.True(t, strings.Contains(err.Error(), "directory"))
+	})
+}
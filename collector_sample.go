@@ -23,6 +23,10 @@ func NewSamplingCollector(minimumInterval time.Duration, collector Collector) Co
 	}
 }
 
+func (c *samplingCollector) SetPeriodicMetadata(in interface{}) error {
+	return setPeriodicMetadata(c.Collector, in)
+}
+
 func (c *samplingCollector) Add(d interface{}) error {
 	if time.Since(c.lastCollection) < c.minimumInterval {
 		return nil
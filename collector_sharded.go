@@ -0,0 +1,399 @@
+package ftdc
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
+	"github.com/mongodb/ftdc/util"
+	"github.com/pkg/errors"
+)
+
+const defaultShardedMergeSamples = 1000
+
+// ShardedOptions configures a sharded collector.
+type ShardedOptions struct {
+	// Shards is the number of lists that Add distributes the
+	// samples across, so that producers rarely push samples onto
+	// the same list at the same time, which defaults to
+	// GOMAXPROCS.
+	Shards int
+
+	// MergeSamples is the number of buffered samples at which the
+	// collector merges the lists into the wrapped collector, in
+	// the background, which defaults to 1000.
+	MergeSamples int
+
+	// TimestampKey is the key (see Metric.Key) of the date-time
+	// field that orders the samples. When empty, or when a sample
+	// has no date-time field with the key, the first date-time
+	// field in the sample is used, as with
+	// ReadOptions.TimestampKey.
+	TimestampKey string
+
+	// NanosecondTimes and UnsignedIntegers have the same meaning
+	// as in CollectorOptions, and should match the wrapped
+	// collector's options, because the sharded collector converts
+	// the samples to documents in Add, so that producers do the
+	// conversion concurrently.
+	NanosecondTimes  bool
+	UnsignedIntegers bool
+}
+
+// Validate returns an error if the options are not valid.
+func (opts ShardedOptions) Validate() error {
+	catcher := util.NewCatcher()
+
+	catcher.NewWhen(opts.Shards < 0, "shards must not be negative")
+	catcher.NewWhen(opts.MergeSamples < 0, "merge samples must not be negative")
+
+	return catcher.Resolve()
+}
+
+// ShardedCollector is a Collector for use by many goroutines, which
+// merges the samples into the collector that it wraps in a background
+// goroutine (see NewShardedCollector).
+type ShardedCollector interface {
+	PeriodicMetadataCollector
+
+	// Close stops the background goroutine, waiting for a merge
+	// that is running, and merges the remaining samples into the
+	// wrapped collector, returning the errors from merges that
+	// Add hasn't returned. The collector can be resolved after it
+	// is closed, but Add returns an error.
+	Close() error
+}
+
+type shardedCollector struct {
+	Collector
+	opts    ShardedOptions
+	shards  []sampleShard
+	seq     atomic.Uint64
+	pending atomic.Int64
+	// err holds the errors from merges that haven't been
+	// returned yet.
+	err    atomic.Pointer[shardedError]
+	closed atomic.Bool
+	// signal wakes the background goroutine to merge the
+	// samples, and stop and done stop the goroutine and report
+	// that it has stopped.
+	signal chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	// mu guards the wrapped collector, which is only used by one
+	// goroutine at a time.
+	mu sync.Mutex
+}
+
+// sampleShard is a list of samples, which producers push samples onto,
+// without locking, until a merge takes the list.
+type sampleShard struct {
+	head atomic.Pointer[shardedSample]
+}
+
+type shardedSample struct {
+	doc  *birch.Document
+	time int64
+	seq  uint64
+	next *shardedSample
+}
+
+type shardedError struct {
+	err error
+}
+
+// NewShardedCollector wraps a collector for use by many goroutines,
+// as NewSynchronizedCollector, but rather than holding the wrapped
+// collector's lock for every Add, Add pushes the sample onto one of
+// several lists without taking any locks, so producers neither wait
+// for each other nor for the wrapped collector, or for any writes
+// that it does. Producers that push onto the same list at the same
+// time only retry the push.
+//
+// When the lists hold MergeSamples samples, a background goroutine
+// merges them into the wrapped collector. The lists are also merged,
+// by the caller, before the collector is resolved, or its Info or
+// periodic metadata are read or set. The goroutine runs until the
+// collector is closed.
+//
+// The samples are only ordered within a merge: each merge adds the
+// buffered samples to the wrapped collector in the order of their
+// timestamps (see TimestampKey), and samples with the same timestamp
+// in the order in which Add was called. Samples without a timestamp
+// are ordered by the time that they were added. Samples are not
+// reordered across merges, so a sample that is added after a merge
+// starts, with an earlier timestamp than the samples in the merge,
+// follows them.
+//
+// Errors from the wrapped collector's Add during a merge are returned
+// by the next call to Add, which doesn't add its sample, or by
+// Resolve or Close, whichever comes first. The samples that the
+// wrapped collector rejects are dropped, so the wrapped collector
+// should write out its chunks as it fills, as the streaming and
+// rotating collectors do, rather than reject samples when it is full.
+func NewShardedCollector(collector Collector, opts ShardedOptions) (ShardedCollector, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid sharded collector options")
+	}
+
+	if opts.Shards == 0 {
+		opts.Shards = runtime.GOMAXPROCS(0)
+	}
+
+	if opts.MergeSamples == 0 {
+		opts.MergeSamples = defaultShardedMergeSamples
+	}
+
+	c := &shardedCollector{
+		Collector: collector,
+		opts:      opts,
+		shards:    make([]sampleShard, opts.Shards),
+		signal:    make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go c.mergeInBackground()
+
+	return c, nil
+}
+
+func (c *shardedCollector) Add(in interface{}) error {
+	if err := c.takeError(); err != nil {
+		return errors.Wrap(err, "problem merging samples")
+	}
+	if c.closed.Load() {
+		return errors.New("collector is closed")
+	}
+
+	doc, err := readSample(in, CollectorOptions{
+		NanosecondTimes:  c.opts.NanosecondTimes,
+		UnsignedIntegers: c.opts.UnsignedIntegers,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	sample := &shardedSample{doc: doc, seq: c.seq.Add(1)}
+	if ts, ok := sampleTime(doc, c.opts.TimestampKey); ok {
+		sample.time = ts
+	} else {
+		sample.time = time.Now().UnixNano()
+	}
+
+	shard := &c.shards[sample.seq%uint64(len(c.shards))]
+	for {
+		sample.next = shard.head.Load()
+		if shard.head.CompareAndSwap(sample.next, sample) {
+			break
+		}
+	}
+
+	// when the goroutine already has a signal, it will take
+	// this sample when it merges.
+	if c.pending.Add(1) >= int64(c.opts.MergeSamples) {
+		select {
+		case c.signal <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// mergeInBackground merges the samples whenever Add signals that the
+// lists hold MergeSamples samples, until the collector is closed.
+func (c *shardedCollector) mergeInBackground() {
+	defer close(c.done)
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-c.signal:
+			c.mu.Lock()
+			c.merge()
+			c.mu.Unlock()
+		}
+	}
+}
+
+// merge adds the buffered samples to the wrapped collector, in order,
+// and must be called with the collector's lock held.
+func (c *shardedCollector) merge() {
+	var samples []*shardedSample
+	for idx := range c.shards {
+		for sample := c.shards[idx].head.Swap(nil); sample != nil; sample = sample.next {
+			samples = append(samples, sample)
+		}
+	}
+	c.pending.Add(-int64(len(samples)))
+
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].time != samples[j].time {
+			return samples[i].time < samples[j].time
+		}
+		return samples[i].seq < samples[j].seq
+	})
+
+	catcher := util.NewCatcher()
+	for _, sample := range samples {
+		catcher.Add(c.Collector.Add(sample.doc))
+	}
+	c.addError(catcher.Resolve())
+}
+
+// addError records an error from a merge, for Add, Resolve, or Close
+// to return, and must be called with the collector's lock held.
+func (c *shardedCollector) addError(err error) {
+	if err == nil {
+		return
+	}
+
+	for {
+		prev := c.err.Load()
+		next := &shardedError{err: err}
+		if prev != nil {
+			catcher := util.NewCatcher()
+			catcher.Add(prev.err)
+			catcher.Add(err)
+			next.err = catcher.Resolve()
+		}
+
+		if c.err.CompareAndSwap(prev, next) {
+			return
+		}
+	}
+}
+
+// takeError returns the errors from merges that haven't been returned,
+// if any, and clears them.
+func (c *shardedCollector) takeError() error {
+	if prev := c.err.Swap(nil); prev != nil {
+		return prev.err
+	}
+
+	return nil
+}
+
+func (c *shardedCollector) SetMetadata(in interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Collector.SetMetadata(in)
+}
+
+func (c *shardedCollector) SetPeriodicMetadata(in interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the metadata follows the samples that were added before it.
+	c.merge()
+	return setPeriodicMetadata(c.Collector, in)
+}
+
+func (c *shardedCollector) Info() CollectorInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.merge()
+	return c.Collector.Info()
+}
+
+func (c *shardedCollector) Resolve() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.merge()
+	if err := c.takeError(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.Collector.Resolve()
+}
+
+func (c *shardedCollector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for idx := range c.shards {
+		for sample := c.shards[idx].head.Swap(nil); sample != nil; sample = sample.next {
+			c.pending.Add(-1)
+		}
+	}
+
+	c.Collector.Reset()
+	c.err.Store(nil)
+}
+
+func (c *shardedCollector) Close() error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	close(c.stop)
+	<-c.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.merge()
+	return errors.WithStack(c.takeError())
+}
+
+// sampleTime returns the time of the sample, in nanoseconds since the
+// epoch, from the date-time field with the key, or from the first
+// date-time field, as timestampMetric does for chunks.
+func sampleTime(doc *birch.Document, key string) (int64, bool) {
+	var (
+		out   int64
+		found bool
+	)
+
+	var visit func(doc *birch.Document, prefix string) bool
+	visit = func(doc *birch.Document, prefix string) bool {
+		for iter := doc.Iterator(); iter.Next(); {
+			elem := iter.Element()
+			path := elem.Key()
+			if prefix != "" {
+				path = prefix + "." + path
+			}
+
+			var (
+				ns int64
+				ok bool
+			)
+			switch val := elem.Value(); val.Type() {
+			case bsontype.DateTime:
+				ns, ok = val.Time().UnixNano(), true
+			case bsontype.EmbeddedDocument:
+				sub := val.MutableDocument()
+				if ns, ok = nanosecondTimeFromDocument(sub); !ok && visit(sub, path) {
+					return true
+				}
+			}
+			if !ok {
+				continue
+			}
+
+			if path == key {
+				out, found = ns, true
+				return true
+			}
+
+			if !found {
+				out, found = ns, true
+				if key == "" {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	visit(doc, "")
+
+	return out, found
+}
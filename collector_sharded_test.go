@@ -0,0 +1,221 @@
+package ftdc
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedCollector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	resolveCounters := func(t *testing.T, c Collector) []int64 {
+		out, err := c.Resolve()
+		require.NoError(t, err)
+		return readCounters(t, out)
+	}
+
+	// addConcurrently adds the samples from many goroutines, with
+	// the timestamps of the samples in the order of their
+	// counters, though the goroutines add them in any order.
+	addConcurrently := func(t *testing.T, c Collector, workers, samples int) {
+		wg := &sync.WaitGroup{}
+		for worker := 0; worker < workers; worker++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				for i := 0; i < samples; i++ {
+					counter := i*workers + worker
+					assert.NoError(t, c.Add(birch.NewDocument(
+						birch.EC.Time("ts", start.Add(time.Duration(counter)*time.Second)),
+						birch.EC.Int64("counter", int64(counter)),
+					)))
+				}
+			}(worker)
+		}
+		wg.Wait()
+	}
+
+	t.Run("Ordered", func(t *testing.T) {
+		c, err := NewShardedCollector(NewBaseCollector(10000), ShardedOptions{Shards: 8, MergeSamples: 10000})
+		require.NoError(t, err)
+		addConcurrently(t, c, 64, 100)
+
+		counters := resolveCounters(t, c)
+		require.Len(t, counters, 6400)
+		for i, counter := range counters {
+			require.Equal(t, int64(i), counter)
+		}
+	})
+	t.Run("Merges", func(t *testing.T) {
+		c, err := NewShardedCollector(NewBatchCollector(500), ShardedOptions{MergeSamples: 100})
+		require.NoError(t, err)
+		addConcurrently(t, c, 32, 100)
+		assert.Equal(t, 3200, c.Info().SampleCount)
+
+		// each sample is recorded once, though samples are
+		// only ordered within each merge.
+		seen := map[int64]bool{}
+		for _, counter := range resolveCounters(t, c) {
+			assert.False(t, seen[counter])
+			seen[counter] = true
+		}
+		assert.Len(t, seen, 3200)
+	})
+	t.Run("BackgroundMerge", func(t *testing.T) {
+		release := make(chan struct{})
+		wrapped := &blockingCollector{Collector: NewBaseCollector(100), release: release}
+		c, err := NewShardedCollector(wrapped, ShardedOptions{MergeSamples: 5})
+		require.NoError(t, err)
+
+		// the wrapped collector blocks the merges, but not
+		// the producers.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 20; i++ {
+				assert.NoError(t, c.Add(birch.NewDocument(birch.EC.Int64("counter", int64(i)))))
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "Add waited for the wrapped collector")
+		}
+
+		close(release)
+		assert.Equal(t, counterRange(0, 20), resolveCounters(t, c))
+	})
+	t.Run("AddOrder", func(t *testing.T) {
+		c, err := NewShardedCollector(NewBaseCollector(100), ShardedOptions{Shards: 4})
+		require.NoError(t, err)
+
+		// samples without timestamps, or with the same
+		// timestamp, are recorded in the order they're added.
+		for i := 0; i < 10; i++ {
+			require.NoError(t, c.Add(birch.NewDocument(birch.EC.Int64("counter", int64(i)))))
+		}
+		assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, resolveCounters(t, c))
+
+		c.Reset()
+		for i := 0; i < 10; i++ {
+			require.NoError(t, c.Add(birch.NewDocument(
+				birch.EC.Time("ts", start),
+				birch.EC.Int64("counter", int64(i)),
+			)))
+		}
+		assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, resolveCounters(t, c))
+	})
+	t.Run("TimestampKey", func(t *testing.T) {
+		c, err := NewShardedCollector(NewBaseCollector(100), ShardedOptions{TimestampKey: "nested.end"})
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, c.Add(birch.NewDocument(
+				birch.EC.Time("start", start.Add(time.Duration(i)*time.Second)),
+				birch.EC.SubDocument("nested", birch.NewDocument(
+					birch.EC.Time("end", start.Add(-time.Duration(i)*time.Second)),
+				)),
+				birch.EC.Int64("counter", int64(i)),
+			)))
+		}
+		assert.Equal(t, []int64{9, 8, 7, 6, 5, 4, 3, 2, 1, 0}, resolveCounters(t, c))
+	})
+	t.Run("Errors", func(t *testing.T) {
+		c, err := NewShardedCollector(NewBaseCollector(5), ShardedOptions{})
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, c.Add(birch.NewDocument(birch.EC.Int64("counter", int64(i)))))
+		}
+		_, err = c.Resolve()
+		assert.Error(t, err)
+
+		c.Reset()
+		assert.Zero(t, c.Info().SampleCount)
+		require.NoError(t, c.Add(birch.NewDocument(birch.EC.Int64("counter", 1))))
+		assert.Equal(t, []int64{1}, resolveCounters(t, c))
+
+		assert.Error(t, c.Add(map[string]string{"counter": "one"}))
+	})
+	t.Run("MergeErrors", func(t *testing.T) {
+		c, err := NewShardedCollector(NewBaseCollector(5), ShardedOptions{MergeSamples: 10})
+		require.NoError(t, err)
+		defer func() { _ = c.Close() }()
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, c.Add(birch.NewDocument(birch.EC.Int64("counter", int64(i)))))
+		}
+
+		// the background merge rejects the samples that don't
+		// fit, and a later Add reports it.
+		assert.Eventually(t, func() bool {
+			return c.Add(birch.NewDocument(birch.EC.Int64("counter", 10))) != nil
+		}, 10*time.Second, time.Millisecond)
+	})
+	t.Run("Close", func(t *testing.T) {
+		release := make(chan struct{})
+		wrapped := &blockingCollector{Collector: NewBaseCollector(100), release: release}
+		c, err := NewShardedCollector(wrapped, ShardedOptions{MergeSamples: 5})
+		require.NoError(t, err)
+		for i := 0; i < 12; i++ {
+			require.NoError(t, c.Add(birch.NewDocument(birch.EC.Int64("counter", int64(i)))))
+		}
+
+		// Close waits for the background merge, which the
+		// wrapped collector blocks.
+		closed := make(chan error)
+		go func() { closed <- c.Close() }()
+		select {
+		case <-closed:
+			require.FailNow(t, "Close didn't wait for the merge")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		require.NoError(t, <-closed)
+		assert.Error(t, c.Add(birch.NewDocument(birch.EC.Int64("counter", 12))))
+		assert.NoError(t, c.Close())
+		assert.Equal(t, counterRange(0, 12), resolveCounters(t, c))
+	})
+	t.Run("UnsignedIntegers", func(t *testing.T) {
+		c, err := NewShardedCollector(NewBaseCollector(100), ShardedOptions{UnsignedIntegers: true})
+		require.NoError(t, err)
+		require.NoError(t, c.Add(map[string]uint64{"counter": math.MaxUint64}))
+
+		out, err := c.Resolve()
+		require.NoError(t, err)
+		for doc, err := range StructuredSamples(ctx, bytes.NewReader(out)) {
+			require.NoError(t, err)
+			counter, ok := Uint64Value(doc.Lookup("counter"))
+			require.True(t, ok)
+			assert.Equal(t, uint64(math.MaxUint64), counter)
+		}
+	})
+	t.Run("Options", func(t *testing.T) {
+		_, err := NewShardedCollector(NewBaseCollector(5), ShardedOptions{Shards: -1})
+		assert.Error(t, err)
+		_, err = NewShardedCollector(NewBaseCollector(5), ShardedOptions{MergeSamples: -1})
+		assert.Error(t, err)
+	})
+}
+
+// blockingCollector is a collector whose Add waits until the release
+// channel is closed.
+type blockingCollector struct {
+	Collector
+	release chan struct{}
+}
+
+func (c *blockingCollector) Add(in interface{}) error {
+	<-c.release
+	return c.Collector.Add(in)
+}
@@ -10,6 +10,8 @@ type streamingCollector struct {
 	output     io.Writer
 	maxSamples int
 	count      int
+	opts       CollectorOptions
+	interim    *interimFile
 	Collector
 }
 
@@ -20,20 +22,42 @@ type streamingCollector struct {
 // when the collector as collected the "maxSamples" number of
 // samples during the Add operation.
 func NewStreamingCollector(maxSamples int, writer io.Writer) Collector {
-	return newStreamingCollector(maxSamples, writer)
+	return newStreamingCollector(maxSamples, writer, CollectorOptions{})
 }
 
-func newStreamingCollector(maxSamples int, writer io.Writer) *streamingCollector {
+// NewStreamingCollectorWithOptions is the same as
+// NewStreamingCollector, but uses the options to control how chunks
+// are encoded.
+func NewStreamingCollectorWithOptions(maxSamples int, writer io.Writer, opts CollectorOptions) (Collector, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid collector options")
+	}
+
+	return newStreamingCollector(maxSamples, writer, opts), nil
+}
+
+func newStreamingCollector(maxSamples int, writer io.Writer, opts CollectorOptions) *streamingCollector {
 	return &streamingCollector{
 		maxSamples: maxSamples,
 		output:     writer,
+		opts:       opts,
 		Collector: &betterCollector{
 			maxDeltas: maxSamples,
+			opts:      opts,
 		},
 	}
 }
 
-func (c *streamingCollector) Reset() { c.count = 0; c.Collector.Reset() }
+func (c *streamingCollector) Reset() {
+	c.count = 0
+	c.Collector.Reset()
+	c.interim.reset(c.output)
+}
+
+func (c *streamingCollector) SetPeriodicMetadata(in interface{}) error {
+	return setPeriodicMetadata(c.Collector, in)
+}
+
 func (c *streamingCollector) Add(in interface{}) error {
 	if c.count >= c.maxSamples {
 		if err := FlushCollector(c, c.output); err != nil {
@@ -41,12 +65,19 @@ func (c *streamingCollector) Add(in interface{}) error {
 		}
 	}
 
-	if err := c.Collector.Add(in); err != nil {
+	err := c.Collector.Add(in)
+	if err == errCollectorFull {
+		if err = FlushCollector(c, c.output); err != nil {
+			return errors.Wrap(err, "problem flushing collector contents")
+		}
+		err = c.Collector.Add(in)
+	}
+	if err != nil {
 		return errors.Wrapf(err, "adding sample #%d", c.count+1)
 	}
 	c.count++
 
-	return nil
+	return errors.WithStack(c.interim.update(c.Collector))
 }
 
 // FlushCollector writes the contents of a collector out to an
@@ -90,23 +121,46 @@ type streamingDynamicCollector struct {
 func NewStreamingDynamicCollector(max int, writer io.Writer) Collector {
 	return &streamingDynamicCollector{
 		output:             writer,
-		streamingCollector: newStreamingCollector(max, writer),
+		streamingCollector: newStreamingCollector(max, writer, CollectorOptions{}),
+	}
+}
+
+// NewStreamingDynamicCollectorWithOptions is the same as
+// NewStreamingDynamicCollector, but uses the options to control how
+// chunks are encoded.
+func NewStreamingDynamicCollectorWithOptions(max int, writer io.Writer, opts CollectorOptions) (Collector, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid collector options")
 	}
+
+	return &streamingDynamicCollector{
+		output:             writer,
+		streamingCollector: newStreamingCollector(max, writer, opts),
+	}, nil
 }
 
 func (c *streamingDynamicCollector) Reset() {
-	c.streamingCollector = newStreamingCollector(c.streamingCollector.maxSamples, c.output)
+	prev := c.streamingCollector
+	c.streamingCollector = newStreamingCollector(prev.maxSamples, c.output, prev.opts)
+	c.streamingCollector.interim = prev.interim
+
+	// the periodic metadata outlives the chunk, as it does when
+	// the base collector is reset, so that the periodic metadata
+	// documents in the output form a single sequence of deltas.
+	c.streamingCollector.Collector.(*betterCollector).periodic = prev.Collector.(*betterCollector).periodic
+
+	prev.interim.reset(c.output)
 	c.metricCount = 0
 	c.hash = ""
 }
 
 func (c *streamingDynamicCollector) Add(in interface{}) error {
-	doc, err := readDocument(in)
+	doc, err := readSample(in, c.opts)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	docHash, num := metricKeyHash(doc)
+	docHash, num := metricKeyHash(doc, c.opts)
 	if c.hash == "" {
 		c.hash = docHash
 		c.metricCount = num
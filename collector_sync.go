@@ -31,6 +31,13 @@ func (c *synchronizedCollector) SetMetadata(in interface{}) error {
 	return c.Collector.SetMetadata(in)
 }
 
+func (c *synchronizedCollector) SetPeriodicMetadata(in interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return setPeriodicMetadata(c.Collector, in)
+}
+
 func (c *synchronizedCollector) Resolve() ([]byte, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"math/rand"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
 	"github.com/mongodb/ftdc/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -329,7 +331,7 @@ func TestFixedEncoding(t *testing.T) {
 		},
 		{
 			name:    "Streaming",
-			factory: func() Collector { return newStreamingCollector(20, &bytes.Buffer{}) },
+			factory: func() Collector { return newStreamingCollector(20, &bytes.Buffer{}, CollectorOptions{}) },
 		},
 	} {
 		t.Run(impl.name, func(t *testing.T) {
@@ -409,6 +411,31 @@ func TestFixedEncoding(t *testing.T) {
 	}
 }
 
+func TestDynamicCollectorSchemaChanges(t *testing.T) {
+	collector := NewDynamicCollector(100)
+	for i := 0; i < 30; i++ {
+		doc := birch.NewDocument(birch.EC.Int64("counter", int64(i)))
+		if (i/10)%2 == 1 {
+			doc.Append(birch.EC.Int64("optional", int64(i)))
+		}
+		require.NoError(t, collector.Add(doc))
+	}
+
+	payload, err := collector.Resolve()
+	require.NoError(t, err)
+
+	// each schema change starts one new chunk, which holds all of
+	// the samples until the next schema change, including when
+	// the schema changes back.
+	sizes := []int{}
+	for chunk, err := range Chunks(context.Background(), bytes.NewReader(payload)) {
+		require.NoError(t, err)
+		sizes = append(sizes, chunk.Size())
+	}
+	assert.Equal(t, []int{10, 10, 10}, sizes)
+	assert.Equal(t, counterRange(0, 30), readCounters(t, payload))
+}
+
 func TestCollectorSizeCap(t *testing.T) {
 	for _, test := range []struct {
 		name    string
@@ -428,6 +455,495 @@ func TestCollectorSizeCap(t *testing.T) {
 	}
 }
 
+func TestCollectorChunkSizeLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const limit = 16 * 1024
+
+	source := rand.New(rand.NewSource(42))
+	values := make([]int64, 500)
+	docs := []*birch.Document{}
+	for i := 0; i < 1000; i++ {
+		doc := birch.DC.Make(len(values))
+		for idx := range values {
+			if idx%4 == 0 {
+				values[idx] += source.Int63n(1000)
+			}
+			doc.Append(birch.EC.Int64(fmt.Sprint("metric", idx), values[idx]))
+		}
+		docs = append(docs, doc)
+	}
+
+	// chunkSizes returns the size of each chunk document, and
+	// checks that the chunks hold all of the samples.
+	chunkSizes := func(t *testing.T, payload []byte) []int {
+		next := newDocumentReader(bytes.NewReader(payload), ReadOptions{}, nil)
+		sizes := []int{}
+		for {
+			source, err := next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			if isNum(1, source.doc.Lookup("type")) {
+				sizes = append(sizes, int(source.size))
+			}
+		}
+
+		iter := ReadMetrics(ctx, bytes.NewReader(payload))
+		defer iter.Close()
+		count := 0
+		for iter.Next() {
+			assert.Equal(t, docs[count].Lookup("metric499").Int64(), iter.Document().Lookup("metric499").Int64())
+			count++
+		}
+		require.NoError(t, iter.Err())
+		assert.Equal(t, len(docs), count)
+
+		return sizes
+	}
+
+	checkSizes := func(t *testing.T, sizes []int) {
+		require.True(t, len(sizes) > 2, "%d chunks", len(sizes))
+		for idx, size := range sizes {
+			assert.True(t, size <= limit+limit/20, "chunk %d is %d bytes", idx, size)
+			if idx < len(sizes)-1 {
+				assert.True(t, size >= limit/2, "chunk %d is %d bytes", idx, size)
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		name    string
+		factory func(CollectorOptions) (Collector, error)
+	}{
+		{
+			name:    "Batch",
+			factory: func(opts CollectorOptions) (Collector, error) { return NewBatchCollectorWithOptions(10000, opts) },
+		},
+		{
+			name:    "Dynamic",
+			factory: func(opts CollectorOptions) (Collector, error) { return NewDynamicCollectorWithOptions(10000, opts) },
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for name, opts := range map[string]CollectorOptions{
+				"Zlib": {MaxChunkSize: limit},
+				"Zstd": {MaxChunkSize: limit, Compression: CompressionZstd},
+			} {
+				t.Run(name, func(t *testing.T) {
+					collector, err := test.factory(opts)
+					require.NoError(t, err)
+
+					for _, doc := range docs {
+						require.NoError(t, collector.Add(doc))
+					}
+
+					payload, err := collector.Resolve()
+					require.NoError(t, err)
+					checkSizes(t, chunkSizes(t, payload))
+
+					estimate := collector.Info().PayloadSize
+					assert.True(t, estimate >= len(payload)/2 && estimate <= 2*len(payload), "estimated %d for %d bytes", estimate, len(payload))
+				})
+			}
+		})
+	}
+	t.Run("Streaming", func(t *testing.T) {
+		for name, factory := range map[string]func(io.Writer) (Collector, error){
+			"Streaming": func(w io.Writer) (Collector, error) {
+				return NewStreamingCollectorWithOptions(10000, w, CollectorOptions{MaxChunkSize: limit})
+			},
+			"StreamingDynamic": func(w io.Writer) (Collector, error) {
+				return NewStreamingDynamicCollectorWithOptions(10000, w, CollectorOptions{MaxChunkSize: limit})
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				buf := &bytes.Buffer{}
+				collector, err := factory(buf)
+				require.NoError(t, err)
+				for _, doc := range docs {
+					require.NoError(t, collector.Add(doc))
+					assert.True(t, collector.Info().PayloadSize <= limit+limit/20)
+				}
+				require.NoError(t, FlushCollector(collector, buf))
+				checkSizes(t, chunkSizes(t, buf.Bytes()))
+			})
+		}
+	})
+	t.Run("Base", func(t *testing.T) {
+		collector, err := NewBaseCollectorWithOptions(10000, CollectorOptions{MaxChunkSize: limit})
+		require.NoError(t, err)
+		assert.Zero(t, collector.Info().PayloadSize)
+
+		added := 0
+		for _, doc := range docs {
+			if err = collector.Add(doc); err != nil {
+				break
+			}
+			added++
+		}
+		require.Error(t, err)
+		assert.True(t, added > 1 && added < len(docs))
+		assert.Equal(t, added, collector.Info().SampleCount)
+		assert.Error(t, collector.Add(docs[added]))
+
+		payload, err := collector.Resolve()
+		require.NoError(t, err)
+		assert.True(t, len(payload) <= limit+limit/20)
+
+		collector.Reset()
+		assert.Zero(t, collector.Info().PayloadSize)
+		assert.NoError(t, collector.Add(docs[added]))
+	})
+	t.Run("LargeSample", func(t *testing.T) {
+		collector, err := NewBatchCollectorWithOptions(100, CollectorOptions{MaxChunkSize: 1024})
+		require.NoError(t, err)
+		for _, doc := range docs[:5] {
+			require.NoError(t, collector.Add(doc))
+		}
+		payload, err := collector.Resolve()
+		require.NoError(t, err)
+
+		iter := ReadChunks(ctx, bytes.NewReader(payload))
+		defer iter.Close()
+		count := 0
+		for iter.Next() {
+			assert.Equal(t, 1, iter.Chunk().Size())
+			count++
+		}
+		assert.Equal(t, 5, count)
+	})
+	t.Run("Validate", func(t *testing.T) {
+		assert.Error(t, CollectorOptions{MaxChunkSize: -1}.Validate())
+	})
+}
+
+func TestCollectorNumericWidening(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sample := func(value, count *birch.Element) *birch.Document {
+		return birch.NewDocument(
+			birch.EC.Int64("stable", 42),
+			birch.EC.SubDocument("nested", birch.NewDocument(value)),
+			birch.EC.Interface("array", []int64{1, 2}),
+			count,
+		)
+	}
+
+	samples := []*birch.Document{
+		sample(birch.EC.Int32("value", 1), birch.EC.Int32("count", 10)),
+		sample(birch.EC.Int64("value", 2), birch.EC.Int64("count", 20)),
+		sample(birch.EC.Int32("value", 3), birch.EC.Int32("count", 30)),
+		sample(birch.EC.Double("value", 4.5), birch.EC.Int32("count", 40)),
+		sample(birch.EC.Int64("value", -5), birch.EC.Int64("count", 50)),
+	}
+
+	for _, test := range []struct {
+		name    string
+		factory func(opts CollectorOptions) (Collector, error)
+	}{
+		{
+			name:    "Base",
+			factory: func(opts CollectorOptions) (Collector, error) { return NewBaseCollectorWithOptions(100, opts) },
+		},
+		{
+			name:    "Batch",
+			factory: func(opts CollectorOptions) (Collector, error) { return NewBatchCollectorWithOptions(100, opts) },
+		},
+		{
+			name:    "Dynamic",
+			factory: func(opts CollectorOptions) (Collector, error) { return NewDynamicCollectorWithOptions(100, opts) },
+		},
+		{
+			name:    "Union",
+			factory: func(opts CollectorOptions) (Collector, error) { return NewUnionCollectorWithOptions(100, opts) },
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Run("Widened", func(t *testing.T) {
+				collector, err := test.factory(CollectorOptions{WidenNumericTypes: true})
+				require.NoError(t, err)
+				for _, doc := range samples {
+					require.NoError(t, collector.Add(doc))
+				}
+
+				out, err := collector.Resolve()
+				require.NoError(t, err)
+
+				chunks := 0
+				for chunk, err := range Chunks(ctx, bytes.NewReader(out)) {
+					require.NoError(t, err)
+					chunks++
+					assert.Equal(t, 5, chunk.Size())
+				}
+				assert.Equal(t, 1, chunks)
+
+				idx := 0
+				for doc, err := range StructuredSamples(ctx, bytes.NewReader(out)) {
+					require.NoError(t, err)
+					require.True(t, idx < len(samples))
+
+					// every sample has the widest type.
+					value, ok := doc.Lookup("nested").MutableDocument().Lookup("value").DoubleOK()
+					require.True(t, ok, "%s", doc)
+					assert.Equal(t, []float64{1, 2, 3, 4.5, -5}[idx], value)
+
+					count, ok := doc.Lookup("count").Int64OK()
+					require.True(t, ok, "%s", doc)
+					assert.Equal(t, int64(10*(idx+1)), count)
+
+					assert.Equal(t, int64(42), doc.Lookup("stable").Int64())
+					idx++
+				}
+				assert.Equal(t, len(samples), idx)
+			})
+			t.Run("NonNumeric", func(t *testing.T) {
+				collector, err := test.factory(CollectorOptions{WidenNumericTypes: true})
+				require.NoError(t, err)
+				require.NoError(t, collector.Add(birch.NewDocument(birch.EC.Int64("value", 1))))
+
+				err = collector.Add(birch.NewDocument(birch.EC.Boolean("value", true)))
+				if test.name == "Union" {
+					// the union collector starts a new chunk.
+					require.NoError(t, err)
+					assert.Equal(t, 2, collector.Info().SampleCount)
+				} else {
+					assert.Error(t, err)
+				}
+			})
+		})
+	}
+	t.Run("Disabled", func(t *testing.T) {
+		collector := NewBaseCollector(100)
+		require.NoError(t, collector.Add(samples[0]))
+		assert.Error(t, collector.Add(samples[1]))
+	})
+	t.Run("Precision", func(t *testing.T) {
+		collector, err := NewBaseCollectorWithOptions(100, CollectorOptions{WidenNumericTypes: true})
+		require.NoError(t, err)
+		require.NoError(t, collector.Add(birch.NewDocument(birch.EC.Int64("value", 1<<40+1))))
+		require.NoError(t, collector.Add(birch.NewDocument(birch.EC.Int64("value", -3))))
+		require.NoError(t, collector.Add(birch.NewDocument(birch.EC.Double("value", 0.25))))
+
+		out, err := collector.Resolve()
+		require.NoError(t, err)
+		values := []float64{}
+		for doc, err := range Samples(ctx, bytes.NewReader(out)) {
+			require.NoError(t, err)
+			values = append(values, doc.Lookup("value").Double())
+		}
+		assert.Equal(t, []float64{1<<40 + 1, -3, 0.25}, values)
+	})
+	t.Run("RejectedSample", func(t *testing.T) {
+		collector, err := NewBaseCollectorWithOptions(10000, CollectorOptions{WidenNumericTypes: true, MaxChunkSize: 4096})
+		require.NoError(t, err)
+
+		random := rand.New(rand.NewSource(1))
+		doc := func(value *birch.Element) *birch.Document {
+			out := birch.NewDocument(value)
+			for i := 0; i < 20; i++ {
+				out.Append(birch.EC.Int64(fmt.Sprint("noise", i), random.Int63()))
+			}
+			return out
+		}
+
+		for i := 0; ; i++ {
+			if err = collector.Add(doc(birch.EC.Int64("value", int64(i)))); err != nil {
+				break
+			}
+		}
+		before, err := collector.Resolve()
+		require.NoError(t, err)
+
+		// a sample that doesn't fit must not widen the
+		// metric for the samples in the chunk.
+		assert.Error(t, collector.Add(doc(birch.EC.Double("value", 0.5))))
+		after, err := collector.Resolve()
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+
+		for doc, err := range Samples(ctx, bytes.NewReader(after)) {
+			require.NoError(t, err)
+			assert.Equal(t, bsontype.Int64, doc.Lookup("value").Type())
+		}
+	})
+}
+
+func TestCollectorNanosecondTimes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type sample struct {
+		Time  time.Time `bson:"ts"`
+		Value int64     `bson:"value"`
+	}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 123456789, time.UTC)
+	times := make([]time.Time, 10)
+	for idx := range times {
+		// many samples within the same millisecond.
+		times[idx] = start.Add(time.Duration(idx*150) * time.Nanosecond)
+	}
+
+	for _, test := range []struct {
+		name    string
+		factory func(opts CollectorOptions) (Collector, error)
+	}{
+		{
+			name:    "Base",
+			factory: func(opts CollectorOptions) (Collector, error) { return NewBaseCollectorWithOptions(100, opts) },
+		},
+		{
+			name:    "Dynamic",
+			factory: func(opts CollectorOptions) (Collector, error) { return NewDynamicCollectorWithOptions(100, opts) },
+		},
+		{
+			name:    "Union",
+			factory: func(opts CollectorOptions) (Collector, error) { return NewUnionCollectorWithOptions(100, opts) },
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			collector, err := test.factory(CollectorOptions{NanosecondTimes: true})
+			require.NoError(t, err)
+			for idx, ts := range times {
+				require.NoError(t, collector.Add(sample{Time: ts, Value: int64(idx)}))
+			}
+
+			out, err := collector.Resolve()
+			require.NoError(t, err)
+
+			chunks := 0
+			for chunk, err := range Chunks(ctx, bytes.NewReader(out)) {
+				require.NoError(t, err)
+				chunks++
+
+				require.Equal(t, 2, chunk.Len())
+				metric := chunk.Metrics[0]
+				assert.Equal(t, "ts", metric.Key())
+				assert.Equal(t, bsontype.DateTime, metric.Type())
+				assert.Equal(t, time.Nanosecond, metric.TimePrecision())
+				assert.Equal(t, time.Millisecond, (&Metric{originalType: bsontype.DateTime}).TimePrecision())
+				assert.Zero(t, chunk.Metrics[1].TimePrecision())
+
+				for idx, ts := range metric.Times() {
+					assert.True(t, times[idx].Equal(ts), "%s != %s", times[idx], ts)
+				}
+				for idx, ns := range metric.Nanoseconds() {
+					assert.Equal(t, times[idx].UnixNano(), ns)
+				}
+				assert.Nil(t, chunk.Metrics[1].Nanoseconds())
+				for idx, ts := range chunk.Timestamps() {
+					assert.True(t, times[idx].Equal(ts), "%s != %s", times[idx], ts)
+				}
+				assert.True(t, times[0].Truncate(time.Millisecond).Equal(chunk.StartTime()))
+				assert.True(t, times[len(times)-1].Equal(chunk.EndTime()))
+
+				// slices starting after the first sample
+				// must keep the nanosecond times in their
+				// reference documents.
+				slice, err := chunk.Slice(3, chunk.Size())
+				require.NoError(t, err)
+				encoded, err := slice.Encode()
+				require.NoError(t, err)
+				for sliced, err := range Chunks(ctx, bytes.NewReader(encoded)) {
+					require.NoError(t, err)
+					assert.Equal(t, time.Nanosecond, sliced.Metrics[0].TimePrecision())
+					assert.Equal(t, metric.Nanoseconds()[3:], sliced.Metrics[0].Nanoseconds())
+				}
+			}
+			assert.Equal(t, 1, chunks)
+
+			// documents hold date-times, which have
+			// millisecond precision, but the iterators'
+			// timestamps keep the nanoseconds.
+			idx := 0
+			for doc, err := range StructuredSamples(ctx, bytes.NewReader(out)) {
+				require.NoError(t, err)
+				require.Equal(t, bsontype.DateTime, doc.Lookup("ts").Type(), "%s", doc)
+				assert.True(t, times[idx].Truncate(time.Millisecond).Equal(doc.Lookup("ts").Time()))
+				idx++
+			}
+			assert.Equal(t, len(times), idx)
+
+			iter := ReadMetrics(ctx, bytes.NewReader(out))
+			idx = 0
+			for iter.Next() {
+				require.Equal(t, bsontype.DateTime, iter.Document().Lookup("ts").Type(), "%s", iter.Document())
+				assert.True(t, times[idx].Truncate(time.Millisecond).Equal(iter.Document().Lookup("ts").Time()))
+				assert.True(t, times[idx].Equal(iter.(TimestampIterator).Timestamp()))
+				idx++
+			}
+			require.NoError(t, iter.Err())
+			assert.Equal(t, len(times), idx)
+
+			for _, iter := range []Iterator{
+				ReadMatrix(ctx, bytes.NewReader(out)),
+				ReadSeries(ctx, bytes.NewReader(out)),
+			} {
+				for doc, err := range Documents(iter) {
+					require.NoError(t, err)
+					series := doc.Lookup("ts").MutableArray()
+					require.Equal(t, len(times), series.Len())
+					for idx := range times {
+						value := series.Lookup(uint(idx))
+						require.Equal(t, bsontype.DateTime, value.Type())
+						assert.True(t, times[idx].Truncate(time.Millisecond).Equal(value.Time()))
+					}
+				}
+			}
+		})
+	}
+	t.Run("Disabled", func(t *testing.T) {
+		collector := NewBaseCollector(100)
+		for idx, ts := range times {
+			require.NoError(t, collector.Add(sample{Time: ts, Value: int64(idx)}))
+		}
+
+		out, err := collector.Resolve()
+		require.NoError(t, err)
+		for chunk, err := range Chunks(ctx, bytes.NewReader(out)) {
+			require.NoError(t, err)
+			assert.Equal(t, time.Millisecond, chunk.Metrics[0].TimePrecision())
+			for _, ts := range chunk.Timestamps() {
+				assert.True(t, start.Truncate(time.Millisecond).Equal(ts))
+			}
+		}
+	})
+	t.Run("Element", func(t *testing.T) {
+		collector := NewBaseCollector(100)
+		for _, ts := range times {
+			require.NoError(t, collector.Add(birch.NewDocument(NanosecondTime("ts", ts))))
+		}
+
+		out, err := collector.Resolve()
+		require.NoError(t, err)
+		for chunk, err := range Chunks(ctx, bytes.NewReader(out)) {
+			require.NoError(t, err)
+			require.Len(t, chunk.Metrics[0].Times(), len(times))
+			for idx, ts := range chunk.Metrics[0].Times() {
+				assert.True(t, times[idx].Equal(ts))
+			}
+		}
+	})
+	t.Run("PrecisionChange", func(t *testing.T) {
+		collector := NewBaseCollector(100)
+		require.NoError(t, collector.Add(birch.NewDocument(birch.EC.Time("ts", start))))
+		assert.Error(t, collector.Add(birch.NewDocument(NanosecondTime("ts", start))))
+	})
+	t.Run("Value", func(t *testing.T) {
+		_, ok := NanosecondTimeValue(birch.VC.Time(start))
+		assert.False(t, ok)
+		_, ok = NanosecondTimeValue(birch.VC.Document(birch.NewDocument(birch.EC.Int32(nanosecondTimeKey, 1))))
+		assert.False(t, ok)
+		ts, ok := NanosecondTimeValue(NanosecondTime("ts", start).Value())
+		assert.True(t, ok)
+		assert.True(t, start.Equal(ts))
+	})
+}
+
 func TestWriter(t *testing.T) {
 	t.Run("NilDocuments", func(t *testing.T) {
 		collector := NewWriterCollector(2, &noopWriter{})
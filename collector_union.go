@@ -0,0 +1,518 @@
+package ftdc
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
+	"github.com/pkg/errors"
+)
+
+// unionPresenceField is the name of the document, appended to the
+// reference document of the chunks that the union collector
+// produces, that records which fields were present in each sample.
+const unionPresenceField = "$presence"
+
+type unionCollector struct {
+	maxSamples int
+	opts       CollectorOptions
+	metadata   *birch.Document
+	chunks     []*unionChunk
+	periodic   periodicMetadata
+
+	// updates holds the periodic metadata documents recorded
+	// before the first chunk.
+	updates []*birch.Document
+}
+
+// NewUnionCollector constructs a Collector that tolerates fields that
+// are added to, or removed from, the documents, without starting a
+// new chunk. Each chunk has the union of the fields of the samples in
+// the chunk: when a sample doesn't have a field, the field has the
+// value from the previous sample, or, for the samples before the
+// field first appeared, the first value of the field, so that fields
+// that come and go add very little to the size of the chunk.
+//
+// Fields that were missing from some of the samples are recorded in
+// the "$presence" document, which is appended to each sample, and
+// has a boolean for each of these fields, named by the path to the
+// field (e.g. "opcounters.insert"), that is true for the samples
+// that have the field. Fields that are only missing when their parent
+// document is missing are not recorded.
+//
+// When the collector records null values (see
+// CollectorOptions.DecimalAndNullValues), a null value for a field
+// that has another type in the chunk is treated as a missing value,
+// so that fields that are sometimes null are recorded in the same way
+// as fields that are sometimes missing.
+//
+// The collector starts a new chunk when the number of samples reaches
+// the max sample count, or when the type of a field changes, unless
+// the collector widens numeric types (see
+// CollectorOptions.WidenNumericTypes).
+// Non-numeric fields, such as strings, are not recorded.
+func NewUnionCollector(maxSamples int) Collector {
+	return newUnionCollector(maxSamples, CollectorOptions{})
+}
+
+// NewUnionCollectorWithOptions is the same as NewUnionCollector, but
+// uses the options to control how chunks are encoded. The union
+// collector does not support a maximum chunk size.
+func NewUnionCollectorWithOptions(maxSamples int, opts CollectorOptions) (Collector, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid collector options")
+	}
+
+	if opts.MaxChunkSize > 0 {
+		return nil, errors.New("the union collector does not support a maximum chunk size")
+	}
+
+	return newUnionCollector(maxSamples, opts), nil
+}
+
+func newUnionCollector(maxSamples int, opts CollectorOptions) *unionCollector {
+	return &unionCollector{
+		maxSamples: maxSamples,
+		opts:       opts,
+	}
+}
+
+func (c *unionCollector) Info() CollectorInfo {
+	out := CollectorInfo{}
+	for _, chunk := range c.chunks {
+		out.MetricsCount += len(chunk.columns)
+		out.SampleCount += chunk.samples
+		out.PayloadSize += chunk.rawSize + chunkDocumentOverhead
+	}
+	return out
+}
+
+func (c *unionCollector) Reset() { c.chunks = nil; c.updates = nil }
+
+func (c *unionCollector) SetMetadata(in interface{}) error {
+	doc, err := readDocument(in)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	c.metadata = doc
+	return nil
+}
+
+func (c *unionCollector) SetPeriodicMetadata(in interface{}) error {
+	return errors.WithStack(c.periodic.set(in, c.addPeriodicMetadata))
+}
+
+func (c *unionCollector) addPeriodicMetadata(update *birch.Document) {
+	if len(c.chunks) == 0 {
+		c.updates = append(c.updates, update)
+		return
+	}
+
+	chunk := c.chunks[len(c.chunks)-1]
+	chunk.updates = append(chunk.updates, update)
+}
+
+func (c *unionCollector) Add(in interface{}) error {
+	doc, err := readSample(in, c.opts)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var chunk *unionChunk
+	if len(c.chunks) > 0 {
+		chunk = c.chunks[len(c.chunks)-1]
+	}
+
+	if chunk == nil || chunk.samples >= c.maxSamples || !chunk.root.compatibleDocument(doc, c.opts) {
+		chunk = newUnionChunk(c.opts)
+		chunk.updates, c.updates = c.updates, nil
+		c.chunks = append(c.chunks, chunk)
+	}
+
+	chunk.add(doc)
+
+	return nil
+}
+
+func (c *unionCollector) Resolve() ([]byte, error) {
+	if len(c.chunks) == 0 {
+		return nil, errors.New("no reference document")
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	if c.metadata != nil {
+		_, err := birch.NewDocument(
+			birch.EC.Time("_id", c.chunks[0].startedAt),
+			birch.EC.Int32("type", 0),
+			birch.EC.SubDocument("doc", c.metadata)).WriteTo(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "problem writing metadata document")
+		}
+	}
+
+	for _, chunk := range c.chunks {
+		reference, columns, err := chunk.resolve()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if err = writePeriodicMetadata(buf, chunk.updates); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		out, err := EncodeChunkWithOptions(reference, columns, c.opts)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		_, _ = buf.Write(out)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// unionChunk holds the samples of a chunk as a column for each metric
+// in the union of the samples' fields.
+type unionChunk struct {
+	opts      CollectorOptions
+	root      *unionNode
+	columns   [][]int64
+	samples   int
+	startedAt time.Time
+	updates   []*birch.Document
+	// rawSize is an estimate of the size of the uncompressed
+	// payload, as in the base collector.
+	rawSize int
+}
+
+// unionNode is a field in the union of the samples' fields. Documents
+// and arrays have children, and other fields have one column, or two
+// for timestamps.
+type unionNode struct {
+	key      string
+	btype    bsontype.Type
+	parent   *unionNode
+	children []*unionNode
+	index    map[string]*unionNode
+	column   int
+	// since is the first sample with the field, and last is the
+	// most recent sample with the field.
+	since int
+	last  int
+	// presence records which samples have the field. It's nil
+	// while the field has been in every sample, since it first
+	// appeared, that has its parent.
+	presence []bool
+}
+
+func newUnionChunk(opts CollectorOptions) *unionChunk {
+	return &unionChunk{
+		opts: opts,
+		root: &unionNode{btype: bsontype.EmbeddedDocument, index: map[string]*unionNode{}},
+	}
+}
+
+func (c *unionChunk) add(doc *birch.Document) {
+	sample := c.samples
+	if sample > 0 {
+		// carry the previous values forward, for the
+		// metrics that are not in the sample.
+		for idx := range c.columns {
+			c.columns[idx] = append(c.columns[idx], c.columns[idx][sample-1])
+		}
+	}
+
+	c.root.last = sample
+	c.mergeDocument(c.root, doc, sample)
+	c.root.updatePresence(sample)
+	c.samples++
+
+	if sample == 0 {
+		metrics, _ := extractMetricsFromDocument(doc, c.opts)
+		c.startedAt = metrics.ts
+		return
+	}
+
+	for idx := range c.columns {
+		column := c.columns[idx]
+		delta := column[sample] - column[sample-1]
+
+		// zeros are run-length encoded, so count two bytes
+		// for the start of each run.
+		switch {
+		case delta != 0:
+			c.rawSize += len(encodeValue(delta))
+		case sample == 1 || column[sample-1] != column[sample-2]:
+			c.rawSize += 2
+		}
+	}
+}
+
+func (c *unionChunk) mergeDocument(node *unionNode, doc *birch.Document, sample int) {
+	iter := doc.Iterator()
+	for iter.Next() {
+		elem := iter.Element()
+		c.mergeValue(node, elem.Key(), elem.Value(), sample)
+	}
+}
+
+func (c *unionChunk) mergeArray(node *unionNode, array *birch.Array, sample int) {
+	iter := array.Iterator()
+	for idx := 0; iter.Next(); idx++ {
+		c.mergeValue(node, strconv.Itoa(idx), iter.Value(), sample)
+	}
+}
+
+func (c *unionChunk) mergeValue(parent *unionNode, key string, value *birch.Value, sample int) {
+	btype := value.Type()
+	if !isUnionType(btype, c.opts) {
+		return
+	}
+
+	node, ok := parent.index[key]
+	switch {
+	case !ok:
+		node = c.addNode(parent, key, value, sample)
+	case node.btype != btype && isNullType(btype):
+		// null values of other fields are treated as
+		// missing values.
+		return
+	}
+	node.last = sample
+
+	if wider, ok := widerNumericType(node.btype, btype); ok && wider != node.btype {
+		if wider == bsontype.Double {
+			widenMetricColumn(c.columns[node.column])
+		}
+		node.btype = wider
+	}
+
+	switch btype {
+	case bsontype.EmbeddedDocument:
+		c.mergeDocument(node, value.MutableDocument(), sample)
+	case bsontype.Array:
+		c.mergeArray(node, value.MutableArray(), sample)
+	default:
+		metrics, _ := extractMetricsFromValue(value, c.opts)
+		for idx, metric := range metrics.values {
+			val := metricValue(widenMetricValue(metric, node.btype))
+			column := c.columns[node.column+idx]
+			if node.since == sample {
+				for prev := range column {
+					column[prev] = val
+				}
+				continue
+			}
+			column[sample] = val
+		}
+	}
+}
+
+// addNode adds a field to the union. The columns of new metrics must
+// be filled with the first value of the metric, for the previous
+// samples, by the caller.
+func (c *unionChunk) addNode(parent *unionNode, key string, value *birch.Value, sample int) *unionNode {
+	node := &unionNode{
+		key:    key,
+		btype:  value.Type(),
+		parent: parent,
+		column: len(c.columns),
+		since:  sample,
+	}
+
+	switch node.btype {
+	case bsontype.EmbeddedDocument, bsontype.Array:
+		node.index = map[string]*unionNode{}
+		c.rawSize += len(key) + 7
+	default:
+		metrics, _ := extractMetricsFromValue(value, c.opts)
+		for range metrics.values {
+			c.columns = append(c.columns, make([]int64, sample+1))
+		}
+		c.rawSize += len(key) + 10
+	}
+
+	parent.children = append(parent.children, node)
+	parent.index[key] = node
+
+	// fields that appear after the first sample with their
+	// parent were missing from the earlier samples.
+	for prev := 0; prev < sample; prev++ {
+		if parent.present(prev) {
+			node.presence = make([]bool, sample)
+			break
+		}
+	}
+
+	return node
+}
+
+// present reports whether the sample has the field.
+func (n *unionNode) present(sample int) bool {
+	switch {
+	case n.parent == nil:
+		return true
+	case n.presence != nil:
+		return n.presence[sample]
+	default:
+		return sample >= n.since && n.parent.present(sample)
+	}
+}
+
+// updatePresence records which fields are in the sample, starting to
+// record the presence of fields when they are first missing from a
+// sample that has their parent.
+func (n *unionNode) updatePresence(sample int) {
+	for _, child := range n.children {
+		seen := child.last == sample
+		switch {
+		case child.presence != nil:
+			child.presence = append(child.presence, seen)
+		case !seen && n.present(sample):
+			presence := make([]bool, sample, sample+1)
+			for prev := range presence {
+				presence[prev] = child.present(prev)
+			}
+			child.presence = append(presence, false)
+		}
+
+		child.updatePresence(sample)
+	}
+}
+
+// compatibleDocument returns false if the type of any of the fields
+// in the document is different from the type of the field in the
+// union, unless both types are numeric and types may be widened.
+func (n *unionNode) compatibleDocument(doc *birch.Document, opts CollectorOptions) bool {
+	iter := doc.Iterator()
+	for iter.Next() {
+		elem := iter.Element()
+		if !n.compatibleValue(elem.Key(), elem.Value(), opts) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *unionNode) compatibleArray(array *birch.Array, opts CollectorOptions) bool {
+	iter := array.Iterator()
+	for idx := 0; iter.Next(); idx++ {
+		if !n.compatibleValue(strconv.Itoa(idx), iter.Value(), opts) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *unionNode) compatibleValue(key string, value *birch.Value, opts CollectorOptions) bool {
+	btype := value.Type()
+	if !isUnionType(btype, opts) {
+		return true
+	}
+
+	node, ok := n.index[key]
+	switch {
+	case !ok:
+		return true
+	case node.btype != btype && isNullType(btype):
+		return true
+	case node.btype != btype:
+		_, ok = widerNumericType(node.btype, btype)
+		return ok && opts.WidenNumericTypes
+	case btype == bsontype.EmbeddedDocument:
+		return node.compatibleDocument(value.MutableDocument(), opts)
+	case btype == bsontype.Array:
+		return node.compatibleArray(value.MutableArray(), opts)
+	default:
+		return true
+	}
+}
+
+func isUnionType(btype bsontype.Type, opts CollectorOptions) bool {
+	switch btype {
+	case bsontype.EmbeddedDocument, bsontype.Array, bsontype.Boolean, bsontype.Double,
+		bsontype.Int32, bsontype.Int64, bsontype.DateTime, bsontype.Timestamp:
+		return true
+	case bsontype.Decimal128, bsontype.Null, bsontype.Undefined:
+		return opts.DecimalAndNullValues
+	default:
+		return false
+	}
+}
+
+func isNullType(btype bsontype.Type) bool {
+	return btype == bsontype.Null || btype == bsontype.Undefined
+}
+
+// resolve returns the reference document and the columns of the
+// chunk, with the presence document, if any of the fields were
+// missing from any of the samples.
+func (c *unionChunk) resolve() (*birch.Document, [][]int64, error) {
+	columns := make([][]int64, 0, len(c.columns))
+	reference := birch.DC.Make(len(c.root.children))
+	for _, child := range c.root.children {
+		reference.Append(child.reference(c.columns, &columns))
+	}
+
+	presence := birch.DC.Make(0)
+	c.root.presenceColumns(nil, presence, &columns)
+	if presence.Len() > 0 {
+		if _, ok := c.root.index[unionPresenceField]; ok {
+			return nil, nil, errors.Errorf("samples cannot have a '%s' field", unionPresenceField)
+		}
+		reference.Append(birch.EC.SubDocument(unionPresenceField, presence))
+	}
+
+	return reference, columns, nil
+}
+
+// reference returns the field, in the reference document, and appends
+// the columns of its metrics, in the order that they appear in the
+// reference document.
+func (n *unionNode) reference(columns [][]int64, out *[][]int64) *birch.Element {
+	switch n.btype {
+	case bsontype.EmbeddedDocument:
+		doc := birch.DC.Make(len(n.children))
+		for _, child := range n.children {
+			doc.Append(child.reference(columns, out))
+		}
+		return birch.EC.SubDocument(n.key, doc)
+	case bsontype.Array:
+		values := make([]*birch.Value, len(n.children))
+		for idx, child := range n.children {
+			values[idx] = child.reference(columns, out).Value()
+		}
+		return birch.EC.ArrayFromElements(n.key, values...)
+	case bsontype.Timestamp:
+		*out = append(*out, columns[n.column], columns[n.column+1])
+		return birch.EC.Timestamp(n.key, uint32(columns[n.column][0]), uint32(columns[n.column+1][0]))
+	default:
+		*out = append(*out, columns[n.column])
+		elem, _ := restoreFlat(n.btype, n.key, columns[n.column][0])
+		return elem
+	}
+}
+
+// presenceColumns appends a field to the presence document, and a
+// column, for each of the fields that were missing from a sample.
+func (n *unionNode) presenceColumns(path []string, presence *birch.Document, out *[][]int64) {
+	for _, child := range n.children {
+		childPath := append(path[:len(path):len(path)], child.key)
+		if child.presence != nil {
+			column := make([]int64, len(child.presence))
+			for idx, present := range child.presence {
+				if present {
+					column[idx] = 1
+				}
+			}
+			presence.Append(birch.EC.Boolean(strings.Join(childPath, "."), child.presence[0]))
+			*out = append(*out, column)
+		}
+
+		child.presenceColumns(childPath, presence, out)
+	}
+}
@@ -0,0 +1,255 @@
+package ftdc
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnionCollector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	readChunks := func(t *testing.T, data []byte) []*Chunk {
+		chunks := []*Chunk{}
+		for chunk, err := range Chunks(ctx, bytes.NewReader(data)) {
+			require.NoError(t, err)
+			chunks = append(chunks, chunk)
+		}
+		return chunks
+	}
+
+	readSamples := func(t *testing.T, data []byte) []*birch.Document {
+		docs := []*birch.Document{}
+		for doc, err := range StructuredSamples(ctx, bytes.NewReader(data)) {
+			require.NoError(t, err)
+			docs = append(docs, doc)
+		}
+		return docs
+	}
+
+	t.Run("StableSchema", func(t *testing.T) {
+		union := NewUnionCollector(100)
+		base := NewBaseCollector(100)
+		for i := 0; i < 50; i++ {
+			doc := birch.NewDocument(
+				birch.EC.Time("ts", start.Add(time.Duration(i)*time.Second)),
+				birch.EC.Int64("counter", int64(i)),
+				birch.EC.SubDocument("nested", birch.NewDocument(
+					birch.EC.Double("ratio", float64(i)/3),
+					birch.EC.Boolean("ok", i%2 == 0),
+					birch.EC.Timestamp("optime", uint32(i), 1),
+				)),
+				birch.EC.Interface("array", []int32{1, int32(i)}),
+			)
+			require.NoError(t, union.Add(doc))
+			require.NoError(t, base.Add(doc))
+		}
+
+		unionOut, err := union.Resolve()
+		require.NoError(t, err)
+		baseOut, err := base.Resolve()
+		require.NoError(t, err)
+		assert.Equal(t, baseOut, unionOut)
+	})
+	t.Run("FlappingField", func(t *testing.T) {
+		union := NewUnionCollector(1000)
+		dynamic := NewDynamicCollector(1000)
+		for i := 0; i < 200; i++ {
+			doc := birch.NewDocument(
+				birch.EC.Time("ts", start.Add(time.Duration(i)*time.Second)),
+				birch.EC.Int64("counter", int64(i)),
+			)
+			if i%3 == 0 {
+				doc.Append(birch.EC.Int64("optional", int64(i*2)))
+			}
+			require.NoError(t, union.Add(doc))
+			require.NoError(t, dynamic.Add(doc))
+		}
+
+		info := union.Info()
+		assert.Equal(t, 200, info.SampleCount)
+		assert.Equal(t, 3, info.MetricsCount)
+
+		unionOut, err := union.Resolve()
+		require.NoError(t, err)
+		dynamicOut, err := dynamic.Resolve()
+		require.NoError(t, err)
+		assert.True(t, len(unionOut)*4 < len(dynamicOut), "%d vs %d", len(unionOut), len(dynamicOut))
+		assert.Len(t, readChunks(t, unionOut), 1)
+
+		docs := readSamples(t, unionOut)
+		require.Len(t, docs, 200)
+		for i, doc := range docs {
+			assert.Equal(t, int64(i), doc.Lookup("counter").Int64())
+
+			// missing values are carried forward.
+			assert.Equal(t, int64(i/3*6), doc.Lookup("optional").Int64())
+
+			presence := doc.Lookup(unionPresenceField).MutableDocument()
+			require.Equal(t, 1, presence.Len(), "%s", doc)
+			assert.Equal(t, i%3 == 0, presence.Lookup("optional").Boolean())
+		}
+	})
+	t.Run("AddedField", func(t *testing.T) {
+		union := NewUnionCollector(100)
+		for i := 0; i < 10; i++ {
+			doc := birch.NewDocument(birch.EC.Int64("counter", int64(i)))
+			if i >= 4 {
+				doc.Append(birch.EC.SubDocument("section", birch.NewDocument(
+					birch.EC.Int32("one", int32(i)),
+					birch.EC.Int32("two", int32(i*2)),
+				)))
+			}
+			require.NoError(t, union.Add(doc))
+		}
+
+		out, err := union.Resolve()
+		require.NoError(t, err)
+		docs := readSamples(t, out)
+		require.Len(t, docs, 10)
+		for i, doc := range docs {
+			// the samples before the field appeared have the
+			// first value of the field.
+			expected := int32(i)
+			if i < 4 {
+				expected = 4
+			}
+			assert.Equal(t, expected, doc.Lookup("section").MutableDocument().Lookup("one").Int32())
+			assert.Equal(t, expected*2, doc.Lookup("section").MutableDocument().Lookup("two").Int32())
+
+			// the presence of the section's fields is implied
+			// by the presence of the section.
+			presence := doc.Lookup(unionPresenceField).MutableDocument()
+			require.Equal(t, 1, presence.Len(), "%s", doc)
+			assert.Equal(t, i >= 4, presence.Lookup("section").Boolean())
+		}
+	})
+	t.Run("NestedFields", func(t *testing.T) {
+		union := NewUnionCollector(100)
+		for i := 0; i < 12; i++ {
+			section := birch.NewDocument(birch.EC.Int64("always", int64(i)))
+			if i%2 == 0 {
+				section.Append(birch.EC.Int64("sometimes", int64(i)))
+			}
+
+			doc := birch.NewDocument(birch.EC.Int64("counter", int64(i)))
+			if i%3 != 0 {
+				doc.Append(birch.EC.SubDocument("section", section))
+			}
+			doc.Append(birch.EC.Interface("array", make([]int64, i%4)))
+			require.NoError(t, union.Add(doc))
+		}
+
+		out, err := union.Resolve()
+		require.NoError(t, err)
+		docs := readSamples(t, out)
+		require.Len(t, docs, 12)
+		for i, doc := range docs {
+			presence := doc.Lookup(unionPresenceField).MutableDocument()
+			keys := []string{}
+			for iter := presence.Iterator(); iter.Next(); {
+				keys = append(keys, iter.Element().Key())
+			}
+			assert.Equal(t, []string{"array.0", "array.1", "array.2", "section", "section.sometimes"}, keys)
+
+			assert.Equal(t, i%3 != 0, presence.Lookup("section").Boolean())
+			assert.Equal(t, i%3 != 0 && i%2 == 0, presence.Lookup("section.sometimes").Boolean())
+			assert.Equal(t, i%4 > 0, presence.Lookup("array.0").Boolean())
+			assert.Equal(t, i%4 > 1, presence.Lookup("array.1").Boolean())
+			assert.Equal(t, i%4 > 2, presence.Lookup("array.2").Boolean())
+			assert.Equal(t, 3, doc.Lookup("array").MutableArray().Len())
+		}
+	})
+	t.Run("NullValues", func(t *testing.T) {
+		union, err := NewUnionCollectorWithOptions(100, CollectorOptions{DecimalAndNullValues: true})
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			doc := birch.NewDocument(birch.EC.Int64("counter", int64(i)))
+			if i%2 == 0 {
+				doc.Append(birch.EC.Int64("sometimes", int64(i)), birch.EC.Null("never"))
+			} else {
+				doc.Append(birch.EC.Null("sometimes"), birch.EC.Null("never"))
+			}
+			require.NoError(t, union.Add(doc))
+		}
+
+		out, err := union.Resolve()
+		require.NoError(t, err)
+		assert.Len(t, readChunks(t, out), 1)
+
+		docs := readSamples(t, out)
+		require.Len(t, docs, 10)
+		for i, doc := range docs {
+			// null values of numeric fields are missing
+			// values, but fields that are always null are
+			// recorded as null.
+			assert.Equal(t, int64(i-i%2), doc.Lookup("sometimes").Int64())
+			assert.Equal(t, bsontype.Null, doc.Lookup("never").Type())
+			assert.Equal(t, i%2 == 0, doc.Lookup(unionPresenceField).MutableDocument().Lookup("sometimes").Boolean())
+		}
+	})
+	t.Run("TypeChange", func(t *testing.T) {
+		union := NewUnionCollector(100)
+		require.NoError(t, union.SetMetadata(birch.NewDocument(birch.EC.String("host", "example"))))
+		for i := 0; i < 10; i++ {
+			require.NoError(t, union.Add(birch.NewDocument(birch.EC.Int64("value", int64(i)))))
+		}
+		for i := 0; i < 10; i++ {
+			require.NoError(t, union.Add(birch.NewDocument(birch.EC.Double("value", float64(i)))))
+		}
+		assert.Equal(t, 20, union.Info().SampleCount)
+
+		out, err := union.Resolve()
+		require.NoError(t, err)
+		chunks := readChunks(t, out)
+		require.Len(t, chunks, 2)
+		assert.Equal(t, "example", chunks[0].GetMetadata().Lookup("doc").MutableDocument().Lookup("host").StringValue())
+		assert.Equal(t, 10, chunks[0].Size())
+		assert.Equal(t, 10, chunks[1].Size())
+	})
+	t.Run("MaxSamples", func(t *testing.T) {
+		union := NewUnionCollector(10)
+		for i := 0; i < 25; i++ {
+			require.NoError(t, union.Add(birch.NewDocument(birch.EC.Int64("value", int64(i)))))
+		}
+
+		out, err := union.Resolve()
+		require.NoError(t, err)
+		assert.Len(t, readChunks(t, out), 3)
+		assert.Len(t, readSamples(t, out), 25)
+
+		union.Reset()
+		assert.Zero(t, union.Info())
+		_, err = union.Resolve()
+		assert.Error(t, err)
+	})
+	t.Run("PresenceConflict", func(t *testing.T) {
+		union := NewUnionCollector(10)
+		require.NoError(t, union.Add(birch.NewDocument(birch.EC.SubDocument(unionPresenceField, birch.NewDocument()))))
+		require.NoError(t, union.Add(birch.NewDocument(birch.EC.Int64("value", 1))))
+		_, err := union.Resolve()
+		assert.Error(t, err)
+	})
+	t.Run("Options", func(t *testing.T) {
+		union, err := NewUnionCollectorWithOptions(10, CollectorOptions{Compression: CompressionZstd})
+		require.NoError(t, err)
+		require.NoError(t, union.Add(birch.NewDocument(birch.EC.Int64("value", 1))))
+		out, err := union.Resolve()
+		require.NoError(t, err)
+		assert.Len(t, readChunks(t, out), 1)
+
+		_, err = NewUnionCollectorWithOptions(10, CollectorOptions{MaxChunkSize: 1024})
+		assert.Error(t, err)
+		_, err = NewUnionCollectorWithOptions(10, CollectorOptions{Compression: "lz4"})
+		assert.Error(t, err)
+	})
+}
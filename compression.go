@@ -0,0 +1,124 @@
+package ftdc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Compression identifies the codec used to compress the payload of
+// metric chunks.
+type Compression string
+
+const (
+	// CompressionZlib is the codec that mongod uses, and the
+	// default for the collectors.
+	CompressionZlib Compression = "zlib"
+
+	// CompressionZstd produces smaller chunks than zlib, and is
+	// faster to decompress, but chunks that use it can only be
+	// read by this package, and not by mongod's tools. The codec
+	// is recorded in the "compression" field of each chunk
+	// document, so readers detect it automatically.
+	CompressionZstd Compression = "zstd"
+)
+
+// chunkCompressionField is the name of the field in metric chunk
+// documents that records the codec, when it is not zlib.
+const chunkCompressionField = "compression"
+
+// Validate returns an error if the codec is not supported. The zero
+// value is valid, and is the same as CompressionZlib.
+func (c Compression) Validate() error {
+	switch c {
+	case "", CompressionZlib, CompressionZstd:
+		return nil
+	default:
+		return errors.Errorf("unsupported compression '%s'", c)
+	}
+}
+
+func (c Compression) isZlib() bool { return c == "" || c == CompressionZlib }
+
+// compressPayload compresses the payload of a metric chunk, which is
+// prefixed with its uncompressed length.
+func compressPayload(input []byte, opts CollectorOptions) ([]byte, error) {
+	if opts.Compression.isZlib() && opts.CompressionLevel == 0 {
+		return compressBuffer(input)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(input)/4))
+	_, _ = buf.Write(encodeSizeValue(uint32(len(input))))
+
+	switch opts.Compression {
+	case CompressionZstd:
+		return zstdEncoder(opts.CompressionLevel).EncodeAll(input, buf.Bytes()), nil
+	default:
+		zbuf, err := zlib.NewWriterLevel(buf, opts.CompressionLevel)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if _, err = zbuf.Write(input); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if err = zbuf.Close(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return buf.Bytes(), nil
+	}
+}
+
+// the zstd encoders and decoder are safe for concurrent use, and
+// expensive to create, so they are shared by all collectors and
+// readers.
+var (
+	zstdEncodersMu sync.Mutex
+	zstdEncoders   = map[zstd.EncoderLevel]*zstd.Encoder{}
+
+	zstdDecoderOnce sync.Once
+	zstdDecoderInst *zstd.Decoder
+)
+
+// zstdEncoder returns the encoder for the compression level, where
+// zero is the default level.
+func zstdEncoder(level int) *zstd.Encoder {
+	encLevel := zstd.SpeedDefault
+	if level != 0 {
+		encLevel = zstd.EncoderLevelFromZstd(level)
+	}
+
+	zstdEncodersMu.Lock()
+	defer zstdEncodersMu.Unlock()
+
+	enc, ok := zstdEncoders[encLevel]
+	if !ok {
+		// the options are valid, so creating the encoder
+		// cannot fail.
+		enc, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(encLevel))
+		zstdEncoders[encLevel] = enc
+	}
+
+	return enc
+}
+
+// maxChunkDataSize limits the uncompressed size of a chunk's payload,
+// which holds a reference document, of at most maxDocumentSize, and
+// the deltas, which compress well, so that a damaged or hostile chunk
+// cannot make the decoder allocate without bound.
+const maxChunkDataSize = 4 * maxDocumentSize
+
+func zstdDecoder() *zstd.Decoder {
+	zstdDecoderOnce.Do(func() {
+		zstdDecoderInst, _ = zstd.NewReader(nil,
+			zstd.WithDecoderConcurrency(0),
+			zstd.WithDecoderMaxMemory(maxChunkDataSize))
+	})
+
+	return zstdDecoderInst
+}
@@ -0,0 +1,212 @@
+package ftdc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	docs := []*birch.Document{}
+	for i := 0; i < 500; i++ {
+		docs = append(docs, birch.NewDocument(
+			birch.EC.Time("ts", start.Add(time.Duration(i)*time.Second)),
+			birch.EC.Int64("counter", int64(i*i)),
+			birch.EC.Double("ratio", float64(i%13)/7),
+			birch.EC.SubDocument("nested", birch.NewDocument(
+				birch.EC.Int32("gauge", int32(i%17)),
+				birch.EC.Int64("flat", 42),
+			)),
+		))
+	}
+
+	collect := func(t *testing.T, opts CollectorOptions) []byte {
+		collector, err := NewBatchCollectorWithOptions(100, opts)
+		require.NoError(t, err)
+		require.NoError(t, collector.SetMetadata(birch.NewDocument(birch.EC.String("host", "test"))))
+		for _, doc := range docs {
+			require.NoError(t, collector.Add(doc))
+		}
+		out, err := collector.Resolve()
+		require.NoError(t, err)
+		return out
+	}
+
+	read := func(t *testing.T, payload []byte, opts ReadOptions) []string {
+		iter := ReadMetricsWithOptions(ctx, bytes.NewReader(payload), opts)
+		defer iter.Close()
+		out := []string{}
+		for iter.Next() {
+			out = append(out, iter.Document().String())
+		}
+		require.NoError(t, iter.Err())
+		return out
+	}
+
+	// codecs returns the codec recorded in each chunk.
+	codecs := func(t *testing.T, payload []byte) []string {
+		next := newDocumentReader(bytes.NewReader(payload), ReadOptions{}, nil)
+		out := []string{}
+		for {
+			source, err := next()
+			if err == io.EOF {
+				return out
+			}
+			require.NoError(t, err)
+			if isNum(1, source.doc.Lookup("type")) {
+				value, _ := source.doc.Lookup(chunkCompressionField).StringValueOK()
+				out = append(out, value)
+			}
+		}
+	}
+
+	baseline := collect(t, CollectorOptions{})
+	expected := read(t, baseline, ReadOptions{})
+	require.Len(t, expected, len(docs))
+
+	t.Run("DefaultIsUnchanged", func(t *testing.T) {
+		collector := NewBatchCollector(100)
+		require.NoError(t, collector.SetMetadata(birch.NewDocument(birch.EC.String("host", "test"))))
+		for _, doc := range docs {
+			require.NoError(t, collector.Add(doc))
+		}
+		out, err := collector.Resolve()
+		require.NoError(t, err)
+		assert.Equal(t, out, baseline)
+		assert.Equal(t, baseline, collect(t, CollectorOptions{Compression: CompressionZlib}))
+		assert.Equal(t, []string{"", "", "", "", ""}, codecs(t, baseline))
+	})
+	for name, opts := range map[string]CollectorOptions{
+		"ZlibBestSpeed":       {CompressionLevel: 1},
+		"ZlibBestCompression": {Compression: CompressionZlib, CompressionLevel: 9},
+		"Zstd":                {Compression: CompressionZstd},
+		"ZstdFastest":         {Compression: CompressionZstd, CompressionLevel: 1},
+		"ZstdBest":            {Compression: CompressionZstd, CompressionLevel: 22},
+	} {
+		t.Run(name, func(t *testing.T) {
+			payload := collect(t, opts)
+			assert.Equal(t, expected, read(t, payload, ReadOptions{}))
+			assert.Equal(t, expected, read(t, payload, ReadOptions{Workers: 4}))
+			assert.Equal(t, expected, read(t, payload, ReadOptions{Synchronous: true}))
+
+			recorded := ""
+			if opts.Compression == CompressionZstd {
+				recorded = "zstd"
+			}
+			assert.Equal(t, []string{recorded, recorded, recorded, recorded, recorded}, codecs(t, payload))
+		})
+	}
+	t.Run("ZstdIsSmaller", func(t *testing.T) {
+		size := func(opts CollectorOptions) int {
+			collector, err := NewBaseCollectorWithOptions(300, opts)
+			require.NoError(t, err)
+			values := make([]int64, 200)
+			for i := 0; i < 300; i++ {
+				doc := birch.DC.Make(len(values))
+				for idx := range values {
+					values[idx] += int64((i * idx) % 7)
+					doc.Append(birch.EC.Int64(fmt.Sprint("metric", idx), values[idx]))
+				}
+				require.NoError(t, collector.Add(doc))
+			}
+			out, err := collector.Resolve()
+			require.NoError(t, err)
+			return len(out)
+		}
+
+		assert.Less(t, size(CollectorOptions{Compression: CompressionZstd, CompressionLevel: 19}), size(CollectorOptions{}))
+		assert.Less(t, size(CollectorOptions{CompressionLevel: 9}), size(CollectorOptions{CompressionLevel: 1}))
+	})
+	t.Run("Encoder", func(t *testing.T) {
+		opts := CollectorOptions{Compression: CompressionZstd}
+		collector, err := NewBaseCollectorWithOptions(len(docs), opts)
+		require.NoError(t, err)
+		for _, doc := range docs {
+			require.NoError(t, collector.Add(doc))
+		}
+		payload, err := collector.Resolve()
+		require.NoError(t, err)
+
+		iter := ReadChunks(ctx, bytes.NewReader(payload))
+		require.True(t, iter.Next())
+		chunk := iter.Chunk()
+		iter.Close()
+
+		columns := [][]int64{}
+		for _, m := range chunk.Metrics {
+			columns = append(columns, m.Values)
+		}
+		out, err := EncodeChunkWithOptions(docs[0], columns, opts)
+		require.NoError(t, err)
+		assert.Equal(t, payload, out)
+	})
+	t.Run("UnknownCodec", func(t *testing.T) {
+		data, err := compressBuffer([]byte("not a chunk"))
+		require.NoError(t, err)
+		buf := &bytes.Buffer{}
+		require.NoError(t, writeChunkDocument(buf, start, data, "lz4"))
+		payload := append(buf.Bytes(), baseline...)
+
+		iter := ReadChunks(ctx, bytes.NewReader(payload))
+		assert.False(t, iter.Next())
+		require.Error(t, iter.Err())
+		assert.Contains(t, iter.Err().Error(), "lz4")
+
+		assert.Equal(t, expected, read(t, payload, ReadOptions{SkipCorrupt: true}))
+	})
+	t.Run("DeclaredSize", func(t *testing.T) {
+		data, err := compressPayload([]byte("not a chunk"), CollectorOptions{Compression: CompressionZstd})
+		require.NoError(t, err)
+
+		for name, size := range map[string]uint32{
+			"Mismatched": 4,
+			"TooLarge":   maxChunkDataSize + 1,
+		} {
+			t.Run(name, func(t *testing.T) {
+				corrupt := append(encodeSizeValue(size), data[4:]...)
+				buf := &bytes.Buffer{}
+				require.NoError(t, writeChunkDocument(buf, start, corrupt, CompressionZstd))
+
+				iter := ReadChunks(ctx, bytes.NewReader(buf.Bytes()))
+				assert.False(t, iter.Next())
+				require.Error(t, iter.Err())
+				assert.Contains(t, iter.Err().Error(), "declare")
+				iter.Close()
+			})
+		}
+	})
+	t.Run("Validate", func(t *testing.T) {
+		assert.NoError(t, CollectorOptions{}.Validate())
+		assert.NoError(t, CollectorOptions{CompressionLevel: 9}.Validate())
+		assert.NoError(t, CollectorOptions{Compression: CompressionZstd, CompressionLevel: 22}.Validate())
+		assert.Error(t, CollectorOptions{Compression: "lz4"}.Validate())
+		assert.Error(t, CollectorOptions{CompressionLevel: 10}.Validate())
+		assert.Error(t, CollectorOptions{CompressionLevel: -1}.Validate())
+		assert.Error(t, CollectorOptions{Compression: CompressionZstd, CompressionLevel: 23}.Validate())
+
+		invalid := CollectorOptions{Compression: "lz4"}
+		_, err := NewBaseCollectorWithOptions(10, invalid)
+		assert.Error(t, err)
+		_, err = NewBatchCollectorWithOptions(10, invalid)
+		assert.Error(t, err)
+		_, err = NewDynamicCollectorWithOptions(10, invalid)
+		assert.Error(t, err)
+		_, err = NewStreamingCollectorWithOptions(10, &bytes.Buffer{}, invalid)
+		assert.Error(t, err)
+		_, err = NewStreamingDynamicCollectorWithOptions(10, &bytes.Buffer{}, invalid)
+		assert.Error(t, err)
+		_, err = EncodeChunkWithOptions(docs[0], nil, invalid)
+		assert.Error(t, err)
+	})
+}
@@ -25,10 +25,21 @@ func (c *Chunk) getFieldNames() []string {
 func (c *Chunk) getRecord(i int) []string {
 	fields := make([]string, len(c.Metrics))
 	for idx, m := range c.Metrics {
+		if m.encoding == encodingUnsigned {
+			fields[idx] = strconv.FormatUint(uint64(m.Values[i]), 10)
+			continue
+		}
+
 		switch m.originalType {
 		case bsontype.Double, bsontype.Int32, bsontype.Int64, bsontype.Boolean, bsontype.Timestamp:
 			fields[idx] = strconv.FormatInt(m.Values[i], 10)
+		case bsontype.Decimal128:
+			fields[idx] = strconv.FormatFloat(restoreFloat(m.Values[i]), 'f', -1, 64)
 		case bsontype.DateTime:
+			if m.encoding == encodingNanoseconds {
+				fields[idx] = time.Unix(0, m.Values[i]).Format(time.RFC3339Nano)
+				break
+			}
 			fields[idx] = time.Unix(m.Values[i]/1000, 0).Format(time.RFC3339)
 		}
 	}
@@ -0,0 +1,174 @@
+package ftdc
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/pkg/errors"
+)
+
+// EncodeChunk renders a metric chunk (type 1) document from columnar
+// data, without building a document for each sample. The reference
+// document holds the first sample, and determines the schema of the
+// chunk: there must be one column for each metric in the reference
+// document, in the order that the metrics appear in the document,
+// which is the same shape as Chunk.Metrics. Every column must have
+// the same number of values, one for each sample, including the
+// reference document, and the first value of each column must be the
+// value of the metric in the reference document. Values are encoded
+// as in Metric.Values: doubles as the bits of the float64 value,
+// booleans as 0 or 1, and date-times as milliseconds since the epoch,
+// or nanoseconds for times recorded at nanosecond precision (see
+// NanosecondTime). Decimal, null, and undefined values are only
+// metrics with the DecimalAndNullValues option (see
+// EncodeChunkWithOptions), and are encoded as the bits of the closest
+// float64 value, for decimals, and as 0 otherwise.
+//
+// The chunk's "_id" is the first date-time in the reference
+// document, and the output is identical to the chunk that the base
+// collector (see NewBaseCollector) produces from the same samples,
+// without a metadata document.
+func EncodeChunk(reference *birch.Document, columns [][]int64) ([]byte, error) {
+	return EncodeChunkWithOptions(reference, columns, CollectorOptions{})
+}
+
+// EncodeChunkWithOptions is the same as EncodeChunk, but uses the
+// options to control how the chunk is compressed, and which values
+// are metrics.
+func EncodeChunkWithOptions(reference *birch.Document, columns [][]int64, opts CollectorOptions) ([]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid collector options")
+	}
+
+	if reference == nil {
+		return nil, errors.New("no reference document")
+	}
+
+	metrics, err := extractMetricsFromDocument(reference, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "problem extracting metrics from reference document")
+	}
+
+	if len(columns) != len(metrics.values) {
+		return nil, errors.Errorf("reference document has %d metrics, but there are %d columns", len(metrics.values), len(columns))
+	}
+
+	samples := 1
+	if len(columns) > 0 {
+		samples = len(columns[0])
+	}
+	if samples == 0 {
+		return nil, errors.New("columns must include the reference sample")
+	}
+
+	for idx := range columns {
+		if len(columns[idx]) != samples {
+			return nil, errors.Errorf("column %d has %d values, not %d", idx, len(columns[idx]), samples)
+		}
+
+		if first := metricValue(metrics.values[idx]); columns[idx][0] != first {
+			return nil, errors.Errorf("column %d begins with %d, but the reference document has %d", idx, columns[idx][0], first)
+		}
+	}
+
+	data, err := encodeChunkPayload(reference, len(columns), samples-1, opts, func(metric, sample int) int64 {
+		return columns[metric][sample+1] - columns[metric][sample]
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	if err = writeChunkDocument(buf, metrics.ts, data, opts.Compression); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Encode renders the chunk as a metric chunk (type 1) document, using
+// EncodeChunk, which makes it possible to write chunks that have been
+// sliced (see Slice and Window) or combined (see ConcatChunks). Chunks
+// that were read with a projection are encoded with only the selected
+// metrics. The metadata documents that preceded the chunk are not
+// included.
+func (c *Chunk) Encode() ([]byte, error) {
+	opts := CollectorOptions{}
+	columns := make([][]int64, len(c.Metrics))
+	for idx := range c.Metrics {
+		columns[idx] = c.Metrics[idx].Values
+
+		// chunks with decimal or null metrics were recorded
+		// with the option, and are encoded the same way.
+		if isDecimalOrNullType(c.Metrics[idx].originalType) {
+			opts.DecimalAndNullValues = true
+		}
+	}
+
+	return EncodeChunkWithOptions(c.reference, columns, opts)
+}
+
+// encodeChunkPayload renders the compressed payload of a metric
+// chunk: the reference document, the number of metrics and deltas,
+// and the deltas for each metric, in metric order, with runs of zeros
+// compressed. The delta function returns the difference between the
+// sample and the previous sample, counting from the first sample
+// after the reference document.
+func encodeChunkPayload(reference *birch.Document, numMetrics, numDeltas int, opts CollectorOptions, delta func(metric, sample int) int64) ([]byte, error) {
+	payload := bytes.NewBuffer([]byte{})
+	if _, err := reference.WriteTo(payload); err != nil {
+		return nil, errors.Wrap(err, "problem writing reference document")
+	}
+
+	payload.Write(encodeSizeValue(uint32(numMetrics)))
+	payload.Write(encodeSizeValue(uint32(numDeltas)))
+	zeroCount := int64(0)
+	for i := 0; i < numMetrics; i++ {
+		for j := 0; j < numDeltas; j++ {
+			value := delta(i, j)
+
+			if value == 0 {
+				zeroCount++
+				continue
+			}
+
+			if zeroCount > 0 {
+				payload.Write(encodeValue(0))
+				payload.Write(encodeValue(zeroCount - 1))
+				zeroCount = 0
+			}
+
+			payload.Write(encodeValue(value))
+		}
+	}
+	if zeroCount > 0 {
+		payload.Write(encodeValue(0))
+		payload.Write(encodeValue(zeroCount - 1))
+	}
+
+	data, err := compressPayload(payload.Bytes(), opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "problem compressing payload")
+	}
+
+	return data, nil
+}
+
+// writeChunkDocument writes the metric chunk document for the
+// compressed payload. The codec is only recorded when it is not zlib,
+// so that the default chunks are identical to mongod's.
+func writeChunkDocument(w io.Writer, id time.Time, data []byte, compression Compression) error {
+	doc := birch.NewDocument(
+		birch.EC.Time("_id", id),
+		birch.EC.Int32("type", 1),
+		birch.EC.Binary("data", data))
+	if !compression.isZlib() {
+		doc.Append(birch.EC.String(chunkCompressionField, string(compression)))
+	}
+
+	_, err := doc.WriteTo(w)
+
+	return errors.Wrap(err, "problem writing metric chunk document")
+}
@@ -0,0 +1,159 @@
+package ftdc
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeChunk(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	docs := []*birch.Document{}
+	for i := 0; i < 50; i++ {
+		docs = append(docs, birch.NewDocument(
+			birch.EC.Time("ts", start.Add(time.Duration(i)*time.Second)),
+			birch.EC.String("name", "server"),
+			birch.EC.Double("ratio", float64(i)/3),
+			birch.EC.Int32("small", int32(i%4)),
+			birch.EC.Int64("large", int64(i)<<40),
+			birch.EC.Boolean("odd", i%2 == 1),
+			birch.EC.Timestamp("optime", uint32(1000+i/10), uint32(i%10)),
+			birch.EC.SubDocument("nested", birch.NewDocument(
+				birch.EC.Int64("zero", 0),
+				birch.EC.Array("list", birch.NewArray(birch.VC.Int64(int64(i)), birch.VC.Int32(7))),
+			)),
+		))
+	}
+
+	collector := NewBaseCollector(len(docs))
+	for _, doc := range docs {
+		require.NoError(t, collector.Add(doc))
+	}
+	expected, err := collector.Resolve()
+	require.NoError(t, err)
+
+	t.Run("Columns", func(t *testing.T) {
+		columns := [][]int64{}
+		for _, doc := range docs {
+			metrics, err := extractMetricsFromDocument(doc, CollectorOptions{})
+			require.NoError(t, err)
+			if len(columns) == 0 {
+				columns = make([][]int64, len(metrics.values))
+			}
+			for idx, value := range metrics.values {
+				if f, ok := value.DoubleOK(); ok {
+					columns[idx] = append(columns[idx], normalizeFloat(f))
+				} else {
+					columns[idx] = append(columns[idx], value.Int64())
+				}
+			}
+		}
+		require.Len(t, columns, 10)
+
+		out, err := EncodeChunk(docs[0], columns)
+		require.NoError(t, err)
+		assert.Equal(t, expected, out)
+	})
+	t.Run("Chunk", func(t *testing.T) {
+		iter := ReadChunks(ctx, bytes.NewReader(expected))
+		require.True(t, iter.Next())
+		chunk := iter.Chunk()
+		iter.Close()
+
+		out, err := chunk.Encode()
+		require.NoError(t, err)
+		assert.Equal(t, expected, out)
+	})
+	t.Run("Window", func(t *testing.T) {
+		iter := ReadChunks(ctx, bytes.NewReader(expected))
+		require.True(t, iter.Next())
+		chunk := iter.Chunk()
+		iter.Close()
+
+		window := chunk.Window(start.Add(10*time.Second), start.Add(20*time.Second))
+		require.NotNil(t, window)
+		out, err := window.Encode()
+		require.NoError(t, err)
+
+		iter = ReadChunks(ctx, bytes.NewReader(out))
+		require.True(t, iter.Next())
+		decoded := iter.Chunk()
+		assert.False(t, iter.Next())
+		require.NoError(t, iter.Err())
+
+		assert.Equal(t, 10, decoded.Size())
+		assert.True(t, start.Add(10*time.Second).Equal(decoded.StartTime()))
+		assert.Equal(t, window.Timestamps(), decoded.Timestamps())
+		for idx := range window.Metrics {
+			assert.Equal(t, window.Metrics[idx].Key(), decoded.Metrics[idx].Key())
+			assert.Equal(t, window.Metrics[idx].Type(), decoded.Metrics[idx].Type())
+		}
+
+		samples := []*birch.Document{}
+		for doc := range decoded.StructuredSamples() {
+			samples = append(samples, doc)
+		}
+		require.Len(t, samples, 10)
+		for idx, doc := range samples {
+			source := docs[idx+10]
+			assert.Equal(t, source.Lookup("ratio").Double(), doc.Lookup("ratio").Double())
+			assert.Equal(t, source.Lookup("large").Int64(), doc.Lookup("large").Int64())
+			assert.Equal(t, source.Lookup("odd").Boolean(), doc.Lookup("odd").Boolean())
+			assert.True(t, source.Lookup("ts").Time().Equal(doc.Lookup("ts").Time()))
+		}
+	})
+	t.Run("Projection", func(t *testing.T) {
+		iter := ReadChunksWithOptions(ctx, bytes.NewReader(expected), ReadOptions{Include: []string{"large", "nested.*"}})
+		require.True(t, iter.Next())
+		chunk := iter.Chunk()
+		iter.Close()
+
+		out, err := chunk.Encode()
+		require.NoError(t, err)
+
+		iter = ReadChunks(ctx, bytes.NewReader(out))
+		require.True(t, iter.Next())
+		decoded := iter.Chunk()
+		iter.Close()
+
+		require.Equal(t, chunk.Len(), decoded.Len())
+		for idx := range chunk.Metrics {
+			assert.Equal(t, chunk.Metrics[idx].Key(), decoded.Metrics[idx].Key())
+			assert.Equal(t, chunk.Metrics[idx].Values, decoded.Metrics[idx].Values)
+		}
+	})
+	t.Run("Errors", func(t *testing.T) {
+		reference := birch.NewDocument(birch.EC.Int64("a", 1), birch.EC.Int64("b", 2))
+
+		_, err := EncodeChunk(nil, nil)
+		assert.Error(t, err)
+		_, err = EncodeChunk(reference, [][]int64{{1, 2}})
+		assert.Error(t, err)
+		_, err = EncodeChunk(reference, [][]int64{{1, 2}, {2}})
+		assert.Error(t, err)
+		_, err = EncodeChunk(reference, [][]int64{{}, {}})
+		assert.Error(t, err)
+		_, err = EncodeChunk(reference, [][]int64{{1, 2}, {3, 4}})
+		assert.Error(t, err)
+		_, err = EncodeChunk(reference, [][]int64{{1, 2}, {2, 3}, {3, 4}})
+		assert.Error(t, err)
+		_, err = EncodeChunk(birch.NewDocument(birch.EC.Double("a", 1.5)), [][]int64{{1, 2}})
+		assert.Error(t, err)
+
+		out, err := EncodeChunk(reference, [][]int64{{1, 2, 3}, {2, 2, 2}})
+		require.NoError(t, err)
+		iter := ReadChunks(ctx, bytes.NewReader(out))
+		require.True(t, iter.Next())
+		assert.Equal(t, []int64{1, 2, 3}, iter.Chunk().Metrics[0].Values)
+		assert.Equal(t, []int64{2, 2, 2}, iter.Chunk().Metrics[1].Values)
+		iter.Close()
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/evergreen-ci/birch"
+	"github.com/mongodb/ftdc"
 	"github.com/mongodb/ftdc/hdrhist"
 )
 
@@ -85,8 +86,18 @@ func newMillisecondCounterHistogram() *hdrhist.Histogram {
 }
 
 func (p *PerformanceHDR) MarshalDocument() (*birch.Document, error) {
+	return p.document(birch.EC.Time("ts", p.Timestamp)), nil
+}
+
+// MarshalDocumentNanoseconds is the same as MarshalDocument, but
+// records the timestamp at nanosecond precision.
+func (p *PerformanceHDR) MarshalDocumentNanoseconds() (*birch.Document, error) {
+	return p.document(ftdc.NanosecondTime("ts", p.Timestamp)), nil
+}
+
+func (p *PerformanceHDR) document(ts *birch.Element) *birch.Document {
 	return birch.DC.Elements(
-		birch.EC.Time("ts", p.Timestamp),
+		ts,
 		birch.EC.Int64("id", p.ID),
 		birch.EC.SubDocumentFromElements("counters",
 			birch.EC.DocumentMarshaler("n", p.Counters.Number),
@@ -103,7 +114,7 @@ func (p *PerformanceHDR) MarshalDocument() (*birch.Document, error) {
 			birch.EC.Int64("workers", p.Gauges.Workers),
 			birch.EC.Boolean("failed", p.Gauges.Failed),
 		),
-	), nil
+	)
 }
 
 func (p *PerformanceHDR) setTimestamp(started time.Time) {
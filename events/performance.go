@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/evergreen-ci/birch"
+	"github.com/mongodb/ftdc"
 	"github.com/pkg/errors"
 )
 
@@ -65,8 +66,19 @@ func (p *Performance) MarshalBSON() ([]byte, error) { return birch.MarshalDocume
 // MarshalDocument exports the Performance type as a birch.Document to
 // support more efficient operations.
 func (p *Performance) MarshalDocument() (*birch.Document, error) {
+	return p.document(birch.EC.Time("ts", p.Timestamp)), nil
+}
+
+// MarshalDocumentNanoseconds is the same as MarshalDocument, but
+// records the timestamp at nanosecond precision, for collectors that
+// record times at nanosecond precision.
+func (p *Performance) MarshalDocumentNanoseconds() (*birch.Document, error) {
+	return p.document(ftdc.NanosecondTime("ts", p.Timestamp)), nil
+}
+
+func (p *Performance) document(ts *birch.Element) *birch.Document {
 	return birch.DC.Elements(
-		birch.EC.Time("ts", p.Timestamp),
+		ts,
 		birch.EC.Int64("id", p.ID),
 		birch.EC.SubDocument("counters", birch.DC.Elements(
 			birch.EC.Int64("n", p.Counters.Number),
@@ -83,7 +95,7 @@ func (p *Performance) MarshalDocument() (*birch.Document, error) {
 			birch.EC.Int64("workers", p.Gauges.Workers),
 			birch.EC.Boolean("failed", p.Gauges.Failed),
 		)),
-	), nil
+	)
 }
 
 func (p *Performance) UnmarshalDocument(doc *birch.Document) error {
@@ -92,7 +104,11 @@ func (p *Performance) UnmarshalDocument(doc *birch.Document) error {
 		elem := iter.Element()
 		switch elem.Key() {
 		case "ts":
-			p.Timestamp = elem.Value().Time()
+			if ts, ok := ftdc.NanosecondTimeValue(elem.Value()); ok {
+				p.Timestamp = ts
+			} else {
+				p.Timestamp = elem.Value().Time()
+			}
 		case "id":
 		case "counters":
 			if err := p.Counters.UnmarshalDocument(elem.Value().MutableDocument()); err != nil {
@@ -1,7 +1,12 @@
 package events
 
 import (
+	"bytes"
+	"context"
 	"testing"
+	"time"
+
+	"github.com/mongodb/ftdc"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -34,6 +39,32 @@ func TestPerformanceType(t *testing.T) {
 		require.NotNil(t, doc)
 		assert.Equal(t, 5, doc.Len())
 	})
+	t.Run("NanosecondTimestamp", func(t *testing.T) {
+		ts := time.Date(2020, 1, 1, 0, 0, 0, 123456789, time.UTC)
+		perf := &Performance{Timestamp: ts, Counters: PerformanceCounters{Number: 2}}
+
+		collector, err := ftdc.NewBaseCollectorWithOptions(10, ftdc.CollectorOptions{NanosecondTimes: true})
+		require.NoError(t, err)
+		require.NoError(t, collector.Add(perf))
+		out, err := collector.Resolve()
+		require.NoError(t, err)
+
+		// the documents hold date-times, with millisecond
+		// precision, and the iterator's timestamps hold the
+		// nanoseconds.
+		iter := ftdc.ReadStructuredMetrics(context.Background(), bytes.NewReader(out))
+		samples := 0
+		for iter.Next() {
+			read := &Performance{}
+			require.NoError(t, read.UnmarshalDocument(iter.Document()))
+			assert.True(t, ts.Truncate(time.Millisecond).Equal(read.Timestamp), "%s != %s", ts, read.Timestamp)
+			assert.True(t, ts.Equal(iter.(ftdc.TimestampIterator).Timestamp()))
+			assert.Equal(t, int64(2), read.Counters.Number)
+			samples++
+		}
+		require.NoError(t, iter.Err())
+		assert.Equal(t, 1, samples)
+	})
 	t.Run("BSON", func(t *testing.T) {
 		perf := &Performance{}
 		out, err := perf.MarshalBSON()
@@ -7,6 +7,7 @@ import (
 
 	"github.com/evergreen-ci/birch"
 	"github.com/evergreen-ci/birch/bsontype"
+	"github.com/pkg/errors"
 )
 
 // Chunk represents a 'metric chunk' of data in the FTDC.
@@ -16,11 +17,116 @@ type Chunk struct {
 	id        time.Time
 	metadata  *birch.Document
 	reference *birch.Document
+
+	// timestamps holds the time, in nanoseconds since the
+	// epoch, of each sample in the chunk, when known. The
+	// timestamp metric is always decoded, even when it is not
+	// selected for inclusion in Metrics.
+	timestamps []int64
+
+	// periodic holds the periodic metadata in effect for the
+	// chunk, and updates holds the periodic metadata documents
+	// that preceded the chunk in the stream.
+	periodic *PeriodicMetadata
+	updates  []*PeriodicMetadata
+}
+
+// PeriodicMetadata represents a periodic metadata (type 2) document,
+// which newer versions of the server write when the metadata that
+// they collect (e.g. server parameters) changes. Each document holds
+// only the fields that changed since the previous periodic metadata
+// document.
+type PeriodicMetadata struct {
+	// ID is the time that the metadata was collected.
+	ID time.Time
+	// Counter is the number of periodic metadata documents since
+	// the last complete document. Documents with a counter of
+	// zero hold the complete metadata.
+	Counter int64
+	// Delta holds the fields from the document in the stream:
+	// the fields that changed since the previous document, or
+	// the complete metadata when the counter is zero.
+	Delta *birch.Document
+	// Document holds the complete metadata as of this document,
+	// reconstructed from the deltas.
+	Document *birch.Document
+
+	// full is a copy of Document, that is only used by the reader.
+	full *birch.Document
 }
 
+// GetMetadata returns the metadata (type 0) document that preceded
+// the chunk in the stream, if any.
 func (c *Chunk) GetMetadata() *birch.Document { return c.metadata }
-func (c *Chunk) Size() int                    { return c.nPoints }
-func (c *Chunk) Len() int                     { return len(c.Metrics) }
+
+// GetPeriodicMetadata returns the complete periodic metadata in effect
+// for the chunk, reconstructed from all of the periodic metadata
+// documents that preceded the chunk in the stream, or nil if there
+// were none.
+func (c *Chunk) GetPeriodicMetadata() *birch.Document {
+	if c.periodic == nil {
+		return nil
+	}
+
+	return c.periodic.Document
+}
+
+// MetadataUpdates returns the periodic metadata documents that were
+// written since the previous chunk, in stream order, which makes it
+// possible to see when the metadata changed within a stream.
+func (c *Chunk) MetadataUpdates() []*PeriodicMetadata { return c.updates }
+
+// currentMetadata returns the most recent metadata document in the
+// stream before the chunk: either the metadata (type 0) document,
+// or, when the stream contains periodic metadata, a periodic metadata
+// document with the complete metadata in effect for the chunk.
+func (c *Chunk) currentMetadata() *birch.Document {
+	if c.periodic == nil {
+		return c.metadata
+	}
+
+	return birch.NewDocument(
+		birch.EC.Time("_id", c.periodic.ID),
+		birch.EC.Int32("type", 2),
+		birch.EC.Int64("counter", c.periodic.Counter),
+		birch.EC.SubDocument("doc", c.periodic.Document),
+	)
+}
+
+func (c *Chunk) Size() int { return c.nPoints }
+func (c *Chunk) Len() int  { return len(c.Metrics) }
+
+// StartTime returns the time that the chunk started, from its "_id",
+// which the collectors set to the time of the first sample.
+func (c *Chunk) StartTime() time.Time { return c.id }
+
+// EndTime returns the time of the last sample in the chunk, or the
+// start time if the chunk has no timestamp metric.
+func (c *Chunk) EndTime() time.Time {
+	times := c.sampleTimes()
+	if len(times) == 0 {
+		return c.id
+	}
+
+	return time.Unix(0, times[len(times)-1])
+}
+
+// Timestamps returns the time of each sample in the chunk, taken from
+// the timestamp metric (see ReadOptions.TimestampKey), or nil if the
+// chunk has no timestamp metric.
+func (c *Chunk) Timestamps() []time.Time {
+	times := c.sampleTimes()
+	if times == nil {
+		return nil
+	}
+
+	out := make([]time.Time, len(times))
+	for idx := range times {
+		out[idx] = time.Unix(0, times[idx])
+	}
+
+	return out
+}
 
 // Iterator returns an iterator that you can use to read documents for
 // each sample period in the chunk. Documents are returned in collection
@@ -33,7 +139,8 @@ func (c *Chunk) Iterator(ctx context.Context) Iterator {
 	return &sampleIterator{
 		closer:   cancel,
 		stream:   c.streamFlattenedDocuments(sctx),
-		metadata: c.GetMetadata(),
+		metadata: c.currentMetadata(),
+		times:    c.sampleTimes(),
 	}
 }
 
@@ -46,10 +153,214 @@ func (c *Chunk) StructuredIterator(ctx context.Context) Iterator {
 	return &sampleIterator{
 		closer:   cancel,
 		stream:   c.streamDocuments(sctx),
-		metadata: c.GetMetadata(),
+		metadata: c.currentMetadata(),
+		times:    c.sampleTimes(),
+	}
+}
+
+// timestampMetric returns the index of the metric that holds the
+// time of each sample, which is the date-time metric with the key, if
+// specified, or the first date-time metric, or -1 if there are no
+// date-time metrics.
+func timestampMetric(metrics []Metric, key string) int {
+	if key != "" {
+		for idx := range metrics {
+			if isTimeMetric(&metrics[idx]) && metrics[idx].Key() == key {
+				return idx
+			}
+		}
+	}
+
+	for idx := range metrics {
+		if isTimeMetric(&metrics[idx]) {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// sampleTimes returns the time of each sample in the chunk, in
+// nanoseconds since the epoch, or nil if the chunk has no timestamp
+// metric.
+func (c *Chunk) sampleTimes() []int64 {
+	if c.timestamps != nil {
+		return c.timestamps
+	}
+
+	if idx := timestampMetric(c.Metrics, ""); idx >= 0 {
+		return c.Metrics[idx].Nanoseconds()
+	}
+
+	return nil
+}
+
+// Slice returns a chunk with the samples in the range [start, end),
+// counting from zero. The new chunk shares the metric values with the
+// original chunk, so the values must not be modified, and its
+// reference document and starting values are re-based on the first
+// sample in the range, as if the chunk had been collected with only
+// those samples. The range must contain at least one sample.
+func (c *Chunk) Slice(start, end int) (*Chunk, error) {
+	if start < 0 || end > c.nPoints || start >= end {
+		return nil, errors.Errorf("invalid range [%d, %d) for chunk with %d samples", start, end, c.nPoints)
+	}
+
+	if start == 0 && end == c.nPoints {
+		return c, nil
+	}
+
+	return c.slice(start, end), nil
+}
+
+// Window returns a chunk with the samples whose timestamps (see
+// Timestamps) fall within the half-open interval [start, end), or nil
+// if there are none, sharing the underlying data as Slice. A zero
+// start or end leaves that side of the interval unbounded. Chunks
+// without a timestamp metric are either returned whole or not at all,
+// depending on whether their start time falls within the interval.
+func (c *Chunk) Window(start, end time.Time) *Chunk {
+	return c.trim(ReadOptions{Start: start, End: end})
+}
+
+// ConcatChunks combines the samples of the chunks, in order, into a
+// single chunk, which is useful to reassemble windows that span
+// several chunks. The chunks must be compatible: they must have the
+// same metrics, with the same keys and types, in the same order,
+// which is the case for consecutive chunks in a stream that did not
+// change schema, and for chunks read with the same options. The new
+// chunk has the reference document and start time of the first
+// chunk, the first metadata document of the chunks, the periodic
+// metadata in effect for the last chunk, and all of the periodic
+// metadata updates of the chunks.
+func ConcatChunks(chunks ...*Chunk) (*Chunk, error) {
+	if len(chunks) == 0 {
+		return nil, errors.New("no chunks to concatenate")
+	}
+
+	first := chunks[0]
+	if len(chunks) == 1 {
+		return first, nil
+	}
+
+	out := &Chunk{
+		Metrics:   make([]Metric, len(first.Metrics)),
+		id:        first.id,
+		metadata:  first.metadata,
+		reference: first.reference,
+		periodic:  chunks[len(chunks)-1].periodic,
+	}
+
+	timed := true
+	for idx, chunk := range chunks {
+		if len(chunk.Metrics) != len(first.Metrics) {
+			return nil, errors.Errorf("chunk %d has %d metrics, not %d", idx, len(chunk.Metrics), len(first.Metrics))
+		}
+
+		for i := range chunk.Metrics {
+			if chunk.Metrics[i].originalType != first.Metrics[i].originalType || chunk.Metrics[i].encoding != first.Metrics[i].encoding || chunk.Metrics[i].Key() != first.Metrics[i].Key() {
+				return nil, errors.Errorf("metric %d of chunk %d is '%s' (%s), not '%s' (%s)", i, idx,
+					chunk.Metrics[i].Key(), chunk.Metrics[i].originalType,
+					first.Metrics[i].Key(), first.Metrics[i].originalType)
+			}
+		}
+
+		if chunk.metadata != nil && out.metadata == nil {
+			out.metadata = chunk.metadata
+		}
+
+		timed = timed && chunk.sampleTimes() != nil
+		out.nPoints += chunk.nPoints
+		out.updates = append(out.updates, chunk.updates...)
+	}
+
+	for i, m := range first.Metrics {
+		m.Values = make([]int64, 0, out.nPoints)
+		for _, chunk := range chunks {
+			m.Values = append(m.Values, chunk.Metrics[i].Values...)
+		}
+		out.Metrics[i] = m
+	}
+
+	if timed {
+		out.timestamps = make([]int64, 0, out.nPoints)
+		for _, chunk := range chunks {
+			out.timestamps = append(out.timestamps, chunk.sampleTimes()...)
+		}
+	}
+
+	return out, nil
+}
+
+// trim returns a chunk with only the samples that fall within the
+// time range specified in the options, or nil if there are no
+// samples within the range. Chunks without a timestamp metric are
+// filtered using their "_id" alone.
+func (c *Chunk) trim(opts ReadOptions) *Chunk {
+	times := c.sampleTimes()
+	if times == nil {
+		if opts.includes(c.id) {
+			return c
+		}
+		return nil
+	}
+
+	start, end := -1, -1
+	for idx, value := range times {
+		if !opts.includes(time.Unix(0, value)) {
+			continue
+		}
+		if start < 0 {
+			start = idx
+		}
+		end = idx + 1
+	}
+
+	switch {
+	case start < 0:
+		return nil
+	case start == 0 && end == c.nPoints:
+		return c
+	default:
+		return c.slice(start, end)
 	}
 }
 
+// slice returns a chunk with the samples in [start, end) that shares
+// the underlying metric values with the original chunk.
+func (c *Chunk) slice(start, end int) *Chunk {
+	out := &Chunk{
+		Metrics:   make([]Metric, len(c.Metrics)),
+		nPoints:   end - start,
+		id:        c.id,
+		metadata:  c.metadata,
+		reference: c.reference,
+		periodic:  c.periodic,
+		updates:   c.updates,
+	}
+
+	for idx, m := range c.Metrics {
+		m.Values = m.Values[start:end:end]
+		if len(m.Values) > 0 {
+			m.startingValue = m.Values[0]
+		}
+		out.Metrics[idx] = m
+	}
+
+	if times := c.sampleTimes(); times != nil {
+		out.timestamps = times[start:end:end]
+	}
+
+	if start > 0 {
+		out.reference, _ = restoreDocument(c.reference, start, c.Metrics, 0, true)
+		if out.timestamps != nil {
+			out.id = time.Unix(0, out.timestamps[0])
+		}
+	}
+
+	return out
+}
+
 // Metric represents an item in a chunk.
 type Metric struct {
 	// For metrics that were derived from nested BSON documents,
@@ -75,8 +386,164 @@ type Metric struct {
 	startingValue int64
 
 	originalType bsontype.Type
+	encoding     metricEncoding
 }
 
+// metricEncoding identifies the metrics whose values are recorded, in
+// the reference document, in a document with a single field (see
+// NanosecondTime and Uint64), rather than as a value of their type.
+type metricEncoding int
+
+const (
+	encodingNone metricEncoding = iota
+	encodingNanoseconds
+	encodingUnsigned
+)
+
+// Key returns the fully qualified key of the metric: the keys of the
+// documents and arrays that hold it, and its own key, separated by
+// dots (e.g. "wiredTiger.cache.bytes read"). Previous versions
+// omitted the keys of the intermediate documents of metrics nested in
+// more than one document, and returned "wiredTiger.bytes read".
 func (m *Metric) Key() string {
 	return strings.Join(append(m.ParentPath, m.KeyName), ".")
 }
+
+// Type returns the BSON type of the values in the source documents,
+// which determines how the values are encoded in Values: doubles hold
+// the bits of the float64 value (see Float64s), booleans are 0 or 1,
+// and date-times are milliseconds since the epoch, or nanoseconds for
+// times recorded at nanosecond precision (see TimePrecision).
+// Timestamps produce two metrics with the Timestamp type: one for the
+// time, and another, whose key ends with ".inc", for the increment.
+// Unsigned integers (see Uint64) are Int64 metrics with the same bits
+// as the integers (see Uint64s). Chunks recorded with the
+// DecimalAndNullValues option also have decimal metrics, which hold
+// the bits of the closest float64, and null and undefined metrics,
+// which are 0.
+func (m *Metric) Type() bsontype.Type { return m.originalType }
+
+// TimePrecision returns the unit of the values of a date-time metric,
+// which is a nanosecond for times recorded at nanosecond precision
+// (see NanosecondTime), and a millisecond otherwise, or zero if the
+// metric is not a date-time.
+func (m *Metric) TimePrecision() time.Duration {
+	switch {
+	case !isTimeMetric(m):
+		return 0
+	case m.encoding == encodingNanoseconds:
+		return time.Nanosecond
+	default:
+		return time.Millisecond
+	}
+}
+
+func isTimeMetric(m *Metric) bool { return m.originalType == bsontype.DateTime }
+
+// Float64s returns the values of the metric as floating point
+// numbers, decoding doubles and decimals, and converting the values
+// of integer, boolean, date-time, and timestamp metrics.
+func (m *Metric) Float64s() []float64 {
+	out := make([]float64, len(m.Values))
+	for idx, value := range m.Values {
+		if m.originalType == bsontype.Double || m.originalType == bsontype.Decimal128 {
+			out[idx] = restoreFloat(value)
+		} else {
+			out[idx] = float64(value)
+		}
+	}
+
+	return out
+}
+
+// Bools returns the values of the metric as booleans, where any
+// non-zero value is true.
+func (m *Metric) Bools() []bool {
+	out := make([]bool, len(m.Values))
+	for idx, value := range m.Values {
+		if m.originalType == bsontype.Double || m.originalType == bsontype.Decimal128 {
+			out[idx] = restoreFloat(value) != 0
+		} else {
+			out[idx] = value != 0
+		}
+	}
+
+	return out
+}
+
+// Times returns the values of a date-time metric as times, or nil if
+// the metric is not a date-time.
+func (m *Metric) Times() []time.Time {
+	if !isTimeMetric(m) {
+		return nil
+	}
+
+	out := make([]time.Time, len(m.Values))
+	for idx, value := range m.Nanoseconds() {
+		out[idx] = time.Unix(0, value)
+	}
+
+	return out
+}
+
+// Nanoseconds returns the values of a date-time metric in nanoseconds
+// since the epoch, whatever their precision (see TimePrecision), or
+// nil if the metric is not a date-time. Unlike the date-times in the
+// documents that the iterators return, which have millisecond
+// precision, these values keep the precision of times recorded with
+// NanosecondTime.
+func (m *Metric) Nanoseconds() []int64 {
+	if !isTimeMetric(m) {
+		return nil
+	}
+
+	unit := int64(m.TimePrecision())
+	out := make([]int64, len(m.Values))
+	for idx, value := range m.Values {
+		out[idx] = value * unit
+	}
+
+	return out
+}
+
+// Uint64s returns the values of a metric recorded as unsigned integers
+// (see Uint64), or nil if the metric does not hold unsigned integers.
+func (m *Metric) Uint64s() []uint64 {
+	if m.encoding != encodingUnsigned {
+		return nil
+	}
+
+	out := make([]uint64, len(m.Values))
+	for idx, value := range m.Values {
+		out[idx] = uint64(value)
+	}
+
+	return out
+}
+
+// BSONTimestamp holds the components of a BSON timestamp value.
+type BSONTimestamp struct {
+	T uint32
+	I uint32
+}
+
+// Timestamps combines the values of the time component of a timestamp
+// metric with the values of its increment, which is the metric that
+// follows it in the chunk, and returns the timestamp values, as in the
+// documents produced by the iterators.
+func (m *Metric) Timestamps(inc *Metric) ([]BSONTimestamp, error) {
+	if m.originalType != bsontype.Timestamp || inc.originalType != bsontype.Timestamp {
+		return nil, errors.Errorf("metrics '%s' and '%s' are not timestamps", m.Key(), inc.Key())
+	}
+
+	if len(m.Values) != len(inc.Values) {
+		return nil, errors.Errorf("timestamp metrics have %d and %d values", len(m.Values), len(inc.Values))
+	}
+
+	out := make([]BSONTimestamp, len(m.Values))
+	for idx := range m.Values {
+		out[idx] = BSONTimestamp{T: uint32(m.Values[idx]), I: uint32(inc.Values[idx])}
+	}
+
+	return out, nil
+}
@@ -12,6 +12,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
 	"github.com/mongodb/ftdc/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -310,3 +312,180 @@ func TestRoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestMetricValues(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	collector := NewBaseCollector(10)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, collector.Add(birch.NewDocument(
+			birch.EC.Time("ts", start.Add(time.Duration(i)*time.Second)),
+			birch.EC.Double("ratio", float64(i)/4),
+			birch.EC.Int32("small", int32(i)),
+			birch.EC.Int64("large", int64(i)<<40),
+			birch.EC.Boolean("odd", i%2 == 1),
+			birch.EC.Timestamp("optime", 100, uint32(i)),
+		)))
+	}
+	data, err := collector.Resolve()
+	require.NoError(t, err)
+
+	iter := ReadChunks(ctx, bytes.NewBuffer(data))
+	defer iter.Close()
+	require.True(t, iter.Next())
+	chunk := iter.Chunk()
+	require.Len(t, chunk.Metrics, 7)
+
+	types := []bsontype.Type{}
+	for _, m := range chunk.Metrics {
+		types = append(types, m.Type())
+	}
+	assert.Equal(t, []bsontype.Type{
+		bsontype.DateTime,
+		bsontype.Double,
+		bsontype.Int32,
+		bsontype.Int64,
+		bsontype.Boolean,
+		bsontype.Timestamp,
+		bsontype.Timestamp,
+	}, types)
+
+	assert.Equal(t, chunk.Timestamps(), chunk.Metrics[0].Times())
+	assert.Nil(t, chunk.Metrics[1].Times())
+	assert.Equal(t, float64(epochMs(start)), chunk.Metrics[0].Float64s()[0])
+
+	_, err = chunk.Metrics[0].Timestamps(&chunk.Metrics[1])
+	assert.Error(t, err)
+	optimes, err := chunk.Metrics[5].Timestamps(&chunk.Metrics[6])
+	require.NoError(t, err)
+	require.Len(t, optimes, 10)
+
+	samples := chunk.StructuredIterator(ctx)
+	defer samples.Close()
+	for i := 0; samples.Next(); i++ {
+		doc := samples.Document()
+
+		assert.Equal(t, float64(i)/4, chunk.Metrics[1].Float64s()[i])
+		assert.Equal(t, doc.Lookup("ratio").Double(), chunk.Metrics[1].Float64s()[i])
+		assert.Equal(t, float64(i), chunk.Metrics[2].Float64s()[i])
+		assert.Equal(t, float64(int64(i)<<40), chunk.Metrics[3].Float64s()[i])
+		assert.Equal(t, i%2 == 1, chunk.Metrics[4].Bools()[i])
+		assert.Equal(t, i != 0, chunk.Metrics[1].Bools()[i])
+
+		ts, inc := doc.Lookup("optime").Timestamp()
+		assert.Equal(t, BSONTimestamp{T: 100, I: uint32(i)}, optimes[i])
+		assert.Equal(t, BSONTimestamp{T: ts, I: inc}, optimes[i])
+	}
+}
+
+func TestChunkSlicing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	chunks := []*Chunk{}
+	iter := ReadChunks(ctx, bytes.NewReader(produceTimedPayload(t, start, 30, 10)))
+	for iter.Next() {
+		chunks = append(chunks, iter.Chunk())
+	}
+	require.NoError(t, iter.Err())
+	require.Len(t, chunks, 3)
+
+	samples := func(chunk *Chunk) []string {
+		out := []string{}
+		for doc := range chunk.StructuredSamples() {
+			out = append(out, doc.String())
+		}
+		return out
+	}
+	all := append(append(samples(chunks[0]), samples(chunks[1])...), samples(chunks[2])...)
+
+	t.Run("Slice", func(t *testing.T) {
+		chunk := chunks[1]
+		sub, err := chunk.Slice(2, 7)
+		require.NoError(t, err)
+		assert.Equal(t, 5, sub.Size())
+		assert.Equal(t, chunk.Len(), sub.Len())
+		assert.True(t, start.Add(12*time.Second).Equal(sub.StartTime()))
+		assert.True(t, start.Add(16*time.Second).Equal(sub.EndTime()))
+		assert.Equal(t, chunk.Timestamps()[2:7], sub.Timestamps())
+		assert.Equal(t, all[12:17], samples(sub))
+		assert.Equal(t, &chunk.Metrics[1].Values[2], &sub.Metrics[1].Values[0])
+		assert.EqualValues(t, 12, sub.reference.Lookup("counter").Interface())
+
+		same, err := chunk.Slice(0, chunk.Size())
+		require.NoError(t, err)
+		assert.Equal(t, chunk, same)
+
+		for _, bounds := range [][2]int{{-1, 2}, {3, 3}, {4, 2}, {0, 11}} {
+			_, err = chunk.Slice(bounds[0], bounds[1])
+			assert.Error(t, err)
+		}
+	})
+	t.Run("Window", func(t *testing.T) {
+		chunk := chunks[1]
+		sub := chunk.Window(start.Add(12*time.Second), start.Add(15*time.Second))
+		require.NotNil(t, sub)
+		assert.Equal(t, all[12:15], samples(sub))
+
+		assert.Equal(t, all[15:20], samples(chunk.Window(start.Add(15*time.Second), time.Time{})))
+		assert.Equal(t, all[10:13], samples(chunk.Window(time.Time{}, start.Add(13*time.Second))))
+		assert.Equal(t, chunk, chunk.Window(time.Time{}, time.Time{}))
+		assert.Nil(t, chunk.Window(start, start.Add(10*time.Second)))
+		assert.Nil(t, chunk.Window(start.Add(20*time.Second), time.Time{}))
+	})
+	t.Run("Concat", func(t *testing.T) {
+		combined, err := ConcatChunks(chunks...)
+		require.NoError(t, err)
+		assert.Equal(t, 30, combined.Size())
+		assert.True(t, start.Equal(combined.StartTime()))
+		assert.True(t, start.Add(29*time.Second).Equal(combined.EndTime()))
+		assert.Equal(t, all, samples(combined))
+		assert.Equal(t, chunks[0].GetMetadata(), combined.GetMetadata())
+
+		window := combined.Window(start.Add(8*time.Second), start.Add(23*time.Second))
+		require.NotNil(t, window)
+		assert.Equal(t, all[8:23], samples(window))
+
+		first := chunks[0].Window(start.Add(8*time.Second), time.Time{})
+		second := chunks[2].Window(time.Time{}, start.Add(23*time.Second))
+		combined, err = ConcatChunks(first, chunks[1], second)
+		require.NoError(t, err)
+		assert.Equal(t, all[8:23], samples(combined))
+		assert.Equal(t, window.Timestamps(), combined.Timestamps())
+
+		single, err := ConcatChunks(chunks[0])
+		require.NoError(t, err)
+		assert.Equal(t, chunks[0], single)
+	})
+	t.Run("Incompatible", func(t *testing.T) {
+		_, err := ConcatChunks()
+		assert.Error(t, err)
+
+		iter := ReadChunksWithOptions(ctx, bytes.NewReader(produceTimedPayload(t, start, 10, 10)), ReadOptions{Include: []string{"counter"}})
+		require.True(t, iter.Next())
+		projected := iter.Chunk()
+		iter.Close()
+
+		_, err = ConcatChunks(chunks[0], projected)
+		assert.Error(t, err)
+
+		collector := NewBaseCollector(10)
+		require.NoError(t, collector.Add(birch.NewDocument(
+			birch.EC.Time("ts", start),
+			birch.EC.Int64("counter", 1),
+			birch.EC.Double("gauge", 1),
+		)))
+		payload, err := collector.Resolve()
+		require.NoError(t, err)
+		iter = ReadChunks(ctx, bytes.NewReader(payload))
+		require.True(t, iter.Next())
+		retyped := iter.Chunk()
+		iter.Close()
+
+		_, err = ConcatChunks(chunks[0], retyped)
+		assert.Error(t, err)
+	})
+}
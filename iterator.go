@@ -3,9 +3,9 @@ package ftdc
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/evergreen-ci/birch"
-	"github.com/mongodb/ftdc/util"
 )
 
 type Iterator interface {
@@ -16,37 +16,47 @@ type Iterator interface {
 	Close()
 }
 
+// TimestampIterator is an Iterator that also reports the time of the
+// current document. The iterators returned by this package implement
+// it, so callers can type-assert an Iterator to a TimestampIterator.
+type TimestampIterator interface {
+	Iterator
+	// Timestamp returns the time of the current document, taken
+	// from the timestamp metric (see ReadOptions.TimestampKey),
+	// or the zero time when the time of the document is not
+	// known. For iterators that return one document per chunk,
+	// this is the start time of the chunk.
+	Timestamp() time.Time
+}
+
 // ReadMetrics returns a standard document iterator that reads FTDC
 // chunks. The Documents returned by the iterator are flattened.
 func ReadMetrics(ctx context.Context, r io.Reader) Iterator {
-	iterctx, cancel := context.WithCancel(ctx)
-	iter := &combinedIterator{
-		closer:  cancel,
-		chunks:  ReadChunks(iterctx, r),
-		flatten: true,
-		pipe:    make(chan *birch.Document, 100),
-		catcher: util.NewCatcher(),
-	}
+	return ReadMetricsWithOptions(ctx, r, ReadOptions{})
+}
 
-	go iter.worker(iterctx)
-	return iter
+// ReadMetricsWithOptions is the same as ReadMetrics, but uses the
+// options to control which data the iterator decodes.
+func ReadMetricsWithOptions(ctx context.Context, r io.Reader, opts ReadOptions) Iterator {
+	return newCombinedIterator(ctx, opts, func(ctx context.Context) *ChunkIterator {
+		return ReadChunksWithOptions(ctx, r, opts)
+	}, true)
 }
 
 // ReadStructuredMetrics returns a standard document iterator that reads FTDC
 // chunks. The Documents returned by the iterator retain the structure
 // of the input documents.
 func ReadStructuredMetrics(ctx context.Context, r io.Reader) Iterator {
-	iterctx, cancel := context.WithCancel(ctx)
-	iter := &combinedIterator{
-		closer:  cancel,
-		chunks:  ReadChunks(iterctx, r),
-		flatten: false,
-		pipe:    make(chan *birch.Document, 100),
-		catcher: util.NewCatcher(),
-	}
+	return ReadStructuredMetricsWithOptions(ctx, r, ReadOptions{})
+}
 
-	go iter.worker(iterctx)
-	return iter
+// ReadStructuredMetricsWithOptions is the same as
+// ReadStructuredMetrics, but uses the options to control which data
+// the iterator decodes.
+func ReadStructuredMetricsWithOptions(ctx context.Context, r io.Reader, opts ReadOptions) Iterator {
+	return newCombinedIterator(ctx, opts, func(ctx context.Context) *ChunkIterator {
+		return ReadChunksWithOptions(ctx, r, opts)
+	}, false)
 }
 
 // ReadMatrix returns a "matrix format" for the data in a chunk. The
@@ -57,16 +67,15 @@ func ReadStructuredMetrics(ctx context.Context, r io.Reader) Iterator {
 // The matrix documents have full type fidelity, but are not
 // substantially less expensive to produce than full iteration.
 func ReadMatrix(ctx context.Context, r io.Reader) Iterator {
-	iterctx, cancel := context.WithCancel(ctx)
-	iter := &matrixIterator{
-		closer:  cancel,
-		chunks:  ReadChunks(iterctx, r),
-		pipe:    make(chan *birch.Document, 25),
-		catcher: util.NewCatcher(),
-	}
+	return ReadMatrixWithOptions(ctx, r, ReadOptions{})
+}
 
-	go iter.worker(iterctx)
-	return iter
+// ReadMatrixWithOptions is the same as ReadMatrix, but uses the
+// options to control which data the iterator decodes.
+func ReadMatrixWithOptions(ctx context.Context, r io.Reader, opts ReadOptions) Iterator {
+	return newMatrixIterator(ctx, opts, func(ctx context.Context) *ChunkIterator {
+		return ReadChunksWithOptions(ctx, r, opts)
+	}, false)
 }
 
 // ReadSeries is similar to the ReadMatrix format, and produces a
@@ -94,15 +103,13 @@ func ReadMatrix(ctx context.Context, r io.Reader) Iterator {
 //
 // Although the *birch.Document type does support iteration directly.
 func ReadSeries(ctx context.Context, r io.Reader) Iterator {
-	iterctx, cancel := context.WithCancel(ctx)
-	iter := &matrixIterator{
-		closer:  cancel,
-		chunks:  ReadChunks(iterctx, r),
-		pipe:    make(chan *birch.Document, 25),
-		catcher: util.NewCatcher(),
-		reflect: true,
-	}
+	return ReadSeriesWithOptions(ctx, r, ReadOptions{})
+}
 
-	go iter.worker(iterctx)
-	return iter
+// ReadSeriesWithOptions is the same as ReadSeries, but uses the
+// options to control which data the iterator decodes.
+func ReadSeriesWithOptions(ctx context.Context, r io.Reader, opts ReadOptions) Iterator {
+	return newMatrixIterator(ctx, opts, func(ctx context.Context) *ChunkIterator {
+		return ReadChunksWithOptions(ctx, r, opts)
+	}, true)
 }
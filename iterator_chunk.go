@@ -3,9 +3,11 @@ package ftdc
 import (
 	"context"
 	"io"
+	"sort"
+	"sync"
 
-	"github.com/evergreen-ci/birch"
 	"github.com/mongodb/ftdc/util"
+	"github.com/pkg/errors"
 )
 
 // ChunkIterator is a simple iterator for reading off of an FTDC data
@@ -38,25 +40,67 @@ type ChunkIterator struct {
 	cancel  context.CancelFunc
 	closed  bool
 	catcher util.Catcher
+	skipped []*ChunkError
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+
+	// when reading synchronously, chunks are read from the source
+	// by Next, rather than from the pipe.
+	ctx    context.Context
+	source chunkSource
+	done   bool
+}
+
+// chunkSource produces chunks on demand, and returns io.EOF when
+// there are no more chunks.
+type chunkSource interface {
+	next() (*Chunk, error)
+	close()
 }
 
 // ReadChunks creates a ChunkIterator from an underlying FTDC data
 // source.
 func ReadChunks(ctx context.Context, r io.Reader) *ChunkIterator {
+	return ReadChunksWithOptions(ctx, r, ReadOptions{})
+}
+
+// ReadChunksWithOptions creates a ChunkIterator from an underlying
+// FTDC data source, using the options to control which data the
+// iterator decodes. If the options are invalid, the iterator returns
+// no chunks and reports the error from its Err method.
+func ReadChunksWithOptions(ctx context.Context, r io.Reader, opts ReadOptions) *ChunkIterator {
 	iter := &ChunkIterator{
 		catcher: util.NewCatcher(),
 		pipe:    make(chan *Chunk, 2),
 	}
 
-	ipc := make(chan *birch.Document)
 	ctx, iter.cancel = context.WithCancel(ctx)
 
+	if err := opts.Validate(); err != nil {
+		iter.catcher.Add(errors.Wrap(err, "invalid read options"))
+		close(iter.pipe)
+		return iter
+	}
+
+	if opts.Synchronous {
+		iter.ctx = ctx
+		iter.source = newChunkDecoder(newDocumentReader(r, opts, iter.addSkipped), opts, iter.addSkipped)
+		return iter
+	}
+
+	ipc := make(chan sourceDocument)
+	iter.wg.Add(2)
+
 	go func() {
-		iter.catcher.Add(readDiagnostic(ctx, r, ipc))
+		defer iter.wg.Done()
+		iter.catcher.Add(readDiagnostic(ctx, r, ipc, opts, iter.addSkipped))
 	}()
 
 	go func() {
-		iter.catcher.Add(readChunks(ctx, ipc, iter.pipe))
+		defer iter.wg.Done()
+		iter.catcher.Add(readChunks(ctx, ipc, iter.pipe, opts, iter.addSkipped))
+		// release the reader if decoding stopped early.
+		iter.cancel()
 	}()
 
 	return iter
@@ -66,6 +110,10 @@ func ReadChunks(ctx context.Context, r io.Reader) *ChunkIterator {
 // chunk that is unprocessed. Use the Chunk() method to access the
 // iterator.
 func (iter *ChunkIterator) Next() bool {
+	if iter.source != nil {
+		return iter.pull()
+	}
+
 	next, ok := <-iter.pipe
 	if !ok {
 		return false
@@ -75,6 +123,34 @@ func (iter *ChunkIterator) Next() bool {
 	return true
 }
 
+// pull reads the next chunk from the source, when reading
+// synchronously.
+func (iter *ChunkIterator) pull() bool {
+	if iter.done || iter.closed || iter.ctx.Err() != nil {
+		iter.release()
+		return false
+	}
+
+	next, err := iter.source.next()
+	if err != nil {
+		if err != io.EOF {
+			iter.catcher.Add(err)
+		}
+		iter.release()
+		return false
+	}
+
+	iter.next = next
+	return true
+}
+
+func (iter *ChunkIterator) release() {
+	if !iter.done {
+		iter.done = true
+		iter.source.close()
+	}
+}
+
 // Chunk returns a copy of the chunk processed by the iterator. You
 // must call Chunk no more than once per iteration. Additional
 // accesses to Chunk will panic.
@@ -86,8 +162,49 @@ func (iter *ChunkIterator) Chunk() *Chunk {
 // release those resources if you stop iterating before the iterator
 // is exhausted. Canceling the context that you used to create the
 // iterator has the same effect.
-func (iter *ChunkIterator) Close() { iter.cancel(); iter.closed = true }
+func (iter *ChunkIterator) Close() {
+	iter.cancel()
+	iter.closed = true
+	if iter.source != nil {
+		iter.release()
+	}
+}
+
+// wait blocks until the goroutines reading the source have exited,
+// which happens promptly after the iterator is closed, once any read
+// from the source that's in progress returns.
+func (iter *ChunkIterator) wait() { iter.wg.Wait() }
 
 // Err returns a non-nil error if the iterator encountered any errors
 // during iteration.
 func (iter *ChunkIterator) Err() error { return iter.catcher.Resolve() }
+
+// Skipped returns a description of every chunk, or damaged region of
+// the source, that the iterator has skipped so far, ordered by their
+// position in the source. When reading a directory, the chunks are
+// ordered by file, and the chunks in each file are reported after the
+// iterator has finished reading the file. Chunks are only skipped when reading with
+// the SkipCorrupt option; otherwise the iterator stops and reports
+// the problem from Err. It is safe to call Skipped during iteration.
+func (iter *ChunkIterator) Skipped() []*ChunkError {
+	iter.mu.Lock()
+	defer iter.mu.Unlock()
+
+	out := make([]*ChunkError, len(iter.skipped))
+	copy(out, iter.skipped)
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].File != out[j].File {
+			return out[i].File < out[j].File
+		}
+		return out[i].Offset < out[j].Offset
+	})
+
+	return out
+}
+
+func (iter *ChunkIterator) addSkipped(err *ChunkError) {
+	iter.mu.Lock()
+	defer iter.mu.Unlock()
+
+	iter.skipped = append(iter.skipped, err)
+}
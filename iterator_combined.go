@@ -2,6 +2,7 @@ package ftdc
 
 import (
 	"context"
+	"time"
 
 	"github.com/evergreen-ci/birch"
 	"github.com/mongodb/ftdc/util"
@@ -11,28 +12,68 @@ import (
 type combinedIterator struct {
 	closer   context.CancelFunc
 	chunks   *ChunkIterator
-	sample   *sampleIterator
+	done     chan struct{}
 	metadata *birch.Document
 	document *birch.Document
-	pipe     chan *birch.Document
+	ts       time.Time
+	pipe     chan iteratorDocument
 	catcher  util.Catcher
 	flatten  bool
 }
 
-func (iter *combinedIterator) Close() {
-	iter.closer()
-	if iter.sample != nil {
-		iter.sample.Close()
+// newCombinedIterator starts an iterator that returns the samples
+// from each of the chunks produced by the chunk iterator, which is
+// created with a context that's canceled when the iterator is closed.
+// When reading synchronously, the iterator decodes the samples when
+// Next is called, instead.
+func newCombinedIterator(ctx context.Context, opts ReadOptions, chunks func(context.Context) *ChunkIterator, flatten bool) Iterator {
+	if opts.Synchronous {
+		if flatten {
+			return newPullIterator(chunks(ctx), pullFlattened)
+		}
+		return newPullIterator(chunks(ctx), pullStructured)
 	}
 
+	iterctx, cancel := context.WithCancel(ctx)
+	iter := &combinedIterator{
+		closer:  cancel,
+		chunks:  chunks(iterctx),
+		flatten: flatten,
+		pipe:    make(chan iteratorDocument, 100),
+		done:    make(chan struct{}),
+		catcher: util.NewCatcher(),
+	}
+
+	go iter.worker(iterctx)
+	return iter
+}
+
+// iteratorDocument pairs a document produced by an iterator's worker
+// with the metadata that was in effect when it was collected.
+type iteratorDocument struct {
+	document *birch.Document
+	metadata *birch.Document
+	ts       time.Time
+}
+
+func (iter *combinedIterator) Close() {
+	iter.closer()
 	if iter.chunks != nil {
 		iter.chunks.Close()
 	}
 }
 
+// wait blocks until the worker, and the goroutines reading chunks,
+// have exited, which happens promptly after the iterator is closed.
+func (iter *combinedIterator) wait() {
+	<-iter.done
+	iter.chunks.wait()
+}
+
 func (iter *combinedIterator) Err() error                { return iter.catcher.Resolve() }
 func (iter *combinedIterator) Metadata() *birch.Document { return iter.metadata }
 func (iter *combinedIterator) Document() *birch.Document { return iter.document }
+func (iter *combinedIterator) Timestamp() time.Time      { return iter.ts }
 
 func (iter *combinedIterator) Next() bool {
 	doc, ok := <-iter.pipe
@@ -40,42 +81,40 @@ func (iter *combinedIterator) Next() bool {
 		return false
 	}
 
-	iter.document = doc
+	iter.document = doc.document
+	iter.metadata = doc.metadata
+	iter.ts = doc.ts
 	return true
 }
 
 func (iter *combinedIterator) worker(ctx context.Context) {
+	defer close(iter.done)
 	defer close(iter.pipe)
-	var ok bool
 
 	for iter.chunks.Next() {
 		chunk := iter.chunks.Chunk()
+		metadata := chunk.currentMetadata()
+		times := chunk.sampleTimes()
 
-		if iter.flatten {
-			iter.sample, ok = chunk.Iterator(ctx).(*sampleIterator)
-		} else {
-			iter.sample, ok = chunk.StructuredIterator(ctx).(*sampleIterator)
-		}
-		if !ok {
-			iter.catcher.Add(errors.New("programmer error"))
-			return
-		}
-		if iter.metadata != nil {
-			iter.metadata = chunk.GetMetadata()
-		}
+		for i := 0; i < chunk.nPoints; i++ {
+			next := iteratorDocument{metadata: metadata}
+			if iter.flatten {
+				next.document = chunk.flattenedSample(i)
+			} else {
+				next.document = chunk.structuredSample(i)
+			}
+			if i < len(times) {
+				next.ts = time.Unix(0, times[i])
+			}
 
-		for iter.sample.Next() {
 			select {
-			case iter.pipe <- iter.sample.Document():
+			case iter.pipe <- next:
 				continue
 			case <-ctx.Done():
 				iter.catcher.Add(errors.New("operation aborted"))
 				return
 			}
-
 		}
-		iter.catcher.Add(iter.sample.Err())
-		iter.sample.Close()
 	}
 	iter.catcher.Add(iter.chunks.Err())
 }
@@ -42,7 +42,30 @@ func (c *Chunk) export() (*birch.Document, error) {
 	return doc, nil
 }
 
+// matrixDocument returns the document for the chunk produced by
+// ReadSeries, when reflect is true, or by ReadMatrix.
+func (c *Chunk) matrixDocument(reflect bool) (*birch.Document, error) {
+	if !reflect {
+		return c.export()
+	}
+
+	payload, err := bson.Marshal(c.exportMatrix())
+	if err != nil {
+		return nil, err
+	}
+
+	return birch.ReadDocument(payload)
+}
+
 func (m *Metric) getSeries() interface{} {
+	if m.encoding == encodingUnsigned {
+		out := make([]bson.D, len(m.Values))
+		for idx, p := range m.Values {
+			out[idx] = bson.D{{Key: unsignedIntegerKey, Value: p}}
+		}
+		return out
+	}
+
 	switch m.originalType {
 	case bsontype.Int64, bsontype.Timestamp:
 		out := make([]int64, len(m.Values))
@@ -67,77 +90,103 @@ func (m *Metric) getSeries() interface{} {
 		}
 		return out
 	case bsontype.DateTime:
-		out := make([]time.Time, len(m.Values))
+		return m.Times()
+	case bsontype.Decimal128:
+		out := make([]bson.Decimal128, len(m.Values))
 		for idx, p := range m.Values {
-			out[idx] = timeEpocMs(p)
+			out[idx] = bson.NewDecimal128(floatToDecimal(restoreFloat(p)).GetBytes())
 		}
 		return out
+	case bsontype.Null, bsontype.Undefined:
+		return make([]interface{}, len(m.Values))
 	default:
 		return nil
 	}
 }
 
+// newMatrixIterator starts an iterator that returns a document for
+// each of the chunks produced by the chunk iterator, as ReadMatrix
+// or, when reflect is true, ReadSeries.
+func newMatrixIterator(ctx context.Context, opts ReadOptions, chunks func(context.Context) *ChunkIterator, reflect bool) Iterator {
+	if opts.Synchronous {
+		if reflect {
+			return newPullIterator(chunks(ctx), pullSeries)
+		}
+		return newPullIterator(chunks(ctx), pullMatrix)
+	}
+
+	iterctx, cancel := context.WithCancel(ctx)
+	iter := &matrixIterator{
+		closer:  cancel,
+		chunks:  chunks(iterctx),
+		pipe:    make(chan iteratorDocument, 25),
+		done:    make(chan struct{}),
+		catcher: util.NewCatcher(),
+		reflect: reflect,
+	}
+
+	go iter.worker(iterctx)
+	return iter
+}
+
 type matrixIterator struct {
 	chunks   *ChunkIterator
 	closer   context.CancelFunc
+	done     chan struct{}
 	metadata *birch.Document
 	document *birch.Document
-	pipe     chan *birch.Document
+	ts       time.Time
+	pipe     chan iteratorDocument
 	catcher  util.Catcher
 	reflect  bool
 }
 
 func (iter *matrixIterator) Close() {
+	iter.closer()
 	if iter.chunks != nil {
 		iter.chunks.Close()
 	}
 }
 
+// wait blocks until the worker, and the goroutines reading chunks,
+// have exited, which happens promptly after the iterator is closed.
+func (iter *matrixIterator) wait() {
+	<-iter.done
+	iter.chunks.wait()
+}
+
 func (iter *matrixIterator) Err() error                { return iter.catcher.Resolve() }
 func (iter *matrixIterator) Metadata() *birch.Document { return iter.metadata }
 func (iter *matrixIterator) Document() *birch.Document { return iter.document }
+func (iter *matrixIterator) Timestamp() time.Time      { return iter.ts }
 func (iter *matrixIterator) Next() bool {
 	doc, ok := <-iter.pipe
 	if !ok {
 		return false
 	}
 
-	iter.document = doc
+	iter.document = doc.document
+	iter.metadata = doc.metadata
+	iter.ts = doc.ts
 	return true
 }
 
 func (iter *matrixIterator) worker(ctx context.Context) {
+	defer close(iter.done)
 	defer func() { iter.catcher.Add(iter.chunks.Err()) }()
 	defer close(iter.pipe)
 
-	var payload []byte
-	var doc *birch.Document
-	var err error
-
 	for iter.chunks.Next() {
 		chunk := iter.chunks.Chunk()
 
-		if iter.reflect {
-			payload, err = bson.Marshal(chunk.exportMatrix())
-			if err != nil {
-				iter.catcher.Add(err)
-				return
-			}
-			doc, err = birch.ReadDocument(payload)
-			if err != nil {
-				iter.catcher.Add(err)
-				return
-			}
-		} else {
-			doc, err = chunk.export()
-			if err != nil {
-				iter.catcher.Add(err)
-				return
-			}
+		doc, err := chunk.matrixDocument(iter.reflect)
+		if err != nil {
+			iter.catcher.Add(err)
+			return
 		}
 
 		select {
-		case iter.pipe <- doc:
+		case iter.pipe <- iteratorDocument{document: doc, metadata: chunk.currentMetadata(), ts: chunk.StartTime()}:
 			continue
 		case <-ctx.Done():
 			iter.catcher.Add(errors.New("operation aborted"))
@@ -0,0 +1,110 @@
+package ftdc
+
+import (
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/mongodb/ftdc/util"
+)
+
+// pullMode determines the documents that a pullIterator produces.
+type pullMode int
+
+const (
+	pullFlattened pullMode = iota
+	pullStructured
+	pullMatrix
+	pullSeries
+)
+
+// pullIterator implements Iterator without any goroutines, by
+// decoding documents from a synchronous ChunkIterator when Next is
+// called. It produces the same documents as the iterators returned by
+// ReadMetrics, ReadStructuredMetrics, ReadMatrix, and ReadSeries,
+// depending on the mode.
+type pullIterator struct {
+	chunks   *ChunkIterator
+	mode     pullMode
+	chunk    *Chunk
+	times    []int64
+	position int
+	done     bool
+	metadata *birch.Document
+	document *birch.Document
+	ts       time.Time
+	catcher  util.Catcher
+}
+
+func newPullIterator(chunks *ChunkIterator, mode pullMode) *pullIterator {
+	return &pullIterator{
+		chunks:  chunks,
+		mode:    mode,
+		catcher: util.NewCatcher(),
+	}
+}
+
+func (iter *pullIterator) Close()                    { iter.chunks.Close() }
+func (iter *pullIterator) Err() error                { return iter.catcher.Resolve() }
+func (iter *pullIterator) Metadata() *birch.Document { return iter.metadata }
+func (iter *pullIterator) Document() *birch.Document { return iter.document }
+func (iter *pullIterator) Timestamp() time.Time      { return iter.ts }
+
+// documents returns the number of documents that the iterator
+// produces for the current chunk.
+func (iter *pullIterator) documents() int {
+	switch {
+	case iter.chunk == nil:
+		return 0
+	case iter.mode == pullMatrix || iter.mode == pullSeries:
+		return 1
+	default:
+		return iter.chunk.nPoints
+	}
+}
+
+func (iter *pullIterator) Next() bool {
+	if iter.done {
+		return false
+	}
+
+	for iter.position >= iter.documents() {
+		if !iter.chunks.Next() {
+			iter.done = true
+			iter.catcher.Add(iter.chunks.Err())
+			return false
+		}
+
+		iter.chunk = iter.chunks.Chunk()
+		iter.times = iter.chunk.sampleTimes()
+		iter.metadata = iter.chunk.currentMetadata()
+		iter.position = 0
+	}
+
+	var err error
+	switch iter.mode {
+	case pullFlattened:
+		iter.document = iter.chunk.flattenedSample(iter.position)
+	case pullStructured:
+		iter.document = iter.chunk.structuredSample(iter.position)
+	case pullMatrix, pullSeries:
+		iter.document, err = iter.chunk.matrixDocument(iter.mode == pullSeries)
+	}
+
+	if iter.mode == pullMatrix || iter.mode == pullSeries {
+		iter.ts = iter.chunk.StartTime()
+	} else if iter.position < len(iter.times) {
+		iter.ts = time.Unix(0, iter.times[iter.position])
+	} else {
+		iter.ts = time.Time{}
+	}
+	iter.position++
+
+	if err != nil {
+		iter.done = true
+		iter.catcher.Add(err)
+		iter.chunks.Close()
+		return false
+	}
+
+	return true
+}
@@ -2,6 +2,7 @@ package ftdc
 
 import (
 	"context"
+	"time"
 
 	"github.com/evergreen-ci/birch"
 )
@@ -13,6 +14,31 @@ type sampleIterator struct {
 	stream   <-chan *birch.Document
 	sample   *birch.Document
 	metadata *birch.Document
+	times    []int64
+	position int
+}
+
+// flattenedSample returns the sample at the index as a document with
+// one field for each metric.
+func (c *Chunk) flattenedSample(i int) *birch.Document {
+	doc := birch.DC.Make(len(c.Metrics))
+	for _, m := range c.Metrics {
+		elem, ok := m.flatElement(m.Key(), m.Values[i])
+		if !ok {
+			continue
+		}
+
+		doc.Append(elem)
+	}
+
+	return doc
+}
+
+// structuredSample returns the sample at the index as a document
+// with the structure of the source documents.
+func (c *Chunk) structuredSample(i int) *birch.Document {
+	doc, _ := restoreDocument(c.reference, i, c.Metrics, 0, false)
+	return doc
 }
 
 func (c *Chunk) streamFlattenedDocuments(ctx context.Context) <-chan *birch.Document {
@@ -21,16 +47,7 @@ func (c *Chunk) streamFlattenedDocuments(ctx context.Context) <-chan *birch.Docu
 	go func() {
 		defer close(out)
 		for i := 0; i < c.nPoints; i++ {
-
-			doc := birch.DC.Make(len(c.Metrics))
-			for _, m := range c.Metrics {
-				elem, ok := restoreFlat(m.originalType, m.Key(), m.Values[i])
-				if !ok {
-					continue
-				}
-
-				doc.Append(elem)
-			}
+			doc := c.flattenedSample(i)
 
 			select {
 			case out <- doc:
@@ -51,7 +68,7 @@ func (c *Chunk) streamDocuments(ctx context.Context) <-chan *birch.Document {
 		defer close(out)
 
 		for i := 0; i < c.nPoints; i++ {
-			doc, _ := restoreDocument(c.reference, i, c.Metrics, 0)
+			doc := c.structuredSample(i)
 			select {
 			case <-ctx.Done():
 				return
@@ -68,8 +85,25 @@ func (c *Chunk) streamDocuments(ctx context.Context) <-chan *birch.Document {
 func (iter *sampleIterator) Close()     { iter.closer() }
 func (iter *sampleIterator) Err() error { return nil }
 
+// wait blocks until the goroutine producing documents has exited,
+// which happens promptly after the iterator is closed.
+func (iter *sampleIterator) wait() {
+	for range iter.stream {
+	}
+}
+
 func (iter *sampleIterator) Metadata() *birch.Document { return iter.metadata }
 
+// Timestamp returns the time of the current document, or the zero
+// time if the chunk has no timestamp metric.
+func (iter *sampleIterator) Timestamp() time.Time {
+	if iter.position == 0 || iter.position > len(iter.times) {
+		return time.Time{}
+	}
+
+	return time.Unix(0, iter.times[iter.position-1])
+}
+
 // Document returns the current document in the iterator. It is safe
 // to call this method more than once, and the result will only be nil
 // before the iterator is advanced.
@@ -84,5 +118,6 @@ func (iter *sampleIterator) Next() bool {
 	}
 
 	iter.sample = doc
+	iter.position++
 	return true
 }
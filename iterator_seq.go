@@ -0,0 +1,200 @@
+package ftdc
+
+import (
+	"context"
+	"io"
+	"iter"
+
+	"github.com/evergreen-ci/birch"
+)
+
+// waiter is implemented by the iterators that use background
+// goroutines, to make it possible to wait for the goroutines to exit
+// after closing the iterator.
+type waiter interface {
+	wait()
+}
+
+// Chunks returns a sequence of the chunks in an FTDC data source, for
+// use with range-over-func loops:
+//
+//	for chunk, err := range ftdc.Chunks(ctx, file) {
+//	    if err != nil {
+//	        return err
+//	    }
+//
+//	    // <manipulate chunk>
+//	}
+//
+// The sequence yields an error, with a nil chunk, at most once, as
+// its last element. Exiting the loop early releases all of the
+// resources used to read the source before the loop statement
+// returns. The source is read once, so the sequence should only be
+// used once.
+func Chunks(ctx context.Context, r io.Reader) iter.Seq2[*Chunk, error] {
+	return ChunksWithOptions(ctx, r, ReadOptions{})
+}
+
+// ChunksWithOptions is the same as Chunks, but uses the options to
+// control which data is decoded, as ReadChunksWithOptions. Unless the
+// options use multiple workers, the source is always read
+// synchronously (see ReadOptions.Synchronous), so the sequence doesn't
+// start any goroutines.
+func ChunksWithOptions(ctx context.Context, r io.Reader, opts ReadOptions) iter.Seq2[*Chunk, error] {
+	if opts.Workers < 2 {
+		opts.Synchronous = true
+	}
+
+	return func(yield func(*Chunk, error) bool) {
+		ReadChunksWithOptions(ctx, r, opts).All()(yield)
+	}
+}
+
+// Samples returns a sequence of the samples in an FTDC data source,
+// as flattened documents, like ReadMetrics. The sequence has the same
+// semantics as Chunks.
+func Samples(ctx context.Context, r io.Reader) iter.Seq2[*birch.Document, error] {
+	return SamplesWithOptions(ctx, r, ReadOptions{})
+}
+
+// SamplesWithOptions is the same as Samples, but uses the options to
+// control which data is decoded, as ReadMetricsWithOptions.
+func SamplesWithOptions(ctx context.Context, r io.Reader, opts ReadOptions) iter.Seq2[*birch.Document, error] {
+	return chunkSamples(ctx, r, opts, (*Chunk).Samples)
+}
+
+// StructuredSamples returns a sequence of the samples in an FTDC data
+// source, as documents that retain the structure of the input
+// documents, like ReadStructuredMetrics. The sequence has the same
+// semantics as Chunks.
+func StructuredSamples(ctx context.Context, r io.Reader) iter.Seq2[*birch.Document, error] {
+	return StructuredSamplesWithOptions(ctx, r, ReadOptions{})
+}
+
+// StructuredSamplesWithOptions is the same as StructuredSamples, but
+// uses the options to control which data is decoded, as
+// ReadStructuredMetricsWithOptions.
+func StructuredSamplesWithOptions(ctx context.Context, r io.Reader, opts ReadOptions) iter.Seq2[*birch.Document, error] {
+	return chunkSamples(ctx, r, opts, (*Chunk).StructuredSamples)
+}
+
+func chunkSamples(ctx context.Context, r io.Reader, opts ReadOptions, samples func(*Chunk) iter.Seq[*birch.Document]) iter.Seq2[*birch.Document, error] {
+	return func(yield func(*birch.Document, error) bool) {
+		for chunk, err := range ChunksWithOptions(ctx, r, opts) {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for doc := range samples(chunk) {
+				if !yield(doc, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// All returns a sequence of the chunks remaining in the iterator,
+// with the same semantics as Chunks. The iterator is closed when the
+// loop exits.
+func (iter *ChunkIterator) All() iter.Seq2[*Chunk, error] {
+	return func(yield func(*Chunk, error) bool) {
+		defer iter.wait()
+		defer iter.Close()
+
+		for iter.Next() {
+			if !yield(iter.Chunk(), nil) {
+				return
+			}
+		}
+
+		if err := iter.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// Documents returns a sequence of the documents remaining in the
+// iterator, with the same semantics as Chunks. The iterator is closed
+// when the loop exits, and for the iterators that this package
+// creates, the goroutines that the iterator uses have exited before
+// the loop statement returns.
+func Documents(it Iterator) iter.Seq2[*birch.Document, error] {
+	return func(yield func(*birch.Document, error) bool) {
+		if w, ok := it.(waiter); ok {
+			defer w.wait()
+		}
+		defer it.Close()
+
+		for it.Next() {
+			if !yield(it.Document(), nil) {
+				return
+			}
+		}
+
+		if err := it.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// Samples returns a sequence of the samples in the chunk as flattened
+// documents, as the documents produced by Iterator, without using
+// any background goroutines.
+func (c *Chunk) Samples() iter.Seq[*birch.Document] {
+	return func(yield func(*birch.Document) bool) {
+		for i := 0; i < c.nPoints; i++ {
+			if !yield(c.flattenedSample(i)) {
+				return
+			}
+		}
+	}
+}
+
+// StructuredSamples returns a sequence of the samples in the chunk as
+// documents that retain the structure of the source documents, as the
+// documents produced by StructuredIterator, without using any
+// background goroutines.
+func (c *Chunk) StructuredSamples() iter.Seq[*birch.Document] {
+	return func(yield func(*birch.Document) bool) {
+		for i := 0; i < c.nPoints; i++ {
+			if !yield(c.structuredSample(i)) {
+				return
+			}
+		}
+	}
+}
+
+// Series returns a sequence of the metrics in the chunk, keyed by
+// their fully qualified names (see Metric.Key). Each metric holds the
+// values of the series for every sample in the chunk, and only in
+// this chunk: to get series that span several chunks, combine the
+// chunks with ConcatChunks first, as long as they have the same
+// metrics, which is not the case across schema changes:
+//
+//	var chunks []*ftdc.Chunk
+//	for chunk, err := range ftdc.Chunks(ctx, file) {
+//	    if err != nil {
+//	        return err
+//	    }
+//	    chunks = append(chunks, chunk)
+//	}
+//
+//	combined, err := ftdc.ConcatChunks(chunks...)
+//	if err != nil {
+//	    return err
+//	}
+//
+//	for key, metric := range combined.Series() {
+//	    // <manipulate metric.Values>
+//	}
+func (c *Chunk) Series() iter.Seq2[string, *Metric] {
+	return func(yield func(string, *Metric) bool) {
+		for idx := range c.Metrics {
+			if !yield(c.Metrics[idx].Key(), &c.Metrics[idx]) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package ftdc
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequences(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	payload := produceTimedPayload(t, start, 100, 10)
+
+	t.Run("Chunks", func(t *testing.T) {
+		count := 0
+		for chunk, err := range Chunks(ctx, bytes.NewBuffer(payload)) {
+			require.NoError(t, err)
+			assert.Equal(t, 10, chunk.Size())
+			count++
+		}
+		assert.Equal(t, 10, count)
+	})
+	t.Run("Samples", func(t *testing.T) {
+		count := 0
+		for doc, err := range Samples(ctx, bytes.NewBuffer(payload)) {
+			require.NoError(t, err)
+			assert.EqualValues(t, count, doc.Lookup("counter").Int64())
+			count++
+		}
+		assert.Equal(t, 100, count)
+
+		count = 0
+		for doc, err := range StructuredSamplesWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Include: []string{"gauge"}, Workers: 4}) {
+			require.NoError(t, err)
+			assert.Equal(t, 1, doc.Len())
+			assert.EqualValues(t, count%7, doc.Lookup("gauge").Int32())
+			count++
+		}
+		assert.Equal(t, 100, count)
+	})
+	t.Run("Errors", func(t *testing.T) {
+		corrupt := append([]byte{}, payload...)
+		corrupt = corrupt[:len(corrupt)-10]
+
+		var (
+			count int
+			errs  []error
+		)
+		for doc, err := range Samples(ctx, bytes.NewBuffer(corrupt)) {
+			if err != nil {
+				assert.Nil(t, doc)
+				errs = append(errs, err)
+				continue
+			}
+			count++
+		}
+		assert.Equal(t, 90, count)
+		assert.Len(t, errs, 1)
+
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Workers: -1})
+		for chunk, err := range iter.All() {
+			assert.Nil(t, chunk)
+			assert.Error(t, err)
+		}
+	})
+	t.Run("EarlyExit", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+
+		for _, workers := range []int{0, 4} {
+			for chunk, err := range ChunksWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Workers: workers}) {
+				require.NoError(t, err)
+				require.NotNil(t, chunk)
+				break
+			}
+			assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+
+			for doc, err := range SamplesWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Workers: workers}) {
+				require.NoError(t, err)
+				require.NotNil(t, doc)
+				break
+			}
+			assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+		}
+
+		for name, iter := range map[string]Iterator{
+			"Metrics": ReadMetrics(ctx, bytes.NewBuffer(payload)),
+			"Matrix":  ReadMatrix(ctx, bytes.NewBuffer(payload)),
+			"Series":  ReadSeries(ctx, bytes.NewBuffer(payload)),
+		} {
+			for doc, err := range Documents(iter) {
+				require.NoError(t, err, name)
+				require.NotNil(t, doc, name)
+				break
+			}
+		}
+		assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+
+		iter := ReadChunks(ctx, bytes.NewBuffer(payload))
+		require.True(t, iter.Next())
+		for doc := range Documents(iter.Chunk().Iterator(ctx)) {
+			require.NotNil(t, doc)
+			break
+		}
+		for range iter.All() {
+			break
+		}
+		assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+	})
+	t.Run("Chunk", func(t *testing.T) {
+		iter := ReadChunks(ctx, bytes.NewBuffer(payload))
+		defer iter.Close()
+		require.True(t, iter.Next())
+		chunk := iter.Chunk()
+
+		keys := []string{}
+		for key, metric := range chunk.Series() {
+			keys = append(keys, key)
+			assert.Len(t, metric.Values, chunk.Size())
+		}
+		assert.Equal(t, []string{"ts", "counter", "gauge"}, keys)
+
+		count := 0
+		for doc := range chunk.Samples() {
+			assert.Equal(t, 3, doc.Len())
+			count++
+		}
+		assert.Equal(t, 10, count)
+
+		for doc := range chunk.StructuredSamples() {
+			assert.Equal(t, 3, doc.Len())
+			break
+		}
+	})
+}
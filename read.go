@@ -7,16 +7,29 @@ import (
 	"context"
 	"encoding/binary"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/evergreen-ci/birch"
+	"github.com/evergreen-ci/birch/bsontype"
+	"github.com/mongodb/ftdc/util"
 	"github.com/pkg/errors"
 )
 
-func readDiagnostic(ctx context.Context, f io.Reader, ch chan<- *birch.Document) error {
+// sourceDocument is a document read from an FTDC source, with its
+// position in the source.
+type sourceDocument struct {
+	doc    *birch.Document
+	offset int64
+	size   int64
+}
+
+func readDiagnostic(ctx context.Context, f io.Reader, ch chan<- sourceDocument, opts ReadOptions, report func(*ChunkError)) error {
 	defer close(ch)
-	buf := bufio.NewReader(f)
+
+	next := newDocumentReader(f, opts, report)
 	for {
-		doc, err := readBufBSON(buf)
+		doc, err := next()
 		if err != nil {
 			if err == io.EOF {
 				err = nil
@@ -32,20 +45,355 @@ func readDiagnostic(ctx context.Context, f io.Reader, ch chan<- *birch.Document)
 	}
 }
 
-func readChunks(ctx context.Context, ch <-chan *birch.Document, o chan<- *Chunk) error {
+// newDocumentReader returns a function that reads the next document
+// from the source each time it's called, and returns io.EOF when
+// there are no more documents.
+func newDocumentReader(f io.Reader, opts ReadOptions, report func(*ChunkError)) func() (sourceDocument, error) {
+	if opts.SkipCorrupt {
+		return newLenientReader(f, report).next
+	}
+
+	buf := bufio.NewReader(f)
+	var offset int64
+	return func() (sourceDocument, error) {
+		doc, size, err := readBSON(buf)
+		if err != nil {
+			return sourceDocument{}, err
+		}
+
+		out := sourceDocument{doc: doc, offset: offset, size: size}
+		offset += size
+		return out, nil
+	}
+}
+
+// channelReader returns a function that returns the documents sent
+// on the channel, and io.EOF once the channel is closed.
+func channelReader(ch <-chan sourceDocument) func() (sourceDocument, error) {
+	return func() (sourceDocument, error) {
+		doc, ok := <-ch
+		if !ok {
+			return sourceDocument{}, io.EOF
+		}
+		return doc, nil
+	}
+}
+
+// chunkPayload holds a metrics chunk document that has been read
+// from the source, but not yet decompressed or decoded.
+type chunkPayload struct {
+	id          time.Time
+	data        *birch.Element
+	compression Compression
+	metadata    *birch.Document
+	periodic    *PeriodicMetadata
+	updates     []*PeriodicMetadata
+	offset      int64
+	size        int64
+}
+
+// corrupt describes the chunk as having been skipped because of the
+// error.
+func (p *chunkPayload) corrupt(err error) *ChunkError {
+	return &ChunkError{
+		Offset: p.offset,
+		Size:   p.size,
+		ID:     p.id,
+		Reason: err,
+	}
+}
+
+func readChunks(ctx context.Context, ch <-chan sourceDocument, o chan<- *Chunk, opts ReadOptions, report func(*ChunkError)) error {
 	defer close(o)
 
-	var metadata *birch.Document
+	if opts.Workers > 1 {
+		return readChunksParallel(ctx, ch, o, opts, report)
+	}
+
+	decoder := newChunkDecoder(channelReader(ch), opts, report)
+	defer decoder.close()
+
+	for {
+		chunk, err := decoder.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if !sendChunk(ctx, o, chunk) {
+			return nil
+		}
+	}
+}
+
+// chunkDecoder reads and decodes chunks on demand, in the calling
+// goroutine, reusing its decompression buffers for every chunk.
+type chunkDecoder struct {
+	scanner *chunkScanner
+	trimmer *chunkTrimmer
+	opts    ReadOptions
+	report  func(*ChunkError)
+	buffers decodeBuffers
+}
+
+func newChunkDecoder(next func() (sourceDocument, error), opts ReadOptions, report func(*ChunkError)) *chunkDecoder {
+	return &chunkDecoder{
+		scanner: newChunkScanner(next, opts, report),
+		trimmer: &chunkTrimmer{opts: opts},
+		opts:    opts,
+		report:  report,
+	}
+}
+
+// next returns the next chunk in the source, or io.EOF when there are
+// no more chunks.
+func (d *chunkDecoder) next() (*Chunk, error) {
+	for {
+		payload, err := d.scanner.scan()
+		if err != nil {
+			return nil, err
+		}
+
+		chunk, err := payload.decode(d.opts, &d.buffers)
+		if err != nil {
+			if d.opts.SkipCorrupt {
+				d.report(payload.corrupt(err))
+				d.trimmer.skip(payload.updates)
+				continue
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		if chunk = d.trimmer.trim(chunk); chunk != nil {
+			return chunk, nil
+		}
+	}
+}
+
+// close releases the decoder's decompression buffers.
+func (d *chunkDecoder) close() { d.buffers.release() }
+
+// decodeJob tracks a chunk that is decoded by a worker. Jobs are
+// queued in file order, and workers close the done channel when the
+// chunk is decoded, so that results can be collected in order
+// without blocking the workers.
+type decodeJob struct {
+	payload *chunkPayload
+	chunk   *Chunk
+	err     error
+	done    chan struct{}
+}
+
+// readChunksParallel decodes chunks using a pool of workers,
+// while preserving the order of the chunks in the source: chunks are
+// dispatched in file order, and the results are emitted in the same
+// order, with at most opts.Workers chunks decoded ahead of the
+// consumer.
+func readChunksParallel(ctx context.Context, ch <-chan sourceDocument, o chan<- *Chunk, opts ReadOptions, report func(*ChunkError)) error {
+	// wait for the workers and the scanner to exit after they're
+	// canceled, so that no goroutines outlive the iterator.
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan *decodeJob)
+	ordered := make(chan *decodeJob, opts.Workers)
+	catcher := util.NewCatcher()
+	trimmer := &chunkTrimmer{opts: opts}
+
+	wg.Add(opts.Workers + 1)
+	for i := 0; i < opts.Workers; i++ {
+		go func() {
+			defer wg.Done()
+			buffers := &decodeBuffers{}
+			defer buffers.release()
+
+			for job := range jobs {
+				job.chunk, job.err = job.payload.decode(opts, buffers)
+				close(job.done)
+			}
+		}()
+	}
+
+	go func() {
+		defer wg.Done()
+		defer close(ordered)
+		defer close(jobs)
+
+		catcher.Add(scanChunks(ch, opts, report, func(payload *chunkPayload) (bool, error) {
+			job := &decodeJob{payload: payload, done: make(chan struct{})}
+			select {
+			case ordered <- job:
+			case <-ctx.Done():
+				return false, nil
+			}
+
+			select {
+			case jobs <- job:
+				return true, nil
+			case <-ctx.Done():
+				return false, nil
+			}
+		}))
+	}()
+
+	for job := range ordered {
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			return nil
+		}
+
+		if job.err != nil {
+			if opts.SkipCorrupt {
+				report(job.payload.corrupt(job.err))
+				trimmer.skip(job.payload.updates)
+				continue
+			}
+			return errors.WithStack(job.err)
+		}
+
+		chunk := trimmer.trim(job.chunk)
+		if chunk == nil {
+			continue
+		}
+
+		if !sendChunk(ctx, o, chunk) {
+			return nil
+		}
+	}
+
+	return catcher.Resolve()
+}
+
+// sendChunk sends the chunk to the output channel, returning false
+// if the context was canceled first.
+func sendChunk(ctx context.Context, o chan<- *Chunk, chunk *Chunk) bool {
+	select {
+	case o <- chunk:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// chunkTrimmer trims chunks to the time range in the options. The
+// periodic metadata updates recorded with chunks that are dropped
+// entirely are carried forward to the next chunk that is returned, so
+// that consumers see every update.
+type chunkTrimmer struct {
+	opts    ReadOptions
+	carried []*PeriodicMetadata
+}
+
+// trim returns the chunk, trimmed to the time range, or nil if none of
+// the samples in the chunk are in the range.
+func (t *chunkTrimmer) trim(chunk *Chunk) *Chunk {
+	if t.opts.hasTimeRange() {
+		trimmed := chunk.trim(t.opts)
+		if trimmed == nil {
+			t.skip(chunk.updates)
+			return nil
+		}
+		chunk = trimmed
+	}
+
+	if len(t.carried) > 0 {
+		chunk.updates = append(t.carried, chunk.updates...)
+		t.carried = nil
+	}
+
+	return chunk
+}
+
+func (t *chunkTrimmer) skip(updates []*PeriodicMetadata) {
+	t.carried = append(t.carried, updates...)
+}
+
+// scanChunks reads documents from the channel, as a chunkScanner,
+// and passes the chunks, in order, to the handler. Scanning stops
+// when the handler returns false or an error.
+func scanChunks(ch <-chan sourceDocument, opts ReadOptions, report func(*ChunkError), handler func(*chunkPayload) (bool, error)) error {
+	scanner := newChunkScanner(channelReader(ch), opts, report)
+	for {
+		payload, err := scanner.scan()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if ok, err := handler(payload); !ok {
+			return err
+		}
+	}
+}
+
+// chunkScanner reads documents from a source, tracking the metadata
+// and skipping documents that aren't metrics chunks, as well as
+// chunks that are outside of the time range in the options, and
+// returns the remaining chunks, in order, without decoding them.
+type chunkScanner struct {
+	next     func() (sourceDocument, error)
+	opts     ReadOptions
+	report   func(*ChunkError)
+	metadata *birch.Document
+	periodic *PeriodicMetadata
+	updates  []*PeriodicMetadata
+	pending  *chunkPayload
+	// done is true once the scanner has reached the end of the
+	// source, or a chunk that begins after the end of the range.
+	done bool
+}
+
+func newChunkScanner(next func() (sourceDocument, error), opts ReadOptions, report func(*ChunkError)) *chunkScanner {
+	return &chunkScanner{next: next, opts: opts, report: report}
+}
+
+// scan returns the next chunk, or io.EOF when there are no more
+// chunks in the source.
+func (s *chunkScanner) scan() (*chunkPayload, error) {
+	for !s.done {
+		source, err := s.next()
+		if err == io.EOF {
+			s.done = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		doc := source.doc
 
-	for doc := range ch {
 		// the FTDC streams typically have onetime-per-file
 		// metadata that includes information that doesn't
 		// change (like process parameters, and machine
-		// info. This implementation entirely ignores that.)
+		// info.) Newer streams also have periodic metadata,
+		// which records changes to the metadata, and is
+		// tracked so that each chunk has the metadata that
+		// was in effect when it was collected.
 		docType := doc.Lookup("type")
 
 		if isNum(0, docType) {
-			metadata = doc
+			s.metadata = doc
+			continue
+		} else if isNum(2, docType) {
+			update, err := readPeriodicMetadata(doc, s.periodic)
+			if err != nil {
+				if s.opts.SkipCorrupt {
+					id, _ := doc.Lookup("_id").TimeOK()
+					s.report(&ChunkError{Offset: source.offset, Size: source.size, ID: id, Reason: err})
+					continue
+				}
+				return nil, errors.WithStack(err)
+			}
+			s.periodic = update
+			s.updates = append(s.updates, update)
 			continue
 		} else if !isNum(1, docType) {
 			continue
@@ -53,100 +401,339 @@ func readChunks(ctx context.Context, ch <-chan *birch.Document, o chan<- *Chunk)
 
 		id, _ := doc.Lookup("_id").TimeOK()
 
+		// chunks are written in time order, so once a chunk
+		// begins after the end of the range, so do all of the
+		// chunks that follow it, and the rest of the source
+		// doesn't need to be read.
+		if s.opts.startsAfterRange(id) {
+			s.done = true
+			break
+		}
+
+		payload := &chunkPayload{
+			id:       id,
+			metadata: s.metadata,
+			periodic: s.periodic,
+			updates:  s.updates,
+			offset:   source.offset,
+			size:     source.size,
+		}
+		s.updates = nil
+
 		// get the data field which holds the metrics chunk
-		zelem := doc.LookupElement("data")
-		if zelem == nil {
-			return errors.New("data is not populated")
+		payload.data = doc.LookupElement("data")
+		if payload.data == nil || payload.data.Value().Type() != bsontype.Binary {
+			err := errors.New("data is not populated")
+			if s.opts.SkipCorrupt {
+				s.report(payload.corrupt(err))
+				s.updates = payload.updates
+				continue
+			}
+			return nil, err
 		}
-		_, zBytes := zelem.Value().Binary()
 
-		// the metrics chunk, after the first 4 bytes, is zlib
-		// compressed, so we make a reader for that. data
-		z, err := zlib.NewReader(bytes.NewBuffer(zBytes[4:]))
-		if err != nil {
-			return errors.Wrap(err, "problem building zlib reader")
+		if value, ok := doc.Lookup(chunkCompressionField).StringValueOK(); ok {
+			payload.compression = Compression(value)
+		}
+
+		if s.opts.Start.IsZero() {
+			return payload, nil
 		}
-		buf := bufio.NewReader(z)
 
-		// the metrics chunk, which is *not* bson, first
-		// contains a bson document which begins the
-		// sample. This has the field and we use use it to
-		// create a slice of Metrics for each series. The
-		// deltas are not populated.
-		refDoc, metrics, err := readBufMetrics(buf)
+		// when there's a start time, hold each chunk until
+		// the next one arrives: if the next chunk starts
+		// before the range, then all of the samples in the
+		// pending chunk do too, and it can be skipped
+		// without decompressing it.
+		pending := s.pending
+		s.pending = payload
+		if pending == nil {
+			continue
+		}
+
+		if !s.opts.startsBeforeRange(id) {
+			return pending, nil
+		}
+
+		// the metadata updates from skipped chunks belong to
+		// the next chunk.
+		payload.updates = append(pending.updates, payload.updates...)
+	}
+
+	if s.pending != nil {
+		payload := s.pending
+		s.pending = nil
+		return payload, nil
+	}
+
+	return nil, io.EOF
+}
+
+// decodeBuffers holds the readers used to decompress chunks, so that
+// they can be reused for every chunk that a decoder reads.
+type decodeBuffers struct {
+	data  *bytes.Reader
+	z     io.ReadCloser
+	buf   *bufio.Reader
+	plain []byte
+}
+
+// reader returns a reader for the decompressed contents of the chunk
+// data, using the codec that compressed the data. The size is the
+// uncompressed size that the chunk declares.
+func (b *decodeBuffers) reader(data []byte, size uint32, compression Compression) (*bufio.Reader, error) {
+	if err := compression.Validate(); err != nil {
+		return nil, err
+	}
+
+	if size > maxChunkDataSize {
+		return nil, errors.Errorf("declared size %d exceeds the limit of %d bytes", size, maxChunkDataSize)
+	}
+
+	if b.data == nil {
+		b.data = bytes.NewReader(data)
+	} else {
+		b.data.Reset(data)
+	}
+
+	if compression == CompressionZstd {
+		plain, err := zstdDecoder().DecodeAll(data, b.plain[:0])
 		if err != nil {
-			return errors.Wrap(err, "problem reading metrics")
+			return nil, err
 		}
+		if len(plain) != int(size) {
+			return nil, errors.Errorf("decompressed %d bytes, but the chunk declares %d", len(plain), size)
+		}
+		b.plain = plain
+		b.data.Reset(plain)
 
-		// now go back and read the first few bytes
-		// (uncompressed) which tell us how many metrics are
-		// in each sample (e.g. the fields in the document)
-		// and how many events are collected in each series.
-		bl := make([]byte, 8)
-		_, err = io.ReadAtLeast(buf, bl, 8)
+		return b.reset(b.data), nil
+	}
+
+	if b.z == nil {
+		z, err := zlib.NewReader(b.data)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		nmetrics := int(binary.LittleEndian.Uint32(bl[:4]))
-		ndeltas := int(binary.LittleEndian.Uint32(bl[4:]))
-
-		// if the number of metrics that we see from the
-		// source document (metrics) and the number the file
-		// reports don't equal, it's probably corrupt.
-		if nmetrics != len(metrics) {
-			return errors.Errorf("metrics mismatch, file likely corrupt Expected %d, got %d", nmetrics, len(metrics))
-		}
-
-		// now go back and populate the delta numbers
-		var nzeroes uint64
-		for i, v := range metrics {
-			metrics[i].startingValue = v.startingValue
-			metrics[i].Values = make([]int64, ndeltas)
-
-			for j := 0; j < ndeltas; j++ {
-				var delta uint64
-				if nzeroes != 0 {
-					delta = 0
-					nzeroes--
-				} else {
-					delta, err = binary.ReadUvarint(buf)
-					if err != nil {
-						return errors.Wrap(err, "reached unexpected end of encoded integer")
-					}
-					if delta == 0 {
-						nzeroes, err = binary.ReadUvarint(buf)
-						if err != nil {
-							return err
-						}
-					}
-				}
-				metrics[i].Values[j] = int64(delta)
+		b.z = z
+	} else if err := b.z.(zlib.Resetter).Reset(b.data, nil); err != nil {
+		return nil, err
+	}
+
+	return b.reset(b.z), nil
+}
+
+// release closes the decompressor and drops the buffers, so that they
+// can be collected once the decoder is no longer used; the buffers are
+// reallocated if the decoder is used again.
+func (b *decodeBuffers) release() {
+	if b.z != nil {
+		_ = b.z.Close()
+	}
+
+	*b = decodeBuffers{}
+}
+
+func (b *decodeBuffers) reset(r io.Reader) *bufio.Reader {
+	if b.buf == nil {
+		b.buf = bufio.NewReader(r)
+	} else {
+		b.buf.Reset(r)
+	}
+
+	return b.buf
+}
+
+func (p *chunkPayload) decode(opts ReadOptions, buffers *decodeBuffers) (*Chunk, error) {
+	_, zBytes := p.data.Value().Binary()
+	if len(zBytes) < 4 {
+		return nil, errors.New("data is not populated")
+	}
+
+	// the metrics chunk, after the first 4 bytes, is compressed,
+	// usually with zlib, so we make a reader for that.
+	buf, err := buffers.reader(zBytes[4:], binary.LittleEndian.Uint32(zBytes[:4]), p.compression)
+	if err != nil {
+		return nil, errors.Wrap(err, "problem building decompression reader")
+	}
+
+	// the metrics chunk, which is *not* bson, first
+	// contains a bson document which begins the
+	// sample. This has the field and we use use it to
+	// create a slice of Metrics for each series. The
+	// deltas are not populated.
+	refDoc, metrics, err := readBufMetrics(buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "problem reading metrics")
+	}
+
+	// now go back and read the first few bytes
+	// (uncompressed) which tell us how many metrics are
+	// in each sample (e.g. the fields in the document)
+	// and how many events are collected in each series.
+	bl := make([]byte, 8)
+	_, err = io.ReadAtLeast(buf, bl, 8)
+	if err != nil {
+		return nil, err
+	}
+	nmetrics := int(binary.LittleEndian.Uint32(bl[:4]))
+	ndeltas := int(binary.LittleEndian.Uint32(bl[4:]))
+
+	// chunks recorded without the DecimalAndNullValues option,
+	// like mongod's, have no metrics for the decimal, null, and
+	// undefined values in the reference document.
+	recorded := metrics
+	if nmetrics != len(metrics) {
+		metrics = withoutDecimalAndNullMetrics(recorded)
+	}
+
+	// if the number of metrics that we see from the
+	// source document (metrics) and the number the file
+	// reports don't equal, it's probably corrupt.
+	if nmetrics != len(metrics) {
+		return nil, errors.Errorf("metrics mismatch, file likely corrupt Expected %d, got %d", nmetrics, len(metrics))
+	}
+
+	// when projecting, prune the reference document and
+	// determine which metrics to keep. The timestamp metric is
+	// always decoded, so that samples can be trimmed and timed
+	// even when it isn't selected.
+	tsIdx := timestampMetric(metrics, opts.TimestampKey)
+	keep := make([]bool, len(metrics))
+	if opts.hasProjection() {
+		refDoc, keep = projectDocument([]string{}, refDoc, opts.selected, keep[:0])
+		if len(recorded) != len(metrics) {
+			keep = withoutDecimalAndNullValues(recorded, keep)
+		}
+	} else {
+		for i := range keep {
+			keep[i] = true
+		}
+	}
+
+	// now go back and populate the delta numbers
+	var (
+		nzeroes    uint64
+		timestamps []int64
+		selected   = make([]Metric, 0, len(metrics))
+	)
+	for i := range metrics {
+		if !keep[i] && i != tsIdx {
+			if err = skipDeltas(buf, ndeltas, &nzeroes); err != nil {
+				return nil, errors.WithStack(err)
 			}
-			metrics[i].Values = undelta(v.startingValue, metrics[i].Values)
+			continue
 		}
-		select {
-		case o <- &Chunk{
-			Metrics:   metrics,
-			nPoints:   ndeltas + 1, // this accounts for the reference document
-			id:        id,
-			metadata:  metadata,
-			reference: refDoc,
-		}:
-		case <-ctx.Done():
-			return nil
+
+		metrics[i].Values = make([]int64, ndeltas)
+		if err = readDeltas(buf, metrics[i].Values, &nzeroes); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		metrics[i].Values = undelta(metrics[i].startingValue, metrics[i].Values)
+
+		if i == tsIdx {
+			timestamps = metrics[i].Nanoseconds()
+		}
+		if keep[i] {
+			selected = append(selected, metrics[i])
+		}
+	}
+
+	return &Chunk{
+		Metrics:    selected,
+		nPoints:    ndeltas + 1, // this accounts for the reference document
+		id:         p.id,
+		metadata:   p.metadata,
+		reference:  refDoc,
+		timestamps: timestamps,
+		periodic:   p.periodic,
+		updates:    p.updates,
+	}, nil
+}
+
+// readDeltas reads len(out) delta-encoded values from the payload
+// into out, expanding runs of zeroes. The nzeroes value tracks the
+// remainder of a run of zeroes, which may continue into the next
+// metric.
+func readDeltas(buf *bufio.Reader, out []int64, nzeroes *uint64) error {
+	for j := range out {
+		delta, err := readDelta(buf, nzeroes)
+		if err != nil {
+			return err
 		}
+		out[j] = int64(delta)
 	}
+
 	return nil
 }
 
+// skipDeltas advances the payload past the values for one metric
+// without storing them.
+func skipDeltas(buf *bufio.Reader, ndeltas int, nzeroes *uint64) error {
+	for j := 0; j < ndeltas; j++ {
+		if _, err := readDelta(buf, nzeroes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readDelta(buf *bufio.Reader, nzeroes *uint64) (uint64, error) {
+	if *nzeroes != 0 {
+		*nzeroes--
+		return 0, nil
+	}
+
+	delta, err := binary.ReadUvarint(buf)
+	if err != nil {
+		return 0, errors.Wrap(err, "reached unexpected end of encoded integer")
+	}
+	if delta == 0 {
+		*nzeroes, err = binary.ReadUvarint(buf)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return delta, nil
+}
+
 func readBufBSON(buf *bufio.Reader) (*birch.Document, error) {
-	doc := &birch.Document{}
+	doc, _, err := readBSON(buf)
+	return doc, err
+}
 
-	if _, err := doc.ReadFrom(buf); err != nil {
-		return nil, err
+// readBSON reads a single BSON document from the reader, returning
+// the document and its size, and validates the size of the document
+// before reading it. Returns io.EOF only if there are no more
+// documents in the reader.
+func readBSON(r io.Reader) (*birch.Document, int64, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+
+	size := int(int32(binary.LittleEndian.Uint32(header)))
+	if size < minDocumentSize || size > maxDocumentSize {
+		return nil, 0, errors.Errorf("invalid document size %d", size)
+	}
+
+	data := make([]byte, size)
+	copy(data, header)
+	if _, err := io.ReadFull(r, data[4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, errors.Wrap(err, "problem reading document")
+	}
+
+	doc, err := birch.ReadDocument(data)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "problem parsing document")
 	}
 
-	return doc, nil
+	return doc, int64(size), nil
 }
 
 func readBufMetrics(buf *bufio.Reader) (*birch.Document, []Metric, error) {
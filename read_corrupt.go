@@ -0,0 +1,287 @@
+package ftdc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/pkg/errors"
+)
+
+const (
+	// minDocumentSize is the size of an empty BSON document.
+	minDocumentSize = 5
+	// maxDocumentSize is the largest document that the server
+	// will write, which is the maximum user document size plus
+	// space for internal overhead.
+	maxDocumentSize = 16*1024*1024 + 16*1024
+	// lenientBufferSize is the initial size of the lenient
+	// reader's buffer, which grows to hold the largest document
+	// that it reads.
+	lenientBufferSize = 64 * 1024
+)
+
+// diagnosticHeader is the beginning of every FTDC document: the size,
+// and the "_id" date-time element, followed by the "type" element,
+// which is checked separately.
+var diagnosticHeader = []byte{0x09, '_', 'i', 'd', 0x00}
+
+// diagnosticTypeKey is the "type" int32 element that follows the "_id"
+// in FTDC documents.
+var diagnosticTypeKey = []byte{0x10, 't', 'y', 'p', 'e', 0x00}
+
+// diagnosticHeaderSize is the size of the header checked by
+// plausibleHeader: the document size, the "_id" element, and the
+// "type" element.
+var diagnosticHeaderSize = 4 + len(diagnosticHeader) + 8 + len(diagnosticTypeKey) + 4
+
+// ChunkError describes a chunk, or a region of an FTDC source, that
+// could not be read or decoded, and was skipped when reading with the
+// SkipCorrupt option.
+type ChunkError struct {
+	// File is the name of the file that contains the chunk, when
+	// reading a directory with ReadDirectory, and is empty
+	// otherwise.
+	File string
+	// Offset is the position, in bytes from the beginning of the
+	// source, of the chunk or unreadable region.
+	Offset int64
+	// Size is the number of bytes skipped.
+	Size int64
+	// ID is the "_id" of the chunk, which is the zero time when
+	// the chunk document itself could not be read.
+	ID time.Time
+	// Reason is the error encountered reading the chunk.
+	Reason error
+}
+
+func (e *ChunkError) Error() string {
+	location := fmt.Sprintf("offset %d", e.Offset)
+	if e.File != "" {
+		location = fmt.Sprintf("%s in '%s'", location, e.File)
+	}
+
+	if e.ID.IsZero() {
+		return fmt.Sprintf("skipped %d corrupt bytes at %s: %s", e.Size, location, e.Reason)
+	}
+
+	return fmt.Sprintf("skipped corrupt chunk '%s' (%d bytes) at %s: %s",
+		e.ID.Format(time.RFC3339), e.Size, location, e.Reason)
+}
+
+// Unwrap returns the underlying error.
+func (e *ChunkError) Unwrap() error { return e.Reason }
+
+// lenientReader reads BSON documents from the source, as
+// readDiagnostic does, but rather than returning an error when the
+// source contains a truncated or malformed document, it reports the
+// damaged region and resumes at the next offset where a valid chunk
+// document begins.
+//
+// The reader buffers the source itself, rather than with a
+// bufio.Reader, so that the buffer only grows to the size of the
+// largest document, rather than to the largest document that the
+// size prefix could describe.
+type lenientReader struct {
+	src     io.Reader
+	buf     []byte
+	pos     int
+	err     error
+	report  func(*ChunkError)
+	offset  int64
+	corrupt *ChunkError
+}
+
+func newLenientReader(f io.Reader, report func(*ChunkError)) *lenientReader {
+	return &lenientReader{
+		src:    f,
+		buf:    make([]byte, 0, lenientBufferSize),
+		report: report,
+	}
+}
+
+// peek returns the next n bytes without advancing the reader, reading
+// from the source as needed. When the source ends first, it returns
+// fewer bytes, and the error from the source.
+func (r *lenientReader) peek(n int) ([]byte, error) {
+	for len(r.buf)-r.pos < n && r.err == nil {
+		if r.pos > 0 {
+			r.buf = r.buf[:copy(r.buf, r.buf[r.pos:])]
+			r.pos = 0
+		}
+
+		// grow the buffer as the source fills it, rather than
+		// to n at once, so that a damaged size prefix doesn't
+		// allocate more than the source holds.
+		if len(r.buf) == cap(r.buf) {
+			size := 2 * cap(r.buf)
+			if size > n {
+				size = n
+			}
+			r.buf = append(make([]byte, 0, size), r.buf...)
+		}
+
+		read, err := r.src.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+read]
+		r.err = err
+	}
+
+	if avail := r.buf[r.pos:]; len(avail) < n {
+		return avail, r.err
+	}
+
+	return r.buf[r.pos : r.pos+n], nil
+}
+
+// discard advances the reader past n bytes that have been peeked.
+func (r *lenientReader) discard(n int) {
+	r.pos += n
+	r.offset += int64(n)
+}
+
+// skip advances by a single byte, to search for the beginning of the
+// next valid document, and tracks the start of the damaged region so
+// that it's only reported once.
+func (r *lenientReader) skip(reason error) error {
+	if r.corrupt == nil {
+		r.corrupt = &ChunkError{Offset: r.offset, Reason: reason}
+	}
+
+	if _, err := r.peek(1); err != nil {
+		return err
+	}
+	r.discard(1)
+	return nil
+}
+
+func (r *lenientReader) flush() {
+	if r.corrupt == nil {
+		return
+	}
+
+	r.corrupt.Size = r.offset - r.corrupt.Offset
+	r.report(r.corrupt)
+	r.corrupt = nil
+}
+
+// next returns the next valid document in the source, or io.EOF when
+// there are no more documents.
+func (r *lenientReader) next() (sourceDocument, error) {
+	for {
+		header, err := r.peek(4)
+		if len(header) < 4 {
+			if err != io.EOF {
+				return sourceDocument{}, errors.WithStack(err)
+			}
+
+			if len(header) > 0 {
+				if r.corrupt == nil {
+					r.corrupt = &ChunkError{Offset: r.offset, Reason: errors.New("truncated document")}
+				}
+				r.discard(len(header))
+			}
+
+			r.flush()
+			return sourceDocument{}, io.EOF
+		}
+
+		size := int(int32(binary.LittleEndian.Uint32(header)))
+		if size < minDocumentSize || size > maxDocumentSize {
+			if err = r.skip(errors.Errorf("invalid document size %d", size)); err != nil {
+				return sourceDocument{}, errors.WithStack(err)
+			}
+			continue
+		}
+
+		// when resynchronizing, check that the bytes begin
+		// like an FTDC document before reading the whole
+		// document, so that each byte of a damaged region
+		// doesn't read as much as the size prefix claims.
+		if r.corrupt != nil && !r.plausibleHeader(size) {
+			if err = r.skip(errors.New("malformed document")); err != nil {
+				return sourceDocument{}, errors.WithStack(err)
+			}
+			continue
+		}
+
+		data, err := r.peek(size)
+		if len(data) < size {
+			if err != io.EOF {
+				return sourceDocument{}, errors.WithStack(err)
+			}
+
+			if err = r.skip(errors.Errorf("truncated document, read %d of %d bytes", len(data), size)); err != nil {
+				return sourceDocument{}, errors.WithStack(err)
+			}
+			continue
+		}
+
+		// the buffer is reused by later reads, so the
+		// document must have its own copy of the data.
+		doc, err := birch.ReadDocument(append(make([]byte, 0, size), data...))
+		if err != nil {
+			if err = r.skip(errors.Wrap(err, "malformed document")); err != nil {
+				return sourceDocument{}, errors.WithStack(err)
+			}
+			continue
+		}
+
+		// when resynchronizing, require that the document
+		// look like an FTDC document, to avoid treating
+		// arbitrary bytes that happen to be a valid document
+		// (e.g. runs of zeros) as data.
+		if r.corrupt != nil && !isDiagnosticDocument(doc) {
+			if err = r.skip(errors.New("malformed document")); err != nil {
+				return sourceDocument{}, errors.WithStack(err)
+			}
+			continue
+		}
+
+		r.flush()
+
+		out := sourceDocument{doc: doc, offset: r.offset, size: int64(size)}
+		r.discard(size)
+		return out, nil
+	}
+}
+
+// plausibleHeader reports whether the document at the current offset
+// begins with the "_id" and "type" elements of an FTDC document, with
+// a type of 0, 1, or 2.
+func (r *lenientReader) plausibleHeader(size int) bool {
+	if size < diagnosticHeaderSize {
+		return false
+	}
+
+	header, _ := r.peek(diagnosticHeaderSize)
+	if len(header) < diagnosticHeaderSize {
+		// the document is truncated, which reading the whole
+		// document reports.
+		return true
+	}
+
+	header = header[4:]
+	if !bytes.HasPrefix(header, diagnosticHeader) {
+		return false
+	}
+
+	header = header[len(diagnosticHeader)+8:]
+	if !bytes.HasPrefix(header, diagnosticTypeKey) {
+		return false
+	}
+
+	docType := int32(binary.LittleEndian.Uint32(header[len(diagnosticTypeKey):]))
+	return docType >= 0 && docType <= 2
+}
+
+func isDiagnosticDocument(doc *birch.Document) bool {
+	if _, ok := doc.Lookup("_id").TimeOK(); !ok {
+		return false
+	}
+
+	docType := doc.Lookup("type")
+	return isNum(0, docType) || isNum(1, docType) || isNum(2, docType)
+}
@@ -0,0 +1,416 @@
+package ftdc
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mongodb/ftdc/util"
+	"github.com/pkg/errors"
+)
+
+const (
+	// diagnosticFilePrefix is the prefix of the names of the FTDC
+	// files that mongod writes in its diagnostic.data directory.
+	diagnosticFilePrefix = "metrics."
+	// diagnosticInterimFile is the name of the file that holds the
+	// samples that mongod has not yet written to the current
+	// metrics file.
+	diagnosticInterimFile = "metrics.interim"
+	// diagnosticFileTimeFormat is the format of the timestamp in
+	// the names of mongod's metrics files, which are named
+	// metrics.<timestamp>-<sequence>, as in
+	// "metrics.2019-08-14T15-37-35Z-00000".
+	diagnosticFileTimeFormat = "2006-01-02T15-04-05Z"
+)
+
+// diagnosticFile describes a file in a diagnostic.data directory.
+type diagnosticFile struct {
+	name     string
+	started  time.Time
+	sequence int
+	interim  bool
+}
+
+// parseDiagnosticFileName returns a description of the file if the
+// name matches mongod's naming scheme for FTDC files.
+func parseDiagnosticFileName(name string) (diagnosticFile, bool) {
+	if name == diagnosticInterimFile {
+		return diagnosticFile{name: name, interim: true}, true
+	}
+
+	if !strings.HasPrefix(name, diagnosticFilePrefix) {
+		return diagnosticFile{}, false
+	}
+
+	suffix := strings.TrimPrefix(name, diagnosticFilePrefix)
+	if len(suffix) < len(diagnosticFileTimeFormat) {
+		return diagnosticFile{}, false
+	}
+
+	started, err := time.Parse(diagnosticFileTimeFormat, suffix[:len(diagnosticFileTimeFormat)])
+	if err != nil {
+		return diagnosticFile{}, false
+	}
+
+	out := diagnosticFile{name: name, started: started}
+
+	suffix = suffix[len(diagnosticFileTimeFormat):]
+	if suffix == "" {
+		return out, true
+	}
+
+	if suffix[0] != '-' {
+		return diagnosticFile{}, false
+	}
+
+	out.sequence, err = strconv.Atoi(suffix[1:])
+	if err != nil {
+		return diagnosticFile{}, false
+	}
+
+	return out, true
+}
+
+// sortDiagnosticFiles orders the files by the time and sequence
+// number in their names, with the interim file last, because it holds
+// the most recent samples.
+func sortDiagnosticFiles(files []diagnosticFile) {
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].interim != files[j].interim {
+			return files[j].interim
+		}
+
+		if !files[i].started.Equal(files[j].started) {
+			return files[i].started.Before(files[j].started)
+		}
+
+		return files[i].sequence < files[j].sequence
+	})
+}
+
+// listDiagnosticFiles returns the FTDC files in the directory, in the
+// order that their data was written. Files whose names do not follow
+// mongod's naming scheme are ignored.
+func listDiagnosticFiles(fsys fs.FS, dir string) ([]diagnosticFile, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem listing directory '%s'", dir)
+	}
+
+	files := []diagnosticFile{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		if file, ok := parseDiagnosticFileName(entry.Name()); ok {
+			files = append(files, file)
+		}
+	}
+
+	sortDiagnosticFiles(files)
+
+	return files, nil
+}
+
+// ReadDirectory creates a ChunkIterator that reads all of the FTDC
+// files in a mongod diagnostic.data directory as a single stream.
+//
+// Files are read in the order that they were written, using the time
+// and sequence number in their names (e.g.
+// "metrics.2019-08-14T15-37-35Z-00000"), and the "metrics.interim"
+// file, which holds the most recent samples, is read last. Files
+// whose names don't follow this scheme are ignored. Because the
+// interim file and the files around a restart can contain the same
+// samples, samples that are not newer than the last sample returned
+// are dropped, so that each sample is returned once.
+//
+// Errors reading a file do not stop the iterator, which moves on to
+// the next file; the errors, annotated with the name of the file, are
+// reported by the iterator's Err method.
+func ReadDirectory(ctx context.Context, dir string) *ChunkIterator {
+	return ReadDirectoryWithOptions(ctx, dir, ReadOptions{})
+}
+
+// ReadDirectoryWithOptions is the same as ReadDirectory, but uses the
+// options to control which data the iterator decodes. The options
+// apply to each file, and files that are entirely outside of the time
+// range are not read.
+func ReadDirectoryWithOptions(ctx context.Context, dir string, opts ReadOptions) *ChunkIterator {
+	return ReadDirectoryFS(ctx, os.DirFS(dir), ".", opts)
+}
+
+// ReadDirectoryFS is the same as ReadDirectoryWithOptions, but reads
+// the diagnostic.data directory from a file system, which makes it
+// possible to read directories from archives (see OpenArchive) or
+// embedded files without extracting them. The directory name must be
+// a valid path for the file system, as described by fs.ValidPath.
+func ReadDirectoryFS(ctx context.Context, fsys fs.FS, dir string, opts ReadOptions) *ChunkIterator {
+	return readFiles(ctx, &directoryReader{fsys: fsys, dir: dir, opts: opts})
+}
+
+// ReadWithInterim creates a ChunkIterator that reads the output of a
+// streaming collector, and then the collector's interim file (see
+// NewStreamingCollectorWithInterim), if it exists, so that the
+// samples in the interim file are read as the final chunk. As with
+// ReadDirectory, samples in the interim file that are not newer than
+// the last sample in the output are dropped, and errors reading
+// either file are reported by the iterator's Err method.
+func ReadWithInterim(ctx context.Context, path, interimPath string, opts ReadOptions) *ChunkIterator {
+	files := []diagnosticFile{{name: path}}
+	if _, err := os.Stat(interimPath); !os.IsNotExist(err) {
+		files = append(files, diagnosticFile{name: interimPath, interim: true})
+	}
+
+	return readFiles(ctx, &directoryReader{fsys: osFiles{}, opts: opts, files: files, listed: true})
+}
+
+// osFiles opens files by their paths on the operating system, rather
+// than by paths within a file system, so that a directoryReader can
+// read a list of files from different directories.
+type osFiles struct{}
+
+func (osFiles) Open(name string) (fs.File, error) { return os.Open(name) }
+
+// readFiles creates a ChunkIterator that reads the files from the
+// directory reader in turn.
+func readFiles(ctx context.Context, source *directoryReader) *ChunkIterator {
+	opts := source.opts
+	iter := &ChunkIterator{
+		catcher: util.NewCatcher(),
+		pipe:    make(chan *Chunk, 2),
+	}
+
+	ctx, iter.cancel = context.WithCancel(ctx)
+
+	if err := opts.Validate(); err != nil {
+		iter.catcher.Add(errors.Wrap(err, "invalid read options"))
+		close(iter.pipe)
+		return iter
+	}
+
+	source.ctx = ctx
+	source.iter = iter
+
+	if opts.Synchronous {
+		iter.ctx = ctx
+		iter.source = source
+		return iter
+	}
+
+	iter.wg.Add(1)
+	go func() {
+		defer iter.wg.Done()
+		defer close(iter.pipe)
+		defer source.close()
+
+		for {
+			chunk, err := source.next()
+			if err != nil {
+				if err != io.EOF {
+					iter.catcher.Add(err)
+				}
+				return
+			}
+
+			if !sendChunk(ctx, iter.pipe, chunk) {
+				return
+			}
+		}
+	}()
+
+	return iter
+}
+
+// ReadDirectoryMetrics returns a standard document iterator, like
+// ReadMetrics, over all of the FTDC files in a mongod diagnostic.data
+// directory, as ReadDirectory. The Documents returned by the iterator
+// are flattened.
+func ReadDirectoryMetrics(ctx context.Context, dir string, opts ReadOptions) Iterator {
+	return ReadDirectoryMetricsFS(ctx, os.DirFS(dir), ".", opts)
+}
+
+// ReadDirectoryMetricsFS is the same as ReadDirectoryMetrics, but
+// reads the directory from a file system, as ReadDirectoryFS.
+func ReadDirectoryMetricsFS(ctx context.Context, fsys fs.FS, dir string, opts ReadOptions) Iterator {
+	return newCombinedIterator(ctx, opts, func(ctx context.Context) *ChunkIterator {
+		return ReadDirectoryFS(ctx, fsys, dir, opts)
+	}, true)
+}
+
+// ReadDirectoryStructuredMetrics returns a standard document
+// iterator, like ReadStructuredMetrics, over all of the FTDC files in
+// a mongod diagnostic.data directory, as ReadDirectory. The Documents
+// returned by the iterator retain the structure of the input
+// documents.
+func ReadDirectoryStructuredMetrics(ctx context.Context, dir string, opts ReadOptions) Iterator {
+	return ReadDirectoryStructuredMetricsFS(ctx, os.DirFS(dir), ".", opts)
+}
+
+// ReadDirectoryStructuredMetricsFS is the same as
+// ReadDirectoryStructuredMetrics, but reads the directory from a file
+// system, as ReadDirectoryFS.
+func ReadDirectoryStructuredMetricsFS(ctx context.Context, fsys fs.FS, dir string, opts ReadOptions) Iterator {
+	return newCombinedIterator(ctx, opts, func(ctx context.Context) *ChunkIterator {
+		return ReadDirectoryFS(ctx, fsys, dir, opts)
+	}, false)
+}
+
+// directoryReader reads the chunks from each of the files in a
+// diagnostic.data directory in turn, and implements chunkSource.
+// Errors reading a file are added to the iterator, rather than
+// returned, so that reading continues with the next file.
+type directoryReader struct {
+	ctx   context.Context
+	fsys  fs.FS
+	dir   string
+	opts  ReadOptions
+	iter  *ChunkIterator
+	files []diagnosticFile
+	// listed is true once the files in the directory have
+	// been listed.
+	listed bool
+	// position is the index of the next file to read.
+	position int
+	// current and chunks are the file that's being read, if
+	// any, and the iterator for its chunks.
+	current diagnosticFile
+	file    fs.File
+	chunks  *ChunkIterator
+	last    *time.Time
+}
+
+func (r *directoryReader) next() (*Chunk, error) {
+	if !r.listed {
+		files, err := listDiagnosticFiles(r.fsys, r.dir)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		r.files = files
+		r.listed = true
+	}
+
+	for {
+		if r.chunks == nil && !r.open() {
+			return nil, io.EOF
+		}
+
+		for r.chunks.Next() {
+			if chunk := dropPreviousSamples(r.chunks.Chunk(), &r.last); chunk != nil {
+				return chunk, nil
+			}
+		}
+
+		r.closeFile(true)
+	}
+}
+
+// open opens the next file that may have samples in the time range,
+// and returns false if there are no more files to read.
+func (r *directoryReader) open() bool {
+	for r.position < len(r.files) {
+		if r.ctx.Err() != nil {
+			return false
+		}
+
+		idx := r.position
+		file := r.files[idx]
+		r.position++
+
+		if !file.interim && r.opts.startsAfterRange(file.started) {
+			r.position = len(r.files)
+			return false
+		}
+
+		// every sample in a file was collected before the
+		// next file was started, so files that are followed
+		// by a file that starts before the range can be
+		// skipped. The names only record the second that the
+		// file was started, so the next file may have started
+		// up to a second after its name, and must start
+		// before the second of the range's start.
+		if idx+1 < len(r.files) && !r.files[idx+1].interim && !r.opts.Start.IsZero() && r.files[idx+1].started.Before(r.opts.Start.Truncate(time.Second)) {
+			continue
+		}
+
+		f, err := r.fsys.Open(path.Join(r.dir, file.name))
+		if err != nil {
+			r.iter.catcher.Add(errors.Wrapf(err, "problem reading '%s'", file.name))
+			continue
+		}
+
+		r.current = file
+		r.file = f
+		r.chunks = ReadChunksWithOptions(r.ctx, f, r.opts)
+		return true
+	}
+
+	return false
+}
+
+// closeFile releases the file that's being read, and, when the file
+// has been read completely, reports the errors and skipped chunks
+// from the file.
+func (r *directoryReader) closeFile(complete bool) {
+	if r.chunks == nil {
+		return
+	}
+
+	r.chunks.Close()
+	r.chunks.wait()
+
+	if complete {
+		for _, skipped := range r.chunks.Skipped() {
+			skipped.File = r.current.name
+			r.iter.addSkipped(skipped)
+		}
+		r.iter.catcher.Add(errors.Wrapf(r.chunks.Err(), "problem reading '%s'", r.current.name))
+	}
+
+	r.iter.catcher.Add(errors.Wrapf(r.file.Close(), "problem closing '%s'", r.current.name))
+	r.chunks = nil
+	r.file = nil
+}
+
+func (r *directoryReader) close() { r.closeFile(false) }
+
+// dropPreviousSamples returns the chunk with only the samples that
+// are newer than the last sample, or nil if there are none, and
+// updates the last sample time. Chunks without a timestamp metric
+// are compared using their "_id".
+func dropPreviousSamples(chunk *Chunk, last **time.Time) *Chunk {
+	times := chunk.sampleTimes()
+	if times == nil {
+		if *last != nil && !chunk.id.After(**last) {
+			return nil
+		}
+		*last = &chunk.id
+		return chunk
+	}
+
+	start := 0
+	if *last != nil {
+		for start < len(times) && !time.Unix(0, times[start]).After(**last) {
+			start++
+		}
+	}
+
+	switch {
+	case start == len(times):
+		return nil
+	case start > 0:
+		chunk = chunk.slice(start, len(times))
+	}
+
+	newest := time.Unix(0, times[len(times)-1])
+	*last = &newest
+
+	return chunk
+}
@@ -0,0 +1,172 @@
+package ftdc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDiagnosticFileName(t *testing.T) {
+	file, ok := parseDiagnosticFileName("metrics.2019-08-14T15-37-35Z-00003")
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2019, 8, 14, 15, 37, 35, 0, time.UTC), file.started)
+	assert.Equal(t, 3, file.sequence)
+	assert.False(t, file.interim)
+
+	file, ok = parseDiagnosticFileName("metrics.2019-08-14T15-37-35Z")
+	require.True(t, ok)
+	assert.Equal(t, 0, file.sequence)
+
+	file, ok = parseDiagnosticFileName("metrics.interim")
+	require.True(t, ok)
+	assert.True(t, file.interim)
+
+	for _, name := range []string{
+		"metrics",
+		"metrics.",
+		"metrics.interim.tmp",
+		"metrics.2019-08-14",
+		"metrics.2019-08-14T15-37-35Z-",
+		"metrics.2019-08-14T15-37-35Z_00000",
+		"diagnostic.2019-08-14T15-37-35Z-00000",
+	} {
+		_, ok = parseDiagnosticFileName(name)
+		assert.False(t, ok, name)
+	}
+
+	files := []diagnosticFile{}
+	for _, name := range []string{
+		"metrics.interim",
+		"metrics.2019-08-14T15-37-35Z-00001",
+		"metrics.2019-08-15T00-00-00Z-00000",
+		"metrics.2019-08-14T15-37-35Z-00000",
+	} {
+		file, ok = parseDiagnosticFileName(name)
+		require.True(t, ok)
+		files = append(files, file)
+	}
+	sortDiagnosticFiles(files)
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.name)
+	}
+	assert.Equal(t, []string{
+		"metrics.2019-08-14T15-37-35Z-00000",
+		"metrics.2019-08-14T15-37-35Z-00001",
+		"metrics.2019-08-15T00-00-00Z-00000",
+		"metrics.interim",
+	}, names)
+}
+
+func TestReadDirectory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+
+	// the interim file is written first and overlaps with the
+	// last metrics file, as after a restart.
+	write("metrics.interim", produceTimedPayload(t, start.Add(40*time.Second), 20, 10))
+	write("metrics.2020-01-01T00-00-00Z-00000", produceTimedPayload(t, start, 20, 10))
+	write("metrics.2020-01-01T00-00-20Z-00000", produceTimedPayload(t, start.Add(20*time.Second), 25, 10))
+	write("metrics.2020-01-01T00-00-45Z-00000", []byte("not ftdc data"))
+	write("README", []byte("ignored"))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "metrics.2020-01-01T00-00-10Z-00000"), 0755))
+
+	readTimes := func(t *testing.T, iter *ChunkIterator) []time.Time {
+		defer iter.Close()
+		out := []time.Time{}
+		for iter.Next() {
+			for _, ts := range iter.Chunk().Metrics[0].Values {
+				out = append(out, timeEpocMs(ts))
+			}
+		}
+		return out
+	}
+
+	t.Run("Chunks", func(t *testing.T) {
+		iter := ReadDirectory(ctx, dir)
+		times := readTimes(t, iter)
+		require.Len(t, times, 60)
+		for idx, ts := range times {
+			assert.Equal(t, start.Add(time.Duration(idx)*time.Second), ts.UTC())
+		}
+
+		err := iter.Err()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "metrics.2020-01-01T00-00-45Z-00000")
+	})
+	t.Run("TimeRange", func(t *testing.T) {
+		iter := ReadDirectoryWithOptions(ctx, dir, ReadOptions{
+			Start: start.Add(25 * time.Second),
+			End:   start.Add(50 * time.Second),
+		})
+		times := readTimes(t, iter)
+		require.Len(t, times, 25)
+		assert.Equal(t, start.Add(25*time.Second), times[0].UTC())
+		assert.Equal(t, start.Add(49*time.Second), times[24].UTC())
+	})
+	t.Run("SkipCorrupt", func(t *testing.T) {
+		iter := ReadDirectoryWithOptions(ctx, dir, ReadOptions{SkipCorrupt: true})
+		assert.Len(t, readTimes(t, iter), 60)
+		assert.NoError(t, iter.Err())
+
+		skipped := iter.Skipped()
+		require.Len(t, skipped, 1)
+		assert.Equal(t, "metrics.2020-01-01T00-00-45Z-00000", skipped[0].File)
+		assert.Contains(t, skipped[0].Error(), skipped[0].File)
+	})
+	t.Run("Metrics", func(t *testing.T) {
+		iter := ReadDirectoryMetrics(ctx, dir, ReadOptions{SkipCorrupt: true})
+		defer iter.Close()
+		count := 0
+		for iter.Next() {
+			ts, ok := iter.Document().Lookup("ts").TimeOK()
+			require.True(t, ok)
+			assert.Equal(t, start.Add(time.Duration(count)*time.Second), ts.UTC())
+			count++
+		}
+		assert.NoError(t, iter.Err())
+		assert.Equal(t, 60, count)
+	})
+	t.Run("StructuredMetrics", func(t *testing.T) {
+		iter := ReadDirectoryStructuredMetrics(ctx, dir, ReadOptions{Include: []string{"counter"}})
+		defer iter.Close()
+		count := 0
+		for iter.Next() {
+			assert.Equal(t, 1, iter.Document().Len())
+			count++
+		}
+		assert.Error(t, iter.Err())
+		assert.Equal(t, 60, count)
+	})
+	t.Run("TruncatedNames", func(t *testing.T) {
+		// the second file starts part way through the second
+		// in its name, after the last sample of the first
+		// file.
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "metrics.2020-01-01T00-00-00Z-00000"), produceTimedPayload(t, start.Add(500*time.Millisecond), 21, 10), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "metrics.2020-01-01T00-00-20Z-00000"), produceTimedPayload(t, start.Add(20900*time.Millisecond), 10, 10), 0644))
+
+		iter := ReadDirectoryWithOptions(ctx, dir, ReadOptions{Start: start.Add(20300 * time.Millisecond)})
+		times := readTimes(t, iter)
+		assert.NoError(t, iter.Err())
+		require.Len(t, times, 11)
+		assert.Equal(t, start.Add(20500*time.Millisecond), times[0].UTC())
+	})
+	t.Run("MissingDirectory", func(t *testing.T) {
+		iter := ReadDirectory(ctx, filepath.Join(dir, "missing"))
+		assert.False(t, iter.Next())
+		assert.Error(t, iter.Err())
+		iter.Close()
+	})
+}
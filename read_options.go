@@ -0,0 +1,166 @@
+package ftdc
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReadOptions control how FTDC data is decoded by the Read*
+// functions. The zero value is valid and reads every sample in the
+// source.
+type ReadOptions struct {
+	// Start and End, when non-zero, restrict the samples returned
+	// to the half-open interval [Start, End). Chunks that fall
+	// entirely outside of the range are skipped before they are
+	// decompressed, using the "_id" of each chunk; chunks that
+	// straddle the boundaries are trimmed to the samples that fall
+	// within the range.
+	//
+	// Sample times are taken from the timestamp metric (see
+	// TimestampKey). When a chunk has no timestamp metric its
+	// samples cannot be trimmed and the chunk is returned whole if
+	// its "_id" is within the range.
+	Start time.Time
+	End   time.Time
+
+	// TimestampKey is the key (see Metric.Key) of the date-time
+	// metric that holds the time of each sample, which is used to
+	// trim samples to the time range, and is reported by
+	// Chunk.Timestamps and TimestampIterator.Timestamp. When empty, or when
+	// a chunk has no date-time metric with the key, the first
+	// date-time metric in the chunk is used, which is the same
+	// field that the collectors use for the "_id" of each chunk
+	// (e.g. "start" in the server's serverStatus documents.)
+	TimestampKey string
+
+	// Include, when non-empty, limits the metrics decoded to those
+	// whose fully qualified key (see Metric.Key) matches one of the
+	// patterns. A pattern matches a key if it is equal to the key,
+	// if it is a dot-separated prefix of the key (e.g.
+	// "wiredTiger.cache" matches "wiredTiger.cache.bytes read"),
+	// or, for patterns containing any of "*?[", if it matches the
+	// key as a glob using the syntax of path.Match. Because keys
+	// are dot-separated, "*" in a glob matches across levels.
+	//
+	// All metrics are still walked in the compressed payload, but
+	// values are only allocated for the selected metrics, and the
+	// documents produced by the iterators contain only the selected
+	// paths. Elements of arrays that are not selected are omitted,
+	// so positions in arrays in structured documents may not match
+	// the original documents, though the flattened keys do.
+	Include []string
+
+	// Workers sets the number of goroutines that decompress and
+	// decode chunks concurrently. Chunks are always returned in
+	// the order that they appear in the source, regardless of the
+	// number of workers. Values less than 2 decode chunks in a
+	// single goroutine.
+	Workers int
+
+	// SkipCorrupt, when true, makes readers skip chunks that
+	// cannot be decoded, rather than stopping at the first
+	// problem. When the source contains a truncated or malformed
+	// document, reading resumes at the next valid chunk
+	// document. Each skipped chunk, or damaged region, is
+	// reported as a *ChunkError by ChunkIterator.Skipped, and
+	// does not cause Err to return an error.
+	SkipCorrupt bool
+
+	// Synchronous, when true, makes the iterators read and decode
+	// the source lazily, in the goroutine that calls Next, rather
+	// than in background goroutines, and reuse the buffers used to
+	// decompress chunks. Synchronous iterators do not start any
+	// goroutines, and must not be used concurrently. Workers must
+	// be less than 2 when reading synchronously.
+	Synchronous bool
+}
+
+// Validate checks the read options and returns an error if any of
+// the settings are invalid.
+func (opts ReadOptions) Validate() error {
+	if !opts.Start.IsZero() && !opts.End.IsZero() && !opts.Start.Before(opts.End) {
+		return errors.Errorf("start time [%s] must be before end time [%s]", opts.Start, opts.End)
+	}
+
+	if opts.Workers < 0 {
+		return errors.New("cannot use a negative number of workers")
+	}
+
+	if opts.Synchronous && opts.Workers > 1 {
+		return errors.New("cannot decode chunks with multiple workers when reading synchronously")
+	}
+
+	for _, pattern := range opts.Include {
+		if pattern == "" {
+			return errors.New("include patterns must not be empty")
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid include pattern '%s'", pattern)
+		}
+	}
+
+	return nil
+}
+
+func (opts ReadOptions) hasProjection() bool { return len(opts.Include) > 0 }
+
+// selected reports if the metric with the specified key should be
+// decoded.
+func (opts ReadOptions) selected(key string) bool {
+	if len(opts.Include) == 0 {
+		return true
+	}
+
+	for _, pattern := range opts.Include {
+		if key == pattern {
+			return true
+		}
+
+		if strings.HasPrefix(key, pattern) && key[len(pattern)] == '.' {
+			return true
+		}
+
+		if strings.ContainsAny(pattern, "*?[") {
+			if ok, _ := path.Match(pattern, key); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (opts ReadOptions) hasTimeRange() bool { return !opts.Start.IsZero() || !opts.End.IsZero() }
+
+// startsAfterRange reports if a chunk with the specified "_id" is
+// entirely after the end of the range.
+func (opts ReadOptions) startsAfterRange(id time.Time) bool {
+	return !opts.End.IsZero() && !id.Before(opts.End)
+}
+
+// startsBeforeRange reports if a chunk with the specified "_id"
+// begins before the start of the range. Because chunks are written in
+// time order, when a chunk starts before the range, all of the
+// samples in the chunk preceding it must also be outside of the
+// range. A chunk without an "_id" says nothing about the chunk
+// preceding it, which must be decoded, and filtered by the times of
+// its samples.
+func (opts ReadOptions) startsBeforeRange(id time.Time) bool {
+	return !opts.Start.IsZero() && !id.IsZero() && id.Before(opts.Start)
+}
+
+func (opts ReadOptions) includes(ts time.Time) bool {
+	if !opts.Start.IsZero() && ts.Before(opts.Start) {
+		return false
+	}
+
+	if !opts.End.IsZero() && !ts.Before(opts.End) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,956 @@
+package ftdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func produceTimedPayload(t *testing.T, start time.Time, samples, chunkSize int) []byte {
+	collector := NewBatchCollector(chunkSize)
+	for i := 0; i < samples; i++ {
+		require.NoError(t, collector.Add(birch.NewDocument(
+			birch.EC.Time("ts", start.Add(time.Duration(i)*time.Second)),
+			birch.EC.Int64("counter", int64(i)),
+			birch.EC.Int32("gauge", int32(i%7)),
+		)))
+	}
+
+	payload, err := collector.Resolve()
+	require.NoError(t, err)
+	return payload
+}
+
+func TestReadOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	payload := produceTimedPayload(t, start, 100, 10)
+
+	t.Run("Validate", func(t *testing.T) {
+		assert.NoError(t, ReadOptions{}.Validate())
+		assert.NoError(t, ReadOptions{Start: start}.Validate())
+		assert.NoError(t, ReadOptions{End: start}.Validate())
+		assert.NoError(t, ReadOptions{Start: start, End: start.Add(time.Second)}.Validate())
+		assert.Error(t, ReadOptions{Start: start, End: start}.Validate())
+		assert.Error(t, ReadOptions{Start: start.Add(time.Second), End: start}.Validate())
+	})
+	t.Run("InvalidOptionsReportError", func(t *testing.T) {
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Start: start, End: start})
+		defer iter.Close()
+		assert.False(t, iter.Next())
+		assert.Error(t, iter.Err())
+	})
+	t.Run("TimeRange", func(t *testing.T) {
+		for _, test := range []struct {
+			name     string
+			opts     ReadOptions
+			chunks   int
+			first    int64
+			expected int
+		}{
+			{
+				name:     "Unbounded",
+				chunks:   10,
+				expected: 100,
+			},
+			{
+				name:     "ChunkAligned",
+				opts:     ReadOptions{Start: start.Add(20 * time.Second), End: start.Add(40 * time.Second)},
+				chunks:   2,
+				first:    20,
+				expected: 20,
+			},
+			{
+				name:     "Straddling",
+				opts:     ReadOptions{Start: start.Add(25 * time.Second), End: start.Add(52 * time.Second)},
+				chunks:   4,
+				first:    25,
+				expected: 27,
+			},
+			{
+				name:     "StartOnly",
+				opts:     ReadOptions{Start: start.Add(95 * time.Second)},
+				chunks:   1,
+				first:    95,
+				expected: 5,
+			},
+			{
+				name:     "EndOnly",
+				opts:     ReadOptions{End: start.Add(5 * time.Second)},
+				chunks:   1,
+				expected: 5,
+			},
+			{
+				name: "BeforeData",
+				opts: ReadOptions{End: start.Add(-time.Second)},
+			},
+			{
+				name: "AfterData",
+				opts: ReadOptions{Start: start.Add(time.Hour)},
+			},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				t.Run("Chunks", func(t *testing.T) {
+					iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), test.opts)
+					defer iter.Close()
+
+					chunks, samples := 0, 0
+					for iter.Next() {
+						chunk := iter.Chunk()
+						if chunks == 0 {
+							assert.Equal(t, test.first, chunk.Metrics[1].Values[0])
+							assert.Equal(t, test.first, chunk.Metrics[1].startingValue)
+							assert.Equal(t, start.Add(time.Duration(test.first)*time.Second), chunk.id.UTC())
+						}
+						for _, m := range chunk.Metrics {
+							assert.Len(t, m.Values, chunk.Size())
+						}
+						chunks++
+						samples += chunk.Size()
+					}
+					require.NoError(t, iter.Err())
+					assert.Equal(t, test.chunks, chunks)
+					assert.Equal(t, test.expected, samples)
+				})
+				t.Run("Metrics", func(t *testing.T) {
+					iter := ReadMetricsWithOptions(ctx, bytes.NewBuffer(payload), test.opts)
+					defer iter.Close()
+
+					count := 0
+					for iter.Next() {
+						doc := iter.Document()
+						assert.Equal(t, test.first+int64(count), doc.Lookup("counter").Int64())
+						assert.True(t, test.opts.includes(doc.Lookup("ts").Time()))
+						count++
+					}
+					require.NoError(t, iter.Err())
+					assert.Equal(t, test.expected, count)
+				})
+				t.Run("StructuredMetrics", func(t *testing.T) {
+					iter := ReadStructuredMetricsWithOptions(ctx, bytes.NewBuffer(payload), test.opts)
+					defer iter.Close()
+
+					count := 0
+					for iter.Next() {
+						assert.Equal(t, test.first+int64(count), iter.Document().Lookup("counter").Int64())
+						count++
+					}
+					require.NoError(t, iter.Err())
+					assert.Equal(t, test.expected, count)
+				})
+				t.Run("Matrix", func(t *testing.T) {
+					iter := ReadMatrixWithOptions(ctx, bytes.NewBuffer(payload), test.opts)
+					defer iter.Close()
+
+					count := 0
+					for iter.Next() {
+						count += iter.Document().Lookup("counter").MutableArray().Len()
+					}
+					require.NoError(t, iter.Err())
+					assert.Equal(t, test.expected, count)
+				})
+			})
+		}
+	})
+	t.Run("StopsAfterRange", func(t *testing.T) {
+		// the source isn't read past the first chunk that starts
+		// after the end of the range, so the garbage that follows
+		// it is never reached.
+		source := append(append([]byte{}, payload...), bytes.Repeat([]byte{0xff}, 64)...)
+		for _, opts := range []ReadOptions{
+			{End: start.Add(25 * time.Second)},
+			{End: start.Add(25 * time.Second), Workers: 4},
+			{End: start.Add(25 * time.Second), Synchronous: true},
+		} {
+			iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(source), opts)
+			count := 0
+			for iter.Next() {
+				count += iter.Chunk().Size()
+			}
+			assert.NoError(t, iter.Err())
+			assert.Equal(t, 25, count)
+			iter.Close()
+		}
+
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(source), ReadOptions{})
+		for iter.Next() {
+		}
+		assert.Error(t, iter.Err())
+		iter.Close()
+	})
+	t.Run("UntimedChunksUseID", func(t *testing.T) {
+		collector := NewBaseCollector(10)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, collector.Add(birch.NewDocument(birch.EC.Int64("counter", int64(i)))))
+		}
+		untimed, err := collector.Resolve()
+		require.NoError(t, err)
+
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(untimed), ReadOptions{Start: time.Now().Add(-time.Hour)})
+		defer iter.Close()
+		require.True(t, iter.Next())
+		assert.Equal(t, 10, iter.Chunk().Size())
+		assert.False(t, iter.Next())
+		require.NoError(t, iter.Err())
+
+		iter = ReadChunksWithOptions(ctx, bytes.NewBuffer(untimed), ReadOptions{End: time.Now().Add(-time.Hour)})
+		defer iter.Close()
+		assert.False(t, iter.Next())
+		require.NoError(t, iter.Err())
+	})
+	t.Run("ChunkWithoutID", func(t *testing.T) {
+		// the chunk holding the samples 30 to 39 has no "_id",
+		// which doesn't mean that the chunk before it, which
+		// straddles the start of the range, can be skipped.
+		var source []byte
+		for data := payload; len(data) > 0; {
+			doc, err := birch.ReadDocument(data)
+			require.NoError(t, err)
+			chunk, err := doc.MarshalBSON()
+			require.NoError(t, err)
+			data = data[len(chunk):]
+
+			if id, ok := doc.Lookup("_id").TimeOK(); ok && id.Equal(start.Add(30*time.Second)) {
+				doc.Delete("_id")
+				chunk, err = doc.MarshalBSON()
+				require.NoError(t, err)
+			}
+			source = append(source, chunk...)
+		}
+
+		for _, opts := range []ReadOptions{
+			{Start: start.Add(25 * time.Second)},
+			{Start: start.Add(25 * time.Second), Workers: 4},
+			{Start: start.Add(25 * time.Second), Synchronous: true},
+		} {
+			iter := ReadMetricsWithOptions(ctx, bytes.NewBuffer(source), opts)
+			assert.Equal(t, counterRange(25, 100), readIteratorCounters(t, iter))
+		}
+	})
+}
+
+func TestReadProjection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	collector := NewBatchCollector(10)
+	for i := 0; i < 30; i++ {
+		require.NoError(t, collector.Add(birch.NewDocument(
+			birch.EC.Time("start", start.Add(time.Duration(i)*time.Second)),
+			birch.EC.String("host", "localhost"),
+			birch.EC.SubDocumentFromElements("opcounters",
+				birch.EC.Int64("insert", int64(i)),
+				birch.EC.Int64("query", int64(2*i)),
+			),
+			birch.EC.SubDocumentFromElements("wiredTiger",
+				birch.EC.SubDocumentFromElements("cache",
+					birch.EC.Int64("bytes read", int64(3*i)),
+					birch.EC.Double("ratio", float64(i)/2),
+				),
+				birch.EC.Timestamp("checkpoint", uint32(i), 1),
+			),
+			birch.EC.ArrayFromElements("latencies", birch.VC.Int32(1), birch.VC.Int32(int32(i))),
+		)))
+	}
+	payload, err := collector.Resolve()
+	require.NoError(t, err)
+
+	readKeys := func(t *testing.T, opts ReadOptions) []string {
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), opts)
+		defer iter.Close()
+		require.True(t, iter.Next())
+		keys := iter.Chunk().getFieldNames()
+		for iter.Next() {
+			assert.Equal(t, keys, iter.Chunk().getFieldNames())
+		}
+		require.NoError(t, iter.Err())
+		return keys
+	}
+
+	t.Run("Validate", func(t *testing.T) {
+		assert.NoError(t, ReadOptions{Include: []string{"a", "b.*"}}.Validate())
+		assert.Error(t, ReadOptions{Include: []string{""}}.Validate())
+		assert.Error(t, ReadOptions{Include: []string{"a["}}.Validate())
+	})
+	t.Run("Selection", func(t *testing.T) {
+		for _, test := range []struct {
+			name     string
+			include  []string
+			expected []string
+		}{
+			{
+				name:     "Exact",
+				include:  []string{"opcounters.insert"},
+				expected: []string{"opcounters.insert"},
+			},
+			{
+				name:     "Prefix",
+				include:  []string{"wiredTiger.cache"},
+				expected: []string{"wiredTiger.cache.bytes read", "wiredTiger.cache.ratio"},
+			},
+			{
+				name:     "PrefixRequiresBoundary",
+				include:  []string{"opcounters.ins"},
+				expected: []string{},
+			},
+			{
+				name:     "Glob",
+				include:  []string{"*.query", "latencies.1"},
+				expected: []string{"opcounters.query", "latencies.1"},
+			},
+			{
+				name:     "Timestamp",
+				include:  []string{"wiredTiger.checkpoint"},
+				expected: []string{"wiredTiger.checkpoint", "wiredTiger.checkpoint.inc"},
+			},
+			{
+				name:     "Multiple",
+				include:  []string{"start", "opcounters"},
+				expected: []string{"start", "opcounters.insert", "opcounters.query"},
+			},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				assert.Equal(t, test.expected, readKeys(t, ReadOptions{Include: test.include}))
+			})
+		}
+	})
+	t.Run("Values", func(t *testing.T) {
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Include: []string{"wiredTiger.cache.bytes read"}})
+		defer iter.Close()
+
+		count := 0
+		for iter.Next() {
+			chunk := iter.Chunk()
+			require.Len(t, chunk.Metrics, 1)
+			for _, value := range chunk.Metrics[0].Values {
+				assert.Equal(t, int64(3*count), value)
+				count++
+			}
+		}
+		require.NoError(t, iter.Err())
+		assert.Equal(t, 30, count)
+	})
+	t.Run("FlattenedDocuments", func(t *testing.T) {
+		iter := ReadMetricsWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Include: []string{"opcounters.query", "latencies.1"}})
+		defer iter.Close()
+
+		count := 0
+		for iter.Next() {
+			doc := iter.Document()
+			require.Equal(t, 2, doc.Len())
+			assert.Equal(t, int64(2*count), doc.Lookup("opcounters.query").Int64())
+			assert.Equal(t, int32(count), doc.Lookup("latencies.1").Int32())
+			count++
+		}
+		require.NoError(t, iter.Err())
+		assert.Equal(t, 30, count)
+	})
+	t.Run("StructuredDocuments", func(t *testing.T) {
+		iter := ReadStructuredMetricsWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Include: []string{"wiredTiger.cache.ratio", "opcounters.insert"}})
+		defer iter.Close()
+
+		count := 0
+		for iter.Next() {
+			doc := iter.Document()
+			require.Equal(t, 2, doc.Len())
+			assert.Equal(t, 1, doc.Lookup("opcounters").MutableDocument().Len())
+			assert.Equal(t, int64(count), doc.Lookup("opcounters").MutableDocument().Lookup("insert").Int64())
+			assert.Equal(t, 1, doc.Lookup("wiredTiger").MutableDocument().Len())
+			assert.Equal(t, float64(count)/2, doc.Lookup("wiredTiger").MutableDocument().Lookup("cache").MutableDocument().Lookup("ratio").Double())
+			count++
+		}
+		require.NoError(t, iter.Err())
+		assert.Equal(t, 30, count)
+	})
+	t.Run("Matrix", func(t *testing.T) {
+		iter := ReadMatrixWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Include: []string{"opcounters"}})
+		defer iter.Close()
+
+		count := 0
+		for iter.Next() {
+			doc := iter.Document()
+			require.Equal(t, 2, doc.Len())
+			count += doc.Lookup("opcounters.insert").MutableArray().Len()
+		}
+		require.NoError(t, iter.Err())
+		assert.Equal(t, 30, count)
+	})
+	t.Run("TimeRangeWithoutTimestamp", func(t *testing.T) {
+		iter := ReadMetricsWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{
+			Include: []string{"opcounters.insert"},
+			Start:   start.Add(5 * time.Second),
+			End:     start.Add(15 * time.Second),
+		})
+		defer iter.Close()
+
+		count := 0
+		for iter.Next() {
+			doc := iter.Document()
+			require.Equal(t, 1, doc.Len())
+			assert.Equal(t, int64(5+count), doc.Lookup("opcounters.insert").Int64())
+			count++
+		}
+		require.NoError(t, iter.Err())
+		assert.Equal(t, 10, count)
+	})
+	t.Run("MarkerDocuments", func(t *testing.T) {
+		// nanosecond times and unsigned integers are single
+		// metrics, so the globs select them by the key of the
+		// document that records them, not by its "$nanos" or
+		// "$uint64" field.
+		collector := NewBatchCollector(10)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, collector.Add(birch.NewDocument(
+				NanosecondTime("ts", start.Add(time.Duration(i)*time.Nanosecond)),
+				Uint64("bytes", uint64(i)),
+				birch.EC.Int64("counter", int64(i)),
+			)))
+		}
+		payload, err := collector.Resolve()
+		require.NoError(t, err)
+
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Include: []string{"t?", "byte?"}})
+		defer iter.Close()
+		require.True(t, iter.Next())
+		chunk := iter.Chunk()
+		assert.Equal(t, []string{"ts", "bytes"}, chunk.getFieldNames())
+		assert.Equal(t, start.UnixNano()+9, chunk.Metrics[0].Nanoseconds()[9])
+		assert.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, chunk.Metrics[1].Uint64s())
+		assert.False(t, iter.Next())
+		require.NoError(t, iter.Err())
+	})
+}
+
+func TestReadParallel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	payload := produceTimedPayload(t, start, 1000, 10)
+
+	t.Run("Validate", func(t *testing.T) {
+		assert.NoError(t, ReadOptions{Workers: 8}.Validate())
+		assert.Error(t, ReadOptions{Workers: -1}.Validate())
+	})
+	for _, test := range []struct {
+		name     string
+		opts     ReadOptions
+		first    int64
+		expected int
+	}{
+		{
+			name:     "AllChunks",
+			opts:     ReadOptions{Workers: 4},
+			expected: 1000,
+		},
+		{
+			name:     "MoreWorkersThanChunks",
+			opts:     ReadOptions{Workers: 200},
+			expected: 1000,
+		},
+		{
+			name:     "TimeRange",
+			opts:     ReadOptions{Workers: 4, Start: start.Add(105 * time.Second), End: start.Add(500 * time.Second)},
+			first:    105,
+			expected: 395,
+		},
+		{
+			name:     "Projection",
+			opts:     ReadOptions{Workers: 4, Include: []string{"counter"}},
+			expected: 1000,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Run("Chunks", func(t *testing.T) {
+				sequential := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{
+					Start:   test.opts.Start,
+					End:     test.opts.End,
+					Include: test.opts.Include,
+				})
+				defer sequential.Close()
+				parallel := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), test.opts)
+				defer parallel.Close()
+
+				samples := 0
+				for parallel.Next() {
+					require.True(t, sequential.Next())
+					expected, actual := sequential.Chunk(), parallel.Chunk()
+					assert.Equal(t, expected.id, actual.id)
+					assert.Equal(t, expected.Metrics, actual.Metrics)
+					samples += actual.Size()
+				}
+				assert.False(t, sequential.Next())
+				require.NoError(t, parallel.Err())
+				require.NoError(t, sequential.Err())
+				assert.Equal(t, test.expected, samples)
+			})
+			t.Run("Metrics", func(t *testing.T) {
+				iter := ReadMetricsWithOptions(ctx, bytes.NewBuffer(payload), test.opts)
+				defer iter.Close()
+
+				last := test.first - 1
+				count := 0
+				for iter.Next() {
+					value := iter.Document().Lookup("counter").Int64()
+					assert.Equal(t, last+1, value)
+					last = value
+					count++
+				}
+				require.NoError(t, iter.Err())
+				assert.Equal(t, test.expected, count)
+			})
+		})
+	}
+	t.Run("EarlyClose", func(t *testing.T) {
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Workers: 4})
+		require.True(t, iter.Next())
+		iter.Close()
+		for iter.Next() {
+		}
+		assert.NoError(t, iter.Err())
+	})
+	t.Run("CorruptChunk", func(t *testing.T) {
+		corrupt := append([]byte{}, payload...)
+		// zero part of the compressed data of the first
+		// chunk to make it impossible to decode.
+		for i := 100; i < 140; i++ {
+			corrupt[i] = 0
+		}
+
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(corrupt), ReadOptions{Workers: 4})
+		defer iter.Close()
+		for iter.Next() {
+		}
+		assert.Error(t, iter.Err())
+	})
+}
+
+func TestReadSkipCorrupt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	payload := produceTimedPayload(t, start, 100, 10)
+
+	// find the offset of each of the chunk documents.
+	offsets := []int{}
+	for offset := 0; offset < len(payload); {
+		offsets = append(offsets, offset)
+		offset += int(int32(payload[offset]) | int32(payload[offset+1])<<8 | int32(payload[offset+2])<<16 | int32(payload[offset+3])<<24)
+	}
+	require.Len(t, offsets, 10)
+	offsets = append(offsets, len(payload))
+
+	copyPayload := func() []byte { return append([]byte{}, payload...) }
+
+	for _, test := range []struct {
+		name    string
+		data    func() []byte
+		samples int
+		skipped []ChunkError
+	}{
+		{
+			name:    "Intact",
+			data:    copyPayload,
+			samples: 100,
+		},
+		{
+			name:    "TruncatedTail",
+			data:    func() []byte { return payload[:offsets[9]+20] },
+			samples: 90,
+			skipped: []ChunkError{{Offset: int64(offsets[9]), Size: 20}},
+		},
+		{
+			name:    "TruncatedHeader",
+			data:    func() []byte { return payload[:offsets[10]+2] },
+			samples: 100,
+			skipped: []ChunkError{{Offset: int64(offsets[10]), Size: 2}},
+		},
+		{
+			name: "CorruptData",
+			data: func() []byte {
+				data := copyPayload()
+				for i := offsets[3] + 100; i < offsets[3]+140; i++ {
+					data[i] = 0
+				}
+				return data
+			},
+			samples: 90,
+			skipped: []ChunkError{{Offset: int64(offsets[3]), Size: int64(offsets[4] - offsets[3]), ID: start.Add(30 * time.Second)}},
+		},
+		{
+			name: "CorruptLength",
+			data: func() []byte {
+				data := copyPayload()
+				data[offsets[5]+3] = 0x7f
+				return data
+			},
+			samples: 90,
+			skipped: []ChunkError{{Offset: int64(offsets[5]), Size: int64(offsets[6] - offsets[5])}},
+		},
+		{
+			name: "ZeroedRegion",
+			data: func() []byte {
+				data := copyPayload()
+				for i := offsets[1]; i < offsets[3]; i++ {
+					data[i] = 0
+				}
+				return data
+			},
+			samples: 80,
+			skipped: []ChunkError{{Offset: int64(offsets[1]), Size: int64(offsets[3] - offsets[1])}},
+		},
+		{
+			name: "LargeSizes",
+			data: func() []byte {
+				// every offset in the region claims to
+				// begin a document of about 15MB.
+				data := copyPayload()
+				for i := offsets[1]; i < offsets[3]; i++ {
+					data[i] = []byte{0x00, 0x00, 0xf0, 0x00}[i%4]
+				}
+				return data
+			},
+			samples: 80,
+			skipped: []ChunkError{{Offset: int64(offsets[1]), Size: int64(offsets[3] - offsets[1])}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Run("Strict", func(t *testing.T) {
+				iter := ReadChunks(ctx, bytes.NewBuffer(test.data()))
+				defer iter.Close()
+				for iter.Next() {
+				}
+				if len(test.skipped) == 0 {
+					assert.NoError(t, iter.Err())
+				} else {
+					assert.Error(t, iter.Err())
+				}
+				assert.Empty(t, iter.Skipped())
+			})
+			for _, workers := range []int{0, 4} {
+				t.Run(fmt.Sprintf("Workers%d", workers), func(t *testing.T) {
+					iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(test.data()), ReadOptions{SkipCorrupt: true, Workers: workers})
+					defer iter.Close()
+
+					samples := 0
+					for iter.Next() {
+						samples += iter.Chunk().Size()
+					}
+					require.NoError(t, iter.Err())
+					assert.Equal(t, test.samples, samples)
+
+					skipped := iter.Skipped()
+					require.Len(t, skipped, len(test.skipped))
+					for idx := range skipped {
+						assert.Equal(t, test.skipped[idx].Offset, skipped[idx].Offset)
+						assert.Equal(t, test.skipped[idx].Size, skipped[idx].Size)
+						assert.True(t, test.skipped[idx].ID.Equal(skipped[idx].ID))
+						assert.Error(t, skipped[idx].Reason)
+						assert.Error(t, skipped[idx])
+						assert.Equal(t, skipped[idx].Reason, errors.Unwrap(skipped[idx]))
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestLenientReaderBuffer(t *testing.T) {
+	payload := produceTimedPayload(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), 100, 10)
+	data := append(append([]byte{0x00, 0x00, 0xf0, 0x00}, payload...), 0x00, 0x00, 0xf0, 0x00)
+
+	skipped := 0
+	r := newLenientReader(bytes.NewReader(data), func(*ChunkError) { skipped++ })
+
+	largest := 0
+	for {
+		doc, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if int(doc.size) > largest {
+			largest = int(doc.size)
+		}
+	}
+
+	assert.Equal(t, 2, skipped)
+	assert.LessOrEqual(t, cap(r.buf), lenientBufferSize+largest)
+}
+
+func TestReadTimestamps(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	collector := NewBatchCollector(10)
+	for i := 0; i < 25; i++ {
+		ts := start.Add(time.Duration(i) * time.Second)
+		require.NoError(t, collector.Add(birch.NewDocument(
+			birch.EC.Int64("counter", int64(i)),
+			birch.EC.SubDocumentFromElements("server",
+				birch.EC.Time("localTime", ts.Add(time.Hour)),
+			),
+			birch.EC.Time("start", ts),
+		)))
+	}
+	payload, err := collector.Resolve()
+	require.NoError(t, err)
+
+	t.Run("Chunks", func(t *testing.T) {
+		iter := ReadChunks(ctx, bytes.NewBuffer(payload))
+		defer iter.Close()
+
+		idx := 0
+		for iter.Next() {
+			chunk := iter.Chunk()
+			times := chunk.Timestamps()
+			require.Len(t, times, chunk.Size())
+			assert.Equal(t, times[0], chunk.StartTime())
+			assert.Equal(t, times[len(times)-1], chunk.EndTime())
+			for _, ts := range times {
+				assert.Equal(t, start.Add(time.Duration(idx)*time.Second+time.Hour), ts.UTC())
+				idx++
+			}
+		}
+		require.NoError(t, iter.Err())
+		assert.Equal(t, 25, idx)
+	})
+	t.Run("Key", func(t *testing.T) {
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{
+			TimestampKey: "start",
+			Start:        start.Add(5 * time.Second),
+			Include:      []string{"counter"},
+		})
+		defer iter.Close()
+
+		require.True(t, iter.Next())
+		chunk := iter.Chunk()
+		require.Len(t, chunk.Metrics, 1)
+		assert.Equal(t, start.Add(5*time.Second), chunk.Timestamps()[0].UTC())
+		assert.Equal(t, start.Add(9*time.Second), chunk.EndTime().UTC())
+		assert.EqualValues(t, 5, chunk.Metrics[0].Values[0])
+	})
+	t.Run("MissingKey", func(t *testing.T) {
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{TimestampKey: "counter"})
+		defer iter.Close()
+
+		require.True(t, iter.Next())
+		assert.Equal(t, start.Add(time.Hour), iter.Chunk().Timestamps()[0].UTC())
+	})
+	t.Run("NoTimestamps", func(t *testing.T) {
+		iter := ReadChunksWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{Include: []string{"counter"}})
+		defer iter.Close()
+
+		require.True(t, iter.Next())
+		chunk := iter.Chunk()
+		assert.Len(t, chunk.Timestamps(), 10)
+
+		chunk = &Chunk{Metrics: chunk.Metrics, nPoints: chunk.nPoints, id: chunk.id}
+		assert.Nil(t, chunk.Timestamps())
+		assert.Equal(t, chunk.StartTime(), chunk.EndTime())
+
+		sample := chunk.Iterator(ctx)
+		defer sample.Close()
+		require.True(t, sample.Next())
+		assert.True(t, sample.(TimestampIterator).Timestamp().IsZero())
+	})
+	t.Run("Iterators", func(t *testing.T) {
+		for name, iter := range map[string]Iterator{
+			"Metrics":           ReadMetricsWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{TimestampKey: "start"}),
+			"StructuredMetrics": ReadStructuredMetricsWithOptions(ctx, bytes.NewBuffer(payload), ReadOptions{TimestampKey: "start"}),
+		} {
+			t.Run(name, func(t *testing.T) {
+				defer iter.Close()
+				idx := 0
+				for iter.Next() {
+					assert.Equal(t, start.Add(time.Duration(idx)*time.Second), iter.(TimestampIterator).Timestamp().UTC())
+					idx++
+				}
+				require.NoError(t, iter.Err())
+				assert.Equal(t, 25, idx)
+			})
+		}
+
+		// chunks start at the time of the first date-time
+		// field, regardless of the key.
+		iter := ReadMatrix(ctx, bytes.NewBuffer(payload))
+		defer iter.Close()
+		idx := 0
+		for iter.Next() {
+			assert.Equal(t, start.Add(time.Duration(idx)*time.Second+time.Hour), iter.(TimestampIterator).Timestamp().UTC())
+			idx += 10
+		}
+		require.NoError(t, iter.Err())
+	})
+}
+
+func TestReadSynchronous(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	payload := produceTimedPayload(t, start, 100, 10)
+
+	corrupt := append([]byte{}, payload...)
+	copy(corrupt[len(corrupt)/2:], bytes.Repeat([]byte{0xff}, 64))
+
+	collect := func(t *testing.T, iter Iterator) []string {
+		defer iter.Close()
+		out := []string{}
+		for iter.Next() {
+			// the elements of series documents are not
+			// ordered, so compare them by key.
+			elems := map[string]string{}
+			for it := iter.Document().Iterator(); it.Next(); {
+				elems[it.Element().Key()] = it.Element().String()
+			}
+			out = append(out, fmt.Sprint(elems, iter.(TimestampIterator).Timestamp(), iter.Metadata() != nil))
+		}
+		require.NoError(t, iter.Err())
+		return out
+	}
+
+	for name, opts := range map[string]ReadOptions{
+		"Default":     {},
+		"TimeRange":   {Start: start.Add(15 * time.Second), End: start.Add(75 * time.Second)},
+		"Projection":  {Include: []string{"counter"}},
+		"SkipCorrupt": {SkipCorrupt: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data := payload
+			if opts.SkipCorrupt {
+				data = corrupt
+			}
+			syncOpts := opts
+			syncOpts.Synchronous = true
+
+			for kind, read := range map[string]func(context.Context, io.Reader, ReadOptions) Iterator{
+				"Metrics":           ReadMetricsWithOptions,
+				"StructuredMetrics": ReadStructuredMetricsWithOptions,
+				"Matrix":            ReadMatrixWithOptions,
+				"Series":            ReadSeriesWithOptions,
+			} {
+				t.Run(kind, func(t *testing.T) {
+					expected := collect(t, read(ctx, bytes.NewReader(data), opts))
+					require.NotEmpty(t, expected)
+
+					before := runtime.NumGoroutine()
+					iter := read(ctx, bytes.NewReader(data), syncOpts)
+					require.True(t, iter.Next())
+					assert.Equal(t, before, runtime.NumGoroutine())
+					iter.Close()
+
+					assert.Equal(t, expected, collect(t, read(ctx, bytes.NewReader(data), syncOpts)))
+				})
+			}
+
+			t.Run("Chunks", func(t *testing.T) {
+				async := ReadChunksWithOptions(ctx, bytes.NewReader(data), opts)
+				defer async.Close()
+				iter := ReadChunksWithOptions(ctx, bytes.NewReader(data), syncOpts)
+				defer iter.Close()
+
+				for async.Next() {
+					require.True(t, iter.Next())
+					assert.Equal(t, async.Chunk().Timestamps(), iter.Chunk().Timestamps())
+					assert.Equal(t, async.Chunk().Metrics, iter.Chunk().Metrics)
+				}
+				assert.False(t, iter.Next())
+				assert.False(t, iter.Next())
+				require.NoError(t, iter.Err())
+				expected, skipped := async.Skipped(), iter.Skipped()
+				require.Len(t, skipped, len(expected))
+				for idx := range expected {
+					assert.Equal(t, expected[idx].Error(), skipped[idx].Error())
+				}
+				assert.Equal(t, opts.SkipCorrupt, len(skipped) > 0)
+			})
+		})
+	}
+	t.Run("Errors", func(t *testing.T) {
+		iter := ReadChunksWithOptions(ctx, bytes.NewReader(payload), ReadOptions{Synchronous: true, Workers: 2})
+		assert.False(t, iter.Next())
+		assert.Error(t, iter.Err())
+
+		for _, read := range []func(context.Context, io.Reader, ReadOptions) Iterator{
+			ReadMetricsWithOptions,
+			ReadStructuredMetricsWithOptions,
+		} {
+			async := read(ctx, bytes.NewReader(corrupt), ReadOptions{})
+			expected := 0
+			for async.Next() {
+				expected++
+			}
+			assert.Error(t, async.Err())
+
+			iter := read(ctx, bytes.NewReader(corrupt), ReadOptions{Synchronous: true})
+			count := 0
+			for iter.Next() {
+				count++
+			}
+			assert.Error(t, iter.Err())
+			assert.Equal(t, expected, count)
+			assert.NotZero(t, count)
+		}
+	})
+	t.Run("Cancel", func(t *testing.T) {
+		cctx, ccancel := context.WithCancel(ctx)
+		iter := ReadMetricsWithOptions(cctx, bytes.NewReader(payload), ReadOptions{Synchronous: true})
+		require.True(t, iter.Next())
+		ccancel()
+		count := 0
+		for iter.Next() {
+			count++
+		}
+		// the rest of the current chunk has already been
+		// decoded.
+		assert.Equal(t, 9, count)
+		assert.NoError(t, iter.Err())
+
+		chunks := ReadChunksWithOptions(ctx, bytes.NewReader(payload), ReadOptions{Synchronous: true})
+		require.True(t, chunks.Next())
+		chunks.Close()
+		assert.False(t, chunks.Next())
+	})
+	t.Run("Directory", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"diagnostic.data/metrics.2020-01-01T00-00-00Z-00000": {Data: payload},
+			"diagnostic.data/metrics.2020-01-01T00-01-00Z-00000": {Data: []byte("corrupt")},
+			"diagnostic.data/metrics.interim":                    {Data: produceTimedPayload(t, start.Add(90*time.Second), 20, 10)},
+		}
+
+		before := runtime.NumGoroutine()
+		iter := ReadDirectoryFS(ctx, fsys, "diagnostic.data", ReadOptions{Synchronous: true})
+		count := 0
+		for iter.Next() {
+			assert.Equal(t, before, runtime.NumGoroutine())
+			count += iter.Chunk().Size()
+		}
+		assert.Equal(t, 110, count)
+		assert.Error(t, iter.Err())
+		iter.Close()
+
+		docs := ReadDirectoryMetricsFS(ctx, fsys, "diagnostic.data", ReadOptions{Synchronous: true, Start: start.Add(95 * time.Second)})
+		count = 0
+		for docs.Next() {
+			count++
+		}
+		assert.Equal(t, 15, count)
+		docs.Close()
+	})
+}
@@ -0,0 +1,255 @@
+package ftdc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/evergreen-ci/birch/bsontype"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mongodb/ftdc/util"
+	"github.com/pkg/errors"
+)
+
+// RepairReport describes the damage that Recover or Repair removed
+// from an FTDC source.
+type RepairReport struct {
+	// Size is the size of the source, in bytes.
+	Size int64
+
+	// BytesLost is the number of bytes that were removed from the
+	// source.
+	BytesLost int64
+
+	// SamplesLost is the number of samples in the chunks that were
+	// removed. Samples are only counted when the beginning of the
+	// chunk's payload can still be decompressed, which is rarely
+	// the case for zstd payloads, so this is a lower bound.
+	SamplesLost int
+
+	// Damaged describes each chunk, or region of the source, that
+	// was removed, in the order in which they appear.
+	Damaged []*ChunkError
+}
+
+// Recover writes the valid documents from an FTDC source to the
+// writer, omitting truncated or malformed documents, and chunks that
+// can't be decoded, and reports what was lost. The typical damage is
+// a document that a process did not finish writing when it stopped.
+func Recover(r io.ReaderAt, size int64, w io.Writer) (RepairReport, error) {
+	report := RepairReport{Size: size}
+
+	next := newLenientReader(io.NewSectionReader(r, 0, size), func(chunkErr *ChunkError) {
+		report.Damaged = append(report.Damaged, chunkErr)
+	})
+
+	for {
+		source, err := next.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return RepairReport{}, errors.Wrap(err, "problem reading source")
+		}
+
+		if isNum(1, source.doc.Lookup("type")) {
+			if err = validateChunkDocument(source.doc); err != nil {
+				id, _ := source.doc.Lookup("_id").TimeOK()
+				report.Damaged = append(report.Damaged, &ChunkError{
+					Offset: source.offset,
+					Size:   source.size,
+					ID:     id,
+					Reason: err,
+				})
+				continue
+			}
+		}
+
+		if _, err = source.doc.WriteTo(w); err != nil {
+			return RepairReport{}, errors.Wrap(err, "problem writing document")
+		}
+	}
+
+	for _, damaged := range report.Damaged {
+		report.BytesLost += damaged.Size
+
+		data := make([]byte, min(damaged.Size, maxDocumentSize))
+		n, err := r.ReadAt(data, damaged.Offset)
+		if err != nil && err != io.EOF {
+			return RepairReport{}, errors.Wrap(err, "problem reading damaged region")
+		}
+		report.SamplesLost += chunkSampleCount(data[:n])
+	}
+
+	return report, nil
+}
+
+// Repair removes damaged documents from the FTDC file, as Recover
+// does. When the only damage is at the end of the file, the file is
+// truncated, so that it can be appended to (see
+// NewAppendingCollector); otherwise the valid documents are written to
+// a temporary file that replaces the original. Intact files are not
+// modified.
+func Repair(path string) (RepairReport, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return RepairReport{}, errors.Wrapf(err, "problem opening '%s'", path)
+	}
+
+	report, err := repairFile(file, path)
+	if err != nil {
+		catcher := util.NewCatcher()
+		catcher.Add(err)
+		catcher.Add(file.Close())
+		return RepairReport{}, catcher.Resolve()
+	}
+
+	return report, errors.Wrapf(file.Close(), "problem closing '%s'", path)
+}
+
+func repairFile(file *os.File, path string) (RepairReport, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return RepairReport{}, errors.WithStack(err)
+	}
+
+	report, err := Recover(file, info.Size(), io.Discard)
+	if err != nil {
+		return RepairReport{}, errors.Wrapf(err, "problem scanning '%s'", path)
+	}
+
+	if len(report.Damaged) == 0 {
+		return report, nil
+	}
+
+	if tail := report.Damaged[0]; len(report.Damaged) == 1 && tail.Offset+tail.Size == info.Size() {
+		if err = file.Truncate(tail.Offset); err != nil {
+			return RepairReport{}, errors.Wrapf(err, "problem truncating '%s'", path)
+		}
+		return report, errors.Wrapf(file.Sync(), "problem syncing '%s'", path)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return RepairReport{}, errors.Wrap(err, "problem creating temporary file")
+	}
+
+	catcher := util.NewCatcher()
+	buf := bufio.NewWriter(tmp)
+	_, err = Recover(file, info.Size(), buf)
+	catcher.Add(err)
+	catcher.Add(buf.Flush())
+	catcher.Add(tmp.Sync())
+	catcher.Add(tmp.Close())
+	if !catcher.HasErrors() {
+		catcher.Add(os.Rename(tmp.Name(), path))
+	}
+	if catcher.HasErrors() {
+		catcher.Add(os.Remove(tmp.Name()))
+		return RepairReport{}, errors.Wrapf(catcher.Resolve(), "problem rewriting '%s'", path)
+	}
+
+	return report, nil
+}
+
+// chunkSampleCount returns the number of samples in a metric chunk,
+// from the beginning of the chunk document, which may be truncated:
+// the header of the payload, which holds the number of samples,
+// follows the reference document at the beginning of the payload.
+// Returns zero when the data does not begin with a chunk document,
+// or the header didn't survive.
+func chunkSampleCount(data []byte) int {
+	if len(data) < minDocumentSize {
+		return 0
+	}
+
+	// the chunk document is parsed by hand, rather than with
+	// birch, because the end of the document may be missing.
+	var (
+		payload []byte
+		isChunk bool
+		pos     = 4
+	)
+	remaining := func(n int) []byte {
+		if end := pos + n; end < len(data) {
+			return data[pos:end]
+		}
+		return data[pos:]
+	}
+	for pos < len(data) && data[pos] != 0 && payload == nil {
+		elemType := bsontype.Type(data[pos])
+		end := bytes.IndexByte(data[pos+1:], 0)
+		if end < 0 {
+			break
+		}
+		key := string(data[pos+1 : pos+1+end])
+		pos += end + 2
+
+		var size int
+		switch elemType {
+		case bsontype.DateTime, bsontype.Int64, bsontype.Double:
+			size = 8
+		case bsontype.Int32:
+			size = 4
+			if key == "type" {
+				value := remaining(size)
+				isChunk = len(value) == 4 && binary.LittleEndian.Uint32(value) == 1
+			}
+		case bsontype.Binary:
+			header := remaining(5)
+			if len(header) < 5 {
+				return 0
+			}
+			pos += 5
+			size = int(int32(binary.LittleEndian.Uint32(header)))
+			if key == "data" {
+				payload = remaining(size)
+			}
+		default:
+			return 0
+		}
+		pos += size
+	}
+
+	// the first 4 bytes of the payload are its uncompressed size.
+	if !isChunk || len(payload) < 8 {
+		return 0
+	}
+	payload = payload[4:]
+
+	// the codec is recorded after the payload, which may not have
+	// survived, so it is detected from the payload itself.
+	var plain io.Reader
+	if bytes.HasPrefix(payload, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		z, err := zstd.NewReader(bytes.NewReader(payload), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxChunkDataSize))
+		if err != nil {
+			return 0
+		}
+		defer z.Close()
+		plain = z
+	} else {
+		z, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return 0
+		}
+		defer z.Close()
+		plain = z
+	}
+
+	buf := bufio.NewReader(plain)
+	if _, err := readBufBSON(buf); err != nil {
+		return 0
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(buf, header); err != nil {
+		return 0
+	}
+
+	// the reference document is a sample, as well as each delta.
+	return int(binary.LittleEndian.Uint32(header[4:])) + 1
+}
@@ -0,0 +1,125 @@
+package ftdc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/birch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepair(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// writeChunks returns three chunks of ten samples each, and
+	// the offset of each chunk.
+	writeChunks := func(t *testing.T, opts CollectorOptions) ([]byte, []int) {
+		buf := &bytes.Buffer{}
+		offsets := []int{}
+		for chunk := 0; chunk < 3; chunk++ {
+			collector, err := NewBaseCollectorWithOptions(100, opts)
+			require.NoError(t, err)
+			for i := chunk * 10; i < (chunk+1)*10; i++ {
+				require.NoError(t, collector.Add(birch.NewDocument(
+					birch.EC.Time("ts", start.Add(time.Duration(i)*time.Second)),
+					birch.EC.Int64("counter", int64(i)),
+				)))
+			}
+			out, err := collector.Resolve()
+			require.NoError(t, err)
+			offsets = append(offsets, buf.Len())
+			_, _ = buf.Write(out)
+		}
+		return buf.Bytes(), offsets
+	}
+
+	t.Run("Intact", func(t *testing.T) {
+		data, _ := writeChunks(t, CollectorOptions{})
+		path := filepath.Join(t.TempDir(), "metrics.ftdc")
+		require.NoError(t, os.WriteFile(path, data, 0644))
+
+		report, err := Repair(path)
+		require.NoError(t, err)
+		assert.Equal(t, RepairReport{Size: int64(len(data))}, report)
+
+		after, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, after)
+	})
+	t.Run("TruncatedTail", func(t *testing.T) {
+		data, offsets := writeChunks(t, CollectorOptions{})
+		path := filepath.Join(t.TempDir(), "metrics.ftdc")
+		require.NoError(t, os.WriteFile(path, data[:len(data)-8], 0644))
+
+		report, err := Repair(path)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)-8), report.Size)
+		assert.Equal(t, int64(len(data)-8-offsets[2]), report.BytesLost)
+		assert.Equal(t, 10, report.SamplesLost)
+		require.Len(t, report.Damaged, 1)
+		assert.Equal(t, int64(offsets[2]), report.Damaged[0].Offset)
+
+		after, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data[:offsets[2]], after)
+		assert.Equal(t, counterRange(0, 20), readCounters(t, after))
+	})
+	t.Run("TruncatedHeader", func(t *testing.T) {
+		// too little of the last chunk survived to count its
+		// samples.
+		data, offsets := writeChunks(t, CollectorOptions{})
+		path := filepath.Join(t.TempDir(), "metrics.ftdc")
+		require.NoError(t, os.WriteFile(path, data[:offsets[2]+12], 0644))
+
+		report, err := Repair(path)
+		require.NoError(t, err)
+		assert.Equal(t, int64(12), report.BytesLost)
+		assert.Zero(t, report.SamplesLost)
+	})
+	t.Run("DamagedMiddle", func(t *testing.T) {
+		data, offsets := writeChunks(t, CollectorOptions{})
+		path := filepath.Join(t.TempDir(), "metrics.ftdc")
+
+		// the end of the second chunk is overwritten, so it is
+		// still a document, but its payload can't be decoded.
+		damaged := append([]byte{}, data...)
+		copy(damaged[offsets[2]-24:], bytes.Repeat([]byte{0xff}, 16))
+		require.NoError(t, os.WriteFile(path, damaged, 0644))
+
+		report, err := Repair(path)
+		require.NoError(t, err)
+		assert.Equal(t, int64(offsets[2]-offsets[1]), report.BytesLost)
+		assert.Equal(t, 10, report.SamplesLost)
+		require.Len(t, report.Damaged, 1)
+		assert.Equal(t, start.Add(10*time.Second), report.Damaged[0].ID.UTC())
+
+		after, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, append(counterRange(0, 10), counterRange(20, 30)...), readCounters(t, after))
+
+		matches, err := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp"))
+		require.NoError(t, err)
+		assert.Empty(t, matches)
+	})
+	t.Run("Recover", func(t *testing.T) {
+		data, offsets := writeChunks(t, CollectorOptions{})
+
+		// the end of the second chunk is missing.
+		damaged := append(append([]byte{}, data[:offsets[2]-8]...), data[offsets[2]:]...)
+
+		out := &bytes.Buffer{}
+		report, err := Recover(bytes.NewReader(damaged), int64(len(damaged)), out)
+		require.NoError(t, err)
+		assert.Equal(t, int64(offsets[2]-8-offsets[1]), report.BytesLost)
+		assert.Equal(t, 10, report.SamplesLost)
+		assert.Equal(t, append(counterRange(0, 10), counterRange(20, 30)...), readCounters(t, out.Bytes()))
+	})
+	t.Run("Missing", func(t *testing.T) {
+		_, err := Repair(filepath.Join(t.TempDir(), "metrics.ftdc"))
+		assert.Error(t, err)
+	})
+}
@@ -5,16 +5,34 @@ import (
 	"compress/zlib"
 	"encoding/binary"
 	"math"
+	"reflect"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/evergreen-ci/birch"
 	"github.com/evergreen-ci/birch/bsontype"
+	"github.com/evergreen-ci/birch/decimal"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 func readDocument(in interface{}) (*birch.Document, error) {
+	return marshalDocument(in, CollectorOptions{})
+}
+
+// readSample converts a sample to a document, as readDocument, but
+// records times at nanosecond precision, and unsigned integers, when
+// the options specify.
+func readSample(in interface{}, opts CollectorOptions) (*birch.Document, error) {
+	if doc, ok := in.(NanosecondDocumentMarshaler); ok && opts.NanosecondTimes {
+		return doc.MarshalDocumentNanoseconds()
+	}
+
+	return marshalDocument(in, opts)
+}
+
+func marshalDocument(in interface{}, opts CollectorOptions) (*birch.Document, error) {
 	switch doc := in.(type) {
 	case *birch.Document:
 		return doc, nil
@@ -28,21 +46,113 @@ func readDocument(in interface{}) (*birch.Document, error) {
 			return nil, errors.Wrap(err, "problem with unmarshaler")
 		}
 		return birch.ReadDocument(data)
-	case map[string]interface{}, map[string]int, map[string]int64, map[string]uint, map[string]uint64:
+	case map[string]uint:
+		if opts.UnsignedIntegers {
+			elems := make(birch.Elements, 0, len(doc))
+			for key, value := range doc {
+				elems = append(elems, Uint64(key, uint64(value)))
+			}
+			sort.Stable(elems)
+			return birch.DC.Elements(elems...), nil
+		}
+		elems := birch.DC.Interface(doc).Elements()
+		sort.Stable(elems)
+		return birch.DC.Elements(elems...), nil
+	case map[string]uint64:
+		if opts.UnsignedIntegers {
+			elems := make(birch.Elements, 0, len(doc))
+			for key, value := range doc {
+				elems = append(elems, Uint64(key, value))
+			}
+			sort.Stable(elems)
+			return birch.DC.Elements(elems...), nil
+		}
+		elems := birch.DC.Interface(doc).Elements()
+		sort.Stable(elems)
+		return birch.DC.Elements(elems...), nil
+	case map[string]interface{}, map[string]int, map[string]int64:
 		elems := birch.DC.Interface(doc).Elements()
 		sort.Stable(elems)
 		return birch.DC.Elements(elems...), nil
 	case map[string]string:
 		return nil, errors.New("cannot use string maps for metrics documents")
 	default:
-		data, err := bson.Marshal(in)
-		if err != nil {
+		buf := &bytes.Buffer{}
+		enc := bson.NewEncoder(bson.NewDocumentWriter(buf))
+		enc.SetRegistry(metricsRegistries[metricsRegistryKey{
+			nanoseconds: opts.NanosecondTimes,
+			unsigned:    opts.UnsignedIntegers,
+		}])
+		if err := enc.Encode(in); err != nil {
 			return nil, errors.Wrap(err, "problem with fallback marshaling")
 		}
-		return birch.ReadDocument(data)
+		return birch.ReadDocument(buf.Bytes())
 	}
 }
 
+type metricsRegistryKey struct {
+	nanoseconds bool
+	unsigned    bool
+}
+
+// metricsRegistries are the registries used to marshal values that
+// are not documents, for each combination of the collector options
+// that change how values are marshaled. The registry for the default
+// options is the driver's default registry.
+var metricsRegistries = func() map[metricsRegistryKey]*bson.Registry {
+	out := map[metricsRegistryKey]*bson.Registry{}
+	for _, nanoseconds := range []bool{false, true} {
+		for _, unsigned := range []bool{false, true} {
+			out[metricsRegistryKey{nanoseconds: nanoseconds, unsigned: unsigned}] = newMetricsRegistry(nanoseconds, unsigned)
+		}
+	}
+	return out
+}()
+
+// newMetricsRegistry returns a registry that writes times at
+// nanosecond precision (see NanosecondTime), and uint and uint64
+// values as unsigned integers (see Uint64), when specified.
+func newMetricsRegistry(nanoseconds, unsigned bool) *bson.Registry {
+	registry := bson.NewRegistry()
+
+	if nanoseconds {
+		registry.RegisterTypeEncoder(reflect.TypeOf(time.Time{}), bson.ValueEncoderFunc(func(_ bson.EncodeContext, vw bson.ValueWriter, val reflect.Value) error {
+			return writeMarkerDocument(vw, nanosecondTimeKey, val.Interface().(time.Time).UnixNano())
+		}))
+	}
+
+	if unsigned {
+		encoder := bson.ValueEncoderFunc(func(_ bson.EncodeContext, vw bson.ValueWriter, val reflect.Value) error {
+			return writeMarkerDocument(vw, unsignedIntegerKey, int64(val.Uint()))
+		})
+		registry.RegisterKindEncoder(reflect.Uint, encoder)
+		registry.RegisterKindEncoder(reflect.Uint64, encoder)
+	}
+
+	return registry
+}
+
+// writeMarkerDocument writes a document with a single int64 field,
+// which records a value that BSON cannot represent (see
+// markerDocumentValue).
+func writeMarkerDocument(vw bson.ValueWriter, key string, value int64) error {
+	dw, err := vw.WriteDocument()
+	if err != nil {
+		return err
+	}
+
+	ew, err := dw.WriteDocumentElement(key)
+	if err != nil {
+		return err
+	}
+
+	if err = ew.WriteInt64(value); err != nil {
+		return err
+	}
+
+	return dw.WriteDocumentEnd()
+}
+
 func getOffset(count, sample, metric int) int { return metric*count + sample }
 
 func undelta(value int64, deltas []int64) []int64 {
@@ -95,6 +205,31 @@ func restoreFloat(in int64) float64   { return math.Float64frombits(uint64(in))
 func epochMs(t time.Time) int64       { return t.UnixNano() / 1000000 }
 func timeEpocMs(in int64) time.Time   { return time.Unix(in/1000, in%1000*1000000) }
 
+// decimalValue returns the value of a Decimal128 value. The birch
+// library writes the low half of decimals first, as BSON requires,
+// but reads the high half first, so the halves are swapped.
+func decimalValue(val *birch.Value) decimal.Decimal128 {
+	high, low := val.Decimal128().GetBytes()
+	return decimal.NewDecimal128(low, high)
+}
+
+// decimalToFloat returns the closest float64 to the decimal, which is
+// how Decimal128 metrics are recorded.
+func decimalToFloat(in decimal.Decimal128) float64 {
+	value, err := strconv.ParseFloat(in.String(), 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return math.NaN()
+	}
+	return value
+}
+
+// floatToDecimal converts a Decimal128 metric value back to a
+// decimal.
+func floatToDecimal(in float64) decimal.Decimal128 {
+	out, _ := decimal.ParseDecimal128(strconv.FormatFloat(in, 'g', -1, 64))
+	return out
+}
+
 func isNum(num int, val *birch.Value) bool {
 	if val == nil {
 		return false
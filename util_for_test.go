@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"context"
 	"math/rand"
+	"os"
+	"testing"
 	"time"
 
 	"github.com/evergreen-ci/birch"
 	"github.com/mongodb/ftdc/testutil"
 	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
 )
 
 type customCollector struct {
@@ -107,6 +110,13 @@ func createCollectors(ctx context.Context) []*customCollector {
 				return NewBufferedCollector(ctx, 0, NewSynchronizedCollector(NewBaseCollector(1000)))
 			},
 		},
+		{
+			name: "Sharded",
+			factory: func() Collector {
+				collector, _ := NewShardedCollector(NewBaseCollector(1000), ShardedOptions{Shards: 4})
+				return collector
+			},
+		},
 		{
 			name:      "SmallBatch",
 			factory:   func() Collector { return NewBatchCollector(10) },
@@ -175,6 +185,38 @@ func createCollectors(ctx context.Context) []*customCollector {
 			name:    "LargeStreamingDynamic",
 			factory: func() Collector { return NewStreamingDynamicCollector(10000, &bytes.Buffer{}) },
 		},
+		{
+			name: "ZstdBatch",
+			factory: func() Collector {
+				collector, _ := NewBatchCollectorWithOptions(100, CollectorOptions{Compression: CompressionZstd})
+				return collector
+			},
+			skipBench: true,
+		},
+		{
+			name: "ZlibBestSpeedDynamic",
+			factory: func() Collector {
+				collector, _ := NewDynamicCollectorWithOptions(100, CollectorOptions{CompressionLevel: 1})
+				return collector
+			},
+			skipBench: true,
+		},
+		{
+			name: "ZstdStreamingDynamic",
+			factory: func() Collector {
+				collector, _ := NewStreamingDynamicCollectorWithOptions(100, &bytes.Buffer{}, CollectorOptions{Compression: CompressionZstd, CompressionLevel: 19})
+				return collector
+			},
+		},
+		{
+			name:      "SmallUnion",
+			factory:   func() Collector { return NewUnionCollector(10) },
+			skipBench: true,
+		},
+		{
+			name:    "MediumUnion",
+			factory: func() Collector { return NewUnionCollector(100) },
+		},
 		{
 			name:         "UncompressedSmallJSON",
 			factory:      func() Collector { return NewUncompressedCollectorJSON(10) },
@@ -567,3 +609,45 @@ func (m *marshaler) MarshalBSON() ([]byte, error) {
 	}
 	return m.doc.MarshalBSON()
 }
+
+// counterRange returns the counters from first up to, but not
+// including, last, which are the values of the "counter" field of the
+// samples that the collector tests add.
+func counterRange(first, last int) []int64 {
+	out := make([]int64, 0, last-first)
+	for i := first; i < last; i++ {
+		out = append(out, int64(i))
+	}
+	return out
+}
+
+// readCounters returns the "counter" field of every sample in the
+// FTDC data.
+func readCounters(t *testing.T, data []byte) []int64 {
+	out := []int64{}
+	for doc, err := range StructuredSamples(context.Background(), bytes.NewReader(data)) {
+		require.NoError(t, err)
+		out = append(out, doc.Lookup("counter").Int64())
+	}
+	return out
+}
+
+// readFileCounters returns the "counter" field of every sample in the
+// FTDC file.
+func readFileCounters(t *testing.T, path string) []int64 {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return readCounters(t, data)
+}
+
+// readIteratorCounters returns the "counter" field of every document
+// that the iterator returns, and closes the iterator.
+func readIteratorCounters(t *testing.T, iter Iterator) []int64 {
+	defer iter.Close()
+	out := []int64{}
+	for iter.Next() {
+		out = append(out, iter.Document().Lookup("counter").Int64())
+	}
+	require.NoError(t, iter.Err())
+	return out
+}
@@ -17,7 +17,7 @@ func NewWriterCollector(chunkSize int, writer io.WriteCloser) io.WriteCloser {
 		writer: writer,
 		collector: &streamingDynamicCollector{
 			output:             writer,
-			streamingCollector: newStreamingCollector(chunkSize, writer),
+			streamingCollector: newStreamingCollector(chunkSize, writer, CollectorOptions{}),
 		},
 	}
 }